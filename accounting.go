@@ -0,0 +1,35 @@
+package replicache
+
+import (
+	"context"
+	"io"
+)
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readCloser reads from one reader and closes another, for wrapping a
+// request body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (rep *Replicache) accountPushSize(ctx context.Context, clientGroupID string, contentLength, read int64) {
+	if rep.pushSizeAccounting == nil {
+		return
+	}
+	if contentLength < 0 {
+		contentLength = read
+	}
+	rep.pushSizeAccounting(ctx, clientGroupID, contentLength)
+}
@@ -0,0 +1,52 @@
+package replicache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrAnonymousProfile is returned when a request without a profileID is
+// rejected by AnonymousReject.
+var ErrAnonymousProfile = errors.New("replicache: anonymous profile not allowed")
+
+// AnonymousPolicy decides what happens to requests with an empty profileID.
+type AnonymousPolicy int
+
+const (
+	// AnonymousAllow passes the empty profileID through to the handler. This
+	// is the default. Handlers must not rely on ProfileID to partition data
+	// since every anonymous client shares the same empty value.
+	AnonymousAllow AnonymousPolicy = iota
+
+	// AnonymousReject responds 401 to requests with an empty profileID.
+	AnonymousReject
+
+	// AnonymousAssign replaces an empty profileID with an ID derived from the
+	// client group, so data for anonymous users still partitions per client
+	// group. The ID is stable but not secret: anyone who knows a
+	// clientGroupID can compute it, so it must never be treated as proof of
+	// identity.
+	AnonymousAssign
+)
+
+// anonymousProfileID derives a stable profile ID for an anonymous client group.
+func anonymousProfileID(clientGroupID string) string {
+	sum := sha256.Sum256([]byte(clientGroupID))
+	return "anon-" + hex.EncodeToString(sum[:16])
+}
+
+// applyAnonymousPolicy rejects or fills in an empty profileID according to
+// the configured policy.
+func (rep *Replicache) applyAnonymousPolicy(info *ClientInfo) error {
+	if info.ProfileID != "" {
+		return nil
+	}
+	switch rep.anonymousPolicy {
+	case AnonymousReject:
+		return ErrAnonymousProfile
+	case AnonymousAssign:
+		info.ProfileID = anonymousProfileID(info.ClientGroupID)
+	}
+	return nil
+}
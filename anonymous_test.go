@@ -0,0 +1,66 @@
+package replicache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAnonymousPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		policy      AnonymousPolicy
+		wantStatus  int
+		wantProfile string
+	}{
+		{"allow", AnonymousAllow, http.StatusOK, ""},
+		{"reject", AnonymousReject, http.StatusUnauthorized, ""},
+		{"assign", AnonymousAssign, http.StatusOK, anonymousProfileID("group")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, db := newFakeDB(t)
+			var profiles []string
+			rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+				profiles = append(profiles, pr.ProfileID)
+				return nil
+			}}, WithClientOnPush(true), WithAnonymousPolicy(tc.policy))
+
+			req := pushRequest("group", mutation("client", 1, "m"))
+			req.ProfileID = ""
+			w := post(t, rep.PushHandler(), req)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("push status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				if len(profiles) != 0 {
+					t.Error("handler ran for a rejected push")
+				}
+				return
+			}
+			if len(profiles) != 1 || profiles[0] != tc.wantProfile {
+				t.Errorf("handler saw profiles %q, want %q", profiles, tc.wantProfile)
+			}
+		})
+	}
+}
+
+func TestAnonymousProfileID(t *testing.T) {
+	id := anonymousProfileID("group")
+	if !strings.HasPrefix(id, "anon-") {
+		t.Errorf("anonymous profile ID %q lacks the anon- prefix", id)
+	}
+	if anonymousProfileID("group") != id {
+		t.Error("anonymous profile ID isn't stable")
+	}
+	if anonymousProfileID("other") == id {
+		t.Error("client groups share an anonymous profile ID")
+	}
+
+	// a request with a profileID is never changed
+	rep := &Replicache{anonymousPolicy: AnonymousAssign}
+	info := ClientInfo{ClientGroupID: "group", ProfileID: "profile"}
+	if err := rep.applyAnonymousPolicy(&info); err != nil || info.ProfileID != "profile" {
+		t.Errorf("applyAnonymousPolicy = %v, profile %q", err, info.ProfileID)
+	}
+}
@@ -0,0 +1,231 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrCircuitOpen is returned without touching the database while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("replicache: database circuit breaker is open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	logger    *slog.Logger
+	clock     clock
+
+	mu           sync.Mutex
+	state        CircuitState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+	opens        int64
+}
+
+// allow reports whether a request may use the database. When the breaker is
+// open it returns the time remaining in the cool-down period.
+func (b *circuitBreaker) allow() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		remaining := b.cooldown - b.clock.Now().Sub(b.openedAt)
+		if remaining > 0 {
+			return remaining, false
+		}
+		b.transition(CircuitHalfOpen)
+		b.probing = true
+		return 0, true
+	case CircuitHalfOpen:
+		// only one probe at a time while half-open
+		if b.probing {
+			return b.cooldown, false
+		}
+		b.probing = true
+		return 0, true
+	default:
+		return 0, true
+	}
+}
+
+// record updates the breaker with the result of a BeginTx or Commit. Errors
+// that aren't caused by the database infrastructure count as successes so
+// that handler errors can't trip the breaker.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isInfrastructureError(err) {
+		b.probing = false
+		b.failures = 0
+		if b.state != CircuitClosed {
+			b.transition(CircuitClosed)
+		}
+		return
+	}
+
+	now := b.clock.Now()
+	switch b.state {
+	case CircuitHalfOpen:
+		b.probing = false
+		b.openedAt = now
+		b.transition(CircuitOpen)
+	case CircuitClosed:
+		if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+			b.failures = 0
+			b.firstFailure = now
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openedAt = now
+			b.transition(CircuitOpen)
+		}
+	}
+}
+
+func (b *circuitBreaker) transition(to CircuitState) {
+	b.logger.Warn("replicache circuit breaker state change", slog.String("from", b.state.String()), slog.String("to", to.String()))
+	if to == CircuitOpen {
+		b.opens++
+	}
+	if to == CircuitClosed {
+		b.failures = 0
+	}
+	b.state = to
+}
+
+func (b *circuitBreaker) snapshot() (CircuitState, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.opens
+}
+
+// breakerSet holds one circuit breaker per database, so a failing tenant
+// database routed by a DBRouter doesn't cut off the others.
+type breakerSet struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	logger    *slog.Logger
+	clock     clock
+
+	mu   sync.Mutex
+	byDB map[*sql.DB]*circuitBreaker
+}
+
+// forDB returns the breaker of db, creating it on first use.
+func (s *breakerSet) forDB(db *sql.DB) *circuitBreaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.byDB[db]
+	if !ok {
+		b = &circuitBreaker{
+			threshold: s.threshold,
+			window:    s.window,
+			cooldown:  s.cooldown,
+			logger:    s.logger,
+			clock:     s.clock,
+		}
+		s.byDB[db] = b
+	}
+	return b
+}
+
+// snapshot returns the most severe state of any breaker, open before
+// half-open before closed, and the opens of all breakers.
+func (s *breakerSet) snapshot() (CircuitState, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	worst, opens := CircuitClosed, int64(0)
+	for _, b := range s.byDB {
+		state, n := b.snapshot()
+		opens += n
+		if state == CircuitOpen || state == CircuitHalfOpen && worst == CircuitClosed {
+			worst = state
+		}
+	}
+	return worst, opens
+}
+
+// CircuitOpenError carries how long clients should wait before retrying.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string { return ErrCircuitOpen.Error() }
+
+func (e *CircuitOpenError) Unwrap() error { return ErrCircuitOpen }
+
+func (rep *Replicache) beginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if rep.breaker == nil {
+		return rep.db.BeginTx(ctx, opts)
+	}
+	if retryAfter, ok := rep.breaker.allow(); !ok {
+		return nil, &CircuitOpenError{RetryAfter: retryAfter}
+	}
+	tx, err := rep.db.BeginTx(ctx, opts)
+	rep.breaker.record(err)
+	return tx, err
+}
+
+func (rep *Replicache) commit(tx *sql.Tx) error {
+	err := tx.Commit()
+	if rep.breaker != nil {
+		rep.breaker.record(err)
+	}
+	return err
+}
+
+// isInfrastructureError reports whether err indicates the database could not
+// be reached or refused the connection, as opposed to a failed query.
+func isInfrastructureError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		code := stateErr.SQLState()
+		// class 08 connection exceptions, class 53 insufficient resources
+		// (too many connections) and 57P0x operator intervention (shutdown)
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "53") || strings.HasPrefix(code, "57P0")
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package replicache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	f, db := newFakeDB(t)
+	clock := newFakeClock()
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithCircuitBreaker(2, time.Minute, 10*time.Second),
+		withClock(clock),
+	)
+	push := func(id int) int {
+		return post(t, rep.PushHandler(), pushRequest("group", mutation("client", id, "m"))).Code
+	}
+
+	f.failBegin(sqlStateError("08006"))
+	push(1)
+	push(1)
+	if state, opens := rep.breaker.snapshot(); state != CircuitOpen || opens != 1 {
+		t.Fatalf("breaker %v after %d opens, want open once", state, opens)
+	}
+
+	// refused without touching the database during the cool-down
+	f.failBegin(nil)
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "10" {
+		t.Errorf("push while open got %d, Retry-After %q, want 503 after 10s", w.Code, w.Header().Get("Retry-After"))
+	}
+	if begins, _, _ := f.txCounts(); begins != 0 {
+		t.Errorf("%d transactions began while the breaker was open", begins)
+	}
+
+	// a failed probe reopens it
+	clock.Advance(10 * time.Second)
+	f.failBegin(sqlStateError("08006"))
+	push(1)
+	if state, _ := rep.breaker.snapshot(); state != CircuitOpen {
+		t.Fatalf("breaker %v after a failed probe, want open", state)
+	}
+
+	// a successful probe closes it
+	clock.Advance(10 * time.Second)
+	f.failBegin(nil)
+	if code := push(1); code != http.StatusOK {
+		t.Errorf("probe push status = %d, want %d", code, http.StatusOK)
+	}
+	if state, _ := rep.breaker.snapshot(); state != CircuitClosed {
+		t.Errorf("breaker %v after a successful probe, want closed", state)
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	f, db := newFakeDB(t)
+	clock := newFakeClock()
+	rep := newTestReplicache(t, db, testHandler{},
+		WithCircuitBreaker(2, time.Minute, 10*time.Second),
+		withClock(clock),
+	)
+
+	// failures further apart than the window don't add up
+	f.failBegin(sqlStateError("08006"))
+	post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+	clock.Advance(2 * time.Minute)
+	post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+	if state, _ := rep.breaker.snapshot(); state != CircuitClosed {
+		t.Errorf("breaker %v, want closed", state)
+	}
+}
@@ -0,0 +1,227 @@
+package replicache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CanonicalJSON re-encodes raw with object keys sorted, no insignificant
+// whitespace and a single formatting for each number, so that equal values
+// encode to equal bytes regardless of how they were stored. Integers keep
+// full precision. Objects with duplicate keys are rejected.
+func CanonicalJSON(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := canonicalValue(dec, &buf); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("replicache: unexpected data after JSON value")
+	}
+	return buf.Bytes(), nil
+}
+
+// CanonicalizeArgs returns the CanonicalJSON form of mutation args, so args
+// that differ only in whitespace, key order or number formatting compare and
+// hash equal.
+func CanonicalizeArgs(args json.RawMessage) (json.RawMessage, error) {
+	return CanonicalJSON(args)
+}
+
+// canonicalizeMutations replaces the args of every mutation with their
+// canonical form when WithCanonicalizeArgs is set. mutations is not
+// modified.
+func (rep *Replicache) canonicalizeMutations(mutations []Mutation) ([]Mutation, error) {
+	if !rep.canonicalizeArgs {
+		return mutations, nil
+	}
+	canonical := make([]Mutation, len(mutations))
+	for i, m := range mutations {
+		if len(m.Args) > 0 {
+			args, err := CanonicalizeArgs(m.Args)
+			if err != nil {
+				return nil, fmt.Errorf("replicache: mutation %d of client %s: %w", m.ID, m.ClientID, err)
+			}
+			m.Args = args
+		}
+		canonical[i] = m
+	}
+	return canonical, nil
+}
+
+func canonicalValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			return canonicalArray(dec, buf)
+		}
+		return canonicalObject(dec, buf)
+	case string:
+		return writeJSONString(buf, t)
+	case json.Number:
+		return writeNumber(buf, t)
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func canonicalArray(dec *json.Decoder, buf *bytes.Buffer) error {
+	buf.WriteByte('[')
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := canonicalValue(dec, buf); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func canonicalObject(dec *json.Decoder, buf *bytes.Buffer) error {
+	members := make(map[string][]byte)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if _, ok := members[key]; ok {
+			return fmt.Errorf("replicache: duplicate object key %q", key)
+		}
+		var value bytes.Buffer
+		if err := canonicalValue(dec, &value); err != nil {
+			return err
+		}
+		members[key] = value.Bytes()
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(members))
+	for key := range members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSONString(buf, key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		buf.Write(members[key])
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// Encode always appends a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// writeNumber formats integers exactly and everything else as the shortest
+// float64 representation, so 1.0 and 1 encode the same.
+func writeNumber(buf *bytes.Buffer, n json.Number) error {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		i, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return fmt.Errorf("replicache: invalid number %q", s)
+		}
+		buf.WriteString(i.String())
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+// DiffMaps returns the patch that turns prev into next. When canonicalize is
+// true, values are compared by their CanonicalJSON form so that storage that
+// reorders keys or reformats numbers (such as Postgres jsonb) doesn't
+// produce spurious puts. Pass false when stored bytes are preserved exactly
+// to skip the cost of canonicalization.
+func DiffMaps(prev, next map[string]json.RawMessage, canonicalize bool) ([]PatchOperation, error) {
+	var patch []PatchOperation
+	for key := range prev {
+		if _, ok := next[key]; !ok {
+			patch = append(patch, PatchOperation{Op: "del", Key: key})
+		}
+	}
+	for key, value := range next {
+		old, ok := prev[key]
+		if ok {
+			equal, err := jsonEqual(old, value, canonicalize)
+			if err != nil {
+				return nil, err
+			}
+			if equal {
+				continue
+			}
+		}
+		patch = append(patch, PatchOperation{Op: "put", Key: key, Value: value})
+	}
+	sort.Slice(patch, func(i, j int) bool { return patch[i].Key < patch[j].Key })
+	return patch, nil
+}
+
+func jsonEqual(a, b json.RawMessage, canonicalize bool) (bool, error) {
+	// an empty value is the absence of one, which is null
+	if len(a) == 0 {
+		a = NullArgs
+	}
+	if len(b) == 0 {
+		b = NullArgs
+	}
+	if bytes.Equal(a, b) || !canonicalize {
+		return bytes.Equal(a, b), nil
+	}
+	ca, err := CanonicalJSON(a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := CanonicalJSON(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ca, cb), nil
+}
@@ -0,0 +1,114 @@
+package replicache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{`{ "b": 1, "a": [true, null, "x"] }`, `{"a":[true,null,"x"],"b":1}`},
+		{`{"id": 9007199254740993}`, `{"id":9007199254740993}`},
+		{`1.50`, `1.5`},
+		{`1e2`, `100`},
+		{`{"a":{"d":1,"c":2}}`, `{"a":{"c":2,"d":1}}`},
+	} {
+		got, err := CanonicalJSON([]byte(tc.in))
+		if err != nil {
+			t.Errorf("CanonicalJSON(%s): %v", tc.in, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("CanonicalJSON(%s) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{`{"a":1,"a":2}`, `{"a":1} {}`, `{`} {
+		if _, err := CanonicalJSON([]byte(in)); err == nil {
+			t.Errorf("CanonicalJSON(%s) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestDiffMapsCanonicalize(t *testing.T) {
+	prev := map[string]json.RawMessage{"a": json.RawMessage(`{"x":1,"y":2}`), "b": json.RawMessage(`1`)}
+	next := map[string]json.RawMessage{"a": json.RawMessage(`{"y":2, "x":1}`), "c": json.RawMessage(`2`)}
+
+	patch, err := DiffMaps(prev, next, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 2 || patch[0].Op != "del" || patch[0].Key != "b" || patch[1].Op != "put" || patch[1].Key != "c" {
+		t.Errorf("patch = %v, want only the del of b and the put of c", patch)
+	}
+
+	patch, err = DiffMaps(prev, next, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 3 {
+		t.Errorf("patch without canonicalization = %v, want 3 ops", patch)
+	}
+}
+
+func TestDiffMapsNullValues(t *testing.T) {
+	// an absent value and null are the same value
+	prev := map[string]json.RawMessage{"a": nil, "b": json.RawMessage(`null`)}
+	next := map[string]json.RawMessage{"a": json.RawMessage(`null`), "b": nil}
+	for _, canonicalize := range []bool{false, true} {
+		patch, err := DiffMaps(prev, next, canonicalize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(patch) != 0 {
+			t.Errorf("canonicalize %v: patch = %v, want none", canonicalize, patch)
+		}
+	}
+}
+
+// BenchmarkCanonicalJSON measures the cost paid for every value on every
+// pull when DiffMaps canonicalizes.
+func BenchmarkCanonicalJSON(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString(`{"title":"buy milk","done":false,"order":12345678901234,"tags":[`)
+	for i := 0; i < 20; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"name":"tag%d","weight":%d.5}`, i, i)
+	}
+	sb.WriteString(`]}`)
+	value := []byte(sb.String())
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(value)))
+	for i := 0; i < b.N; i++ {
+		if _, err := CanonicalJSON(value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDiffMaps(b *testing.B) {
+	prev := make(map[string]json.RawMessage, 1000)
+	next := make(map[string]json.RawMessage, 1000)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("todo/%d", i)
+		prev[key] = json.RawMessage(fmt.Sprintf(`{"id":%d,"title":"todo","done":false}`, i))
+		next[key] = json.RawMessage(fmt.Sprintf(`{"done":false,"id":%d,"title":"todo"}`, i))
+	}
+	for _, canonicalize := range []bool{false, true} {
+		b.Run(fmt.Sprintf("canonicalize=%v", canonicalize), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := DiffMaps(prev, next, canonicalize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+)
+
+// FailedRequestStore receives the body of a push that failed, with mutation
+// args passed through the ArgRedactor. info never includes the
+// Authorization header.
+type FailedRequestStore func(ctx context.Context, info ClientInfo, body []byte, err error)
+
+// requestCapture buffers up to max bytes of a request body as it is read.
+type requestCapture struct {
+	r   io.Reader
+	buf bytes.Buffer
+	max int
+}
+
+// malformedBodyLogBytes is how much of a malformed push body is logged by
+// WithLogMalformedPushBody.
+const malformedBodyLogBytes = 512
+
+// captureBody returns body teed into a capture buffer when failed request
+// capture or malformed body logging is enabled, and body unchanged with a
+// nil capture otherwise.
+func (rep *Replicache) captureBody(body io.Reader) (io.Reader, *requestCapture) {
+	if rep.failedRequestStore == nil && !rep.logMalformedBody {
+		return body, nil
+	}
+	c := &requestCapture{}
+	if rep.failedRequestStore != nil {
+		c.max = rep.failedRequestMaxBytes
+	}
+	if rep.logMalformedBody {
+		c.max = max(c.max, malformedBodyLogBytes)
+	}
+	c.r = io.TeeReader(body, c)
+	return c.r, c
+}
+
+// Write keeps bytes up to the cap and silently drops the rest, so reading
+// the body never fails because of the capture.
+func (c *requestCapture) Write(p []byte) (int, error) {
+	if room := c.max - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// fill reads bytes the decoder didn't reach up to the cap, so the captured
+// body is as complete as possible.
+func (c *requestCapture) fill() []byte {
+	if room := c.max - c.buf.Len(); room > 0 {
+		io.Copy(io.Discard, io.LimitReader(c.r, int64(room)))
+	}
+	return c.buf.Bytes()
+}
+
+// captureFailedPush hands the captured body of a failed push to the store.
+func (rep *Replicache) captureFailedPush(ctx context.Context, c *requestCapture, info ClientInfo, err error) {
+	if c == nil || rep.failedRequestStore == nil {
+		return
+	}
+	body := c.fill()
+	body = body[:min(len(body), rep.failedRequestMaxBytes)]
+	info.Auth = ""
+	rep.failedRequestStore(ctx, info, rep.redactPushBody(body), err)
+}
+
+// logMalformedPush logs the start of a push body that couldn't be decoded, at
+// debug level. Mutation args are passed through the ArgRedactor, and a body
+// too broken to find them in is logged only by its length and hash.
+func (rep *Replicache) logMalformedPush(ctx context.Context, c *requestCapture, err error) {
+	if c == nil || !rep.logMalformedBody {
+		return
+	}
+	body := c.fill()
+	truncated := len(body) > malformedBodyLogBytes
+	body = body[:min(len(body), malformedBodyLogBytes)]
+	rep.logger.DebugContext(ctx, "replicache malformed push body",
+		slog.String("body", string(rep.redactPushBody(body))),
+		slog.Bool("truncated", truncated),
+		slog.Any("err", err),
+	)
+}
@@ -0,0 +1,102 @@
+package replicache
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// HandlerMiddleware wraps a Handler with logic that applies to both push and
+// pull, such as authorization or metrics. ctx is only used while the chain is
+// built, not for requests.
+type HandlerMiddleware func(ctx context.Context, inner Handler) Handler
+
+// HandlerChain composes HandlerMiddleware around a final Handler. The first
+// middleware appended is the outermost, so
+//
+//	chain.Append(auth).Append(metrics).Then(h)
+//
+// runs auth, then metrics, then h. The result can be passed to NewReplicache.
+// Replicache.Freeze reaches every handler of the chain, so middleware doesn't
+// have to forward it to a MutationRouter it wraps.
+type HandlerChain struct {
+	middleware []HandlerMiddleware
+}
+
+// DefaultChain returns a chain with LoggingMiddleware installed, logging to
+// slog.Default().
+func DefaultChain() *HandlerChain {
+	return new(HandlerChain).Append(LoggingMiddleware(slog.Default()))
+}
+
+// Append adds m to the end of the chain and returns the chain.
+func (c *HandlerChain) Append(m HandlerMiddleware) *HandlerChain {
+	c.middleware = append(c.middleware, m)
+	return c
+}
+
+// Then wraps final in the chain's middleware.
+func (c *HandlerChain) Then(final Handler) Handler {
+	h := final
+	elements := []Handler{final}
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		h = c.middleware[i](context.Background(), h)
+		elements = append(elements, h)
+	}
+	return &chainedHandler{Handler: h, elements: elements}
+}
+
+// chainedHandler is the outermost handler of a chain, which also freezes
+// every handler it was built from.
+type chainedHandler struct {
+	Handler
+	elements []Handler
+}
+
+func (h *chainedHandler) Freeze() {
+	for _, e := range h.elements {
+		freezeHandler(e)
+	}
+}
+
+// LoggingMiddleware logs every push and pull at debug level with its client
+// group, duration and error, if any.
+func LoggingMiddleware(logger *slog.Logger) HandlerMiddleware {
+	return func(_ context.Context, inner Handler) Handler {
+		return &loggingHandler{inner: inner, logger: logger}
+	}
+}
+
+type loggingHandler struct {
+	inner  Handler
+	logger *slog.Logger
+}
+
+func (h *loggingHandler) HandlePush(ctx context.Context, pr PushRequest) error {
+	start := time.Now()
+	err := h.inner.HandlePush(ctx, pr)
+	h.logger.DebugContext(ctx, "replicache push handled",
+		slog.String("client_group_id", pr.ClientGroupID),
+		slog.Int("mutations", len(pr.Mutations)),
+		slog.Duration("duration", time.Since(start)),
+		slog.Any("err", err),
+	)
+	return err
+}
+
+// Freeze freezes the wrapped handler, so Replicache.Freeze reaches a
+// MutationRouter inside a chain.
+func (h *loggingHandler) Freeze() {
+	freezeHandler(h.inner)
+}
+
+func (h *loggingHandler) HandlePull(ctx context.Context, pr PullRequest) (any, error) {
+	start := time.Now()
+	resp, err := h.inner.HandlePull(ctx, pr)
+	h.logger.DebugContext(ctx, "replicache pull handled",
+		slog.String("client_group_id", pr.ClientGroupID),
+		slog.Duration("duration", time.Since(start)),
+		slog.Any("err", err),
+	)
+	return resp, err
+}
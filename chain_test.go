@@ -0,0 +1,114 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// tracingHandler records the order in which a chain reaches it in calls,
+// and counts its Freeze calls.
+type tracingHandler struct {
+	name   string
+	inner  Handler
+	calls  *[]string
+	frozen int
+}
+
+func (h *tracingHandler) HandlePush(ctx context.Context, pr PushRequest) error {
+	*h.calls = append(*h.calls, h.name+" push")
+	if h.inner == nil {
+		return nil
+	}
+	return h.inner.HandlePush(ctx, pr)
+}
+
+func (h *tracingHandler) HandlePull(ctx context.Context, pr PullRequest) (any, error) {
+	*h.calls = append(*h.calls, h.name+" pull")
+	if h.inner == nil {
+		return nil, nil
+	}
+	return h.inner.HandlePull(ctx, pr)
+}
+
+func (h *tracingHandler) Freeze() {
+	h.frozen++
+}
+
+// opaque hides the Freeze method of the handler it wraps, like middleware
+// that doesn't know about it.
+type opaque struct{ Handler }
+
+func TestHandlerChainOrder(t *testing.T) {
+	var calls []string
+	middleware := func(name string) HandlerMiddleware {
+		return func(_ context.Context, inner Handler) Handler {
+			return opaque{&tracingHandler{name: name, inner: inner, calls: &calls}}
+		}
+	}
+	h := new(HandlerChain).Append(middleware("auth")).Append(middleware("metrics")).Then(&tracingHandler{name: "final", calls: &calls})
+
+	if err := h.HandlePush(context.Background(), PushRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.HandlePull(context.Background(), PullRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "auth push, metrics push, final push, auth pull, metrics pull, final pull"
+	if got := strings.Join(calls, ", "); got != want {
+		t.Errorf("chain ran %s, want %s", got, want)
+	}
+}
+
+func TestHandlerChainFreeze(t *testing.T) {
+	var calls []string
+	inner := &tracingHandler{name: "inner", calls: &calls}
+	var middle *tracingHandler
+	h := new(HandlerChain).
+		Append(func(_ context.Context, inner Handler) Handler { return opaque{inner} }).
+		Append(func(_ context.Context, inner Handler) Handler {
+			middle = &tracingHandler{name: "middle", inner: opaque{inner}, calls: &calls}
+			return middle
+		}).
+		Then(inner)
+
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, h)
+	rep.Freeze()
+	if inner.frozen == 0 {
+		t.Error("Freeze didn't reach the final handler behind middleware that doesn't forward it")
+	}
+	if middle.frozen == 0 {
+		t.Error("Freeze didn't reach the middleware")
+	}
+}
+
+func TestDefaultChain(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	router := NewMutationRouter()
+	h := DefaultChain().
+		Append(func(_ context.Context, inner Handler) Handler { return opaque{inner} }).
+		Then(struct {
+			*MutationRouter
+			PullHandler
+		}{router, testHandler{}})
+	if _, err := h.HandlePull(context.Background(), PullRequest{ClientInfo: ClientInfo{ClientGroupID: "group"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "replicache pull handled") || !strings.Contains(buf.String(), "client_group_id=group") {
+		t.Errorf("default chain logged %q, want the pull", buf.String())
+	}
+
+	_, db := newFakeDB(t)
+	newTestReplicache(t, db, h).Freeze()
+	if err := router.Register("m", func(context.Context, *sql.Tx, ClientInfo, Mutation) error { return nil }); !errors.Is(err, ErrRouterFrozen) {
+		t.Errorf("Register after Freeze = %v, want ErrRouterFrozen", err)
+	}
+}
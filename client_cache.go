@@ -0,0 +1,93 @@
+package replicache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// clientStateCache remembers the last mutation IDs of recently pushed client
+// groups, evicting the least recently used group beyond maxGroups. It only
+// lets fully duplicate pushes skip the database; every other push still
+// reads client state inside its transaction.
+type clientStateCache struct {
+	maxGroups int
+
+	mu     sync.Mutex
+	order  *list.List // of client group IDs, most recent first
+	groups map[string]*cachedGroup
+}
+
+type cachedGroup struct {
+	elem            *list.Element
+	lastMutationIDs map[string]int64
+}
+
+func newClientStateCache(maxGroups int) *clientStateCache {
+	return &clientStateCache{
+		maxGroups: maxGroups,
+		order:     list.New(),
+		groups:    make(map[string]*cachedGroup),
+	}
+}
+
+// allApplied reports whether every mutation is known to have been applied
+// already.
+func (c *clientStateCache) allApplied(clientGroupID string, mutations []Mutation) bool {
+	if c == nil || len(mutations) == 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[clientGroupID]
+	if !ok {
+		return false
+	}
+	for _, m := range mutations {
+		lmid, ok := g.lastMutationIDs[m.ClientID]
+		if !ok || int64(m.ID) > lmid {
+			return false
+		}
+	}
+	c.order.MoveToFront(g.elem)
+	return true
+}
+
+// update records committed last mutation IDs. It must only be called after
+// the transaction that wrote them committed.
+func (c *clientStateCache) update(clientGroupID string, lastMutationIDs map[string]int64) {
+	if c == nil || len(lastMutationIDs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[clientGroupID]
+	if ok {
+		c.order.MoveToFront(g.elem)
+	} else {
+		g = &cachedGroup{elem: c.order.PushFront(clientGroupID), lastMutationIDs: make(map[string]int64)}
+		c.groups[clientGroupID] = g
+		if c.order.Len() > c.maxGroups {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.groups, oldest.Value.(string))
+		}
+	}
+	for clientID, lmid := range lastMutationIDs {
+		g.lastMutationIDs[clientID] = max(g.lastMutationIDs[clientID], lmid)
+	}
+}
+
+// invalidate forgets client groups whose state was deleted or reset.
+func (c *clientStateCache) invalidate(clientGroupIDs ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range clientGroupIDs {
+		if g, ok := c.groups[id]; ok {
+			c.order.Remove(g.elem)
+			delete(c.groups, id)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package replicache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClientCacheShortCircuitAfterChecks(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithClientStateCache(10),
+		WithSupportedSchemaVersions("v1"),
+	)
+	req := pushRequest("group", mutation("client", 1, "m"))
+	req.SchemaVersion = "v1"
+	if w := post(t, rep.PushHandler(), req); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	begins, _, _ := f.txCounts()
+
+	// a re-send is answered from the cache
+	if w := post(t, rep.PushHandler(), req); w.Code != http.StatusOK {
+		t.Fatalf("re-sent push status = %d: %s", w.Code, w.Body)
+	}
+	if n, _, _ := f.txCounts(); n != begins {
+		t.Errorf("re-sent push began %d transactions, want none", n-begins)
+	}
+
+	// but not before its schema version was checked
+	req.SchemaVersion = "v2"
+	w := post(t, rep.PushHandler(), req)
+	if !strings.Contains(w.Body.String(), "VersionNotSupported") {
+		t.Errorf("re-sent push with an unsupported schema version got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestClientCacheStale(t *testing.T) {
+	f, db := newFakeDB(t)
+	var applied []int
+	h := testHandler{push: func(_ context.Context, pr PushRequest) error {
+		for _, m := range pr.Mutations {
+			applied = append(applied, m.ID)
+		}
+		return nil
+	}}
+	// two servers sharing a database, each with its own cache
+	a := newTestReplicache(t, db, h, WithClientOnPush(true), WithClientStateCache(10))
+	b := newTestReplicache(t, db, h, WithClientOnPush(true), WithClientStateCache(10))
+	push := func(rep *Replicache, muts ...Mutation) {
+		t.Helper()
+		if w := post(t, rep.PushHandler(), pushRequest("group", muts...)); w.Code != http.StatusOK {
+			t.Fatalf("push status = %d: %s", w.Code, w.Body)
+		}
+	}
+
+	push(a, mutation("client", 1, "m"))
+	push(b, mutation("client", 1, "m"), mutation("client", 2, "m"), mutation("client", 3, "m"))
+	// a's cache is behind the database, so the re-send is checked there
+	applied = nil
+	push(a, mutation("client", 2, "m"), mutation("client", 3, "m"))
+	if len(applied) != 0 {
+		t.Errorf("re-send through a stale cache applied %v again", applied)
+	}
+	if c, _ := f.client("group", "client"); c.lastMutationID != 3 {
+		t.Errorf("last mutation ID = %d, want 3", c.lastMutationID)
+	}
+
+	// a forced last mutation ID invalidates the cache, so the mutations
+	// are applied again rather than skipped from memory
+	if err := a.ForceLastMutationID(context.Background(), "group", "client", 1); err != nil {
+		t.Fatal(err)
+	}
+	applied = nil
+	push(a, mutation("client", 2, "m"))
+	if len(applied) != 1 || applied[0] != 2 {
+		t.Errorf("push after the forced reset applied %v, want [2]", applied)
+	}
+}
+
+// BenchmarkPushResend measures the database round trips of a fully
+// duplicate push with and without the client state cache.
+func BenchmarkPushResend(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		options []Option
+	}{
+		{"uncached", nil},
+		{"cached", []Option{WithClientStateCache(100)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			f, db := newFakeDB(b)
+			rep := newTestReplicache(b, db, testHandler{}, append([]Option{WithClientOnPush(true)}, bc.options...)...)
+			req := pushRequest("group", mutation("client", 1, "m"), mutation("client", 2, "m"))
+			if w := post(b, rep.PushHandler(), req); w.Code != http.StatusOK {
+				b.Fatalf("push status = %d", w.Code)
+			}
+			before := len(f.statements())
+			beginsBefore, _, _ := f.txCounts()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if w := post(b, rep.PushHandler(), req); w.Code != http.StatusOK {
+					b.Fatalf("push status = %d", w.Code)
+				}
+			}
+			b.StopTimer()
+			begins, _, _ := f.txCounts()
+			b.ReportMetric(float64(len(f.statements())-before)/float64(b.N), "statements/op")
+			b.ReportMetric(float64(begins-beginsBefore)/float64(b.N), "transactions/op")
+		})
+	}
+}
@@ -0,0 +1,646 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrClientNotFound      = errors.New("replicache: client not found")
+	ErrMutationOutOfOrder  = errors.New("replicache: mutation received out of order")
+	ErrClientGroupMismatch = errors.New("replicache: client belongs to a different client group")
+)
+
+// ClientRecord is the bookkeeping row stored for each client.
+type ClientRecord struct {
+	ClientGroupID  string
+	ClientID       string
+	LastMutationID int64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	// Metadata is stored alongside the client and is never read by the
+	// package. Use it to record application specific information such as
+	// a tenant or who created the client.
+	Metadata json.RawMessage
+}
+
+// ClientFactory builds the record inserted when a client is created on push.
+type ClientFactory func(info ClientInfo, clientID string) (ClientRecord, error)
+
+// defaultClientFactory leaves the timestamps for createClients to set from
+// the server clock.
+func defaultClientFactory(info ClientInfo, clientID string) (ClientRecord, error) {
+	return ClientRecord{
+		ClientGroupID:  info.ClientGroupID,
+		ClientID:       clientID,
+		LastMutationID: 0,
+	}, nil
+}
+
+// clientEvents records the clients and client group created by a transaction
+// so hooks can run once it commits.
+type clientEvents struct {
+	groupCreated   bool
+	clientsCreated []string
+}
+
+func (e *clientEvents) add(other clientEvents) {
+	e.groupCreated = e.groupCreated || other.groupCreated
+	e.clientsCreated = append(e.clientsCreated, other.clientsCreated...)
+}
+
+// loadClients returns the last mutation ID for every client referenced by
+// mutations, creating missing clients when clientOnPush is set. Clients are
+// loaded with a single query and missing clients are created with a single
+// insert, which keeps the first push of a new client group short. Created
+// clients and client groups are recorded in events.
+func (rep *Replicache) loadClients(ctx context.Context, tx *sql.Tx, info ClientInfo, mutations []Mutation, events *clientEvents) (map[string]int64, error) {
+	var clientIDs []string
+	seen := make(map[string]bool)
+	for _, m := range mutations {
+		if !seen[m.ClientID] {
+			seen[m.ClientID] = true
+			clientIDs = append(clientIDs, m.ClientID)
+		}
+	}
+	if len(clientIDs) == 0 {
+		return map[string]int64{}, nil
+	}
+
+	lastMutationIDs := make(map[string]int64)
+	done := rep.observeQuery(ctx, queryLoadClients, info.ClientGroupID)
+	if err := lockClients(ctx, tx, info.ClientGroupID, clientIDs, lastMutationIDs); err != nil {
+		return nil, err
+	}
+	done()
+
+	var missing []string
+	for _, clientID := range clientIDs {
+		if _, ok := lastMutationIDs[clientID]; !ok {
+			missing = append(missing, clientID)
+		}
+	}
+	if len(missing) == 0 {
+		return lastMutationIDs, nil
+	}
+
+	if err := rep.checkClientGroup(ctx, tx, info.ClientGroupID, missing); err != nil {
+		return nil, err
+	}
+	if !rep.clientOnPush {
+		return nil, fmt.Errorf("%w: %s", ErrClientNotFound, missing[0])
+	}
+	created, err := rep.ensureClientGroup(ctx, tx, info)
+	if err != nil {
+		return nil, err
+	}
+	events.groupCreated = events.groupCreated || created
+
+	recs, err := rep.createClients(ctx, tx, info, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range recs {
+		events.clientsCreated = append(events.clientsCreated, rec.ClientID)
+		lastMutationIDs[rec.ClientID] = rec.LastMutationID
+	}
+	if len(recs) < len(missing) {
+		// clients created by a concurrent push since they were read are
+		// locked and read like any existing client
+		var raced []string
+		for _, clientID := range missing {
+			if _, ok := lastMutationIDs[clientID]; !ok {
+				raced = append(raced, clientID)
+			}
+		}
+		if err := lockClients(ctx, tx, info.ClientGroupID, raced, lastMutationIDs); err != nil {
+			return nil, err
+		}
+	}
+	return lastMutationIDs, nil
+}
+
+// lockClients reads the last mutation IDs of the stored clients among
+// clientIDs into lastMutationIDs, locking their rows for the transaction.
+func lockClients(ctx context.Context, tx *sql.Tx, clientGroupID string, clientIDs []string, lastMutationIDs map[string]int64) error {
+	args := []any{clientGroupID}
+	for _, clientID := range clientIDs {
+		args = append(args, clientID)
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND client_id IN (`+placeholders(2, len(clientIDs))+`) FOR UPDATE`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var clientID string
+		var lmid int64
+		if err := rows.Scan(&clientID, &lmid); err != nil {
+			return err
+		}
+		lastMutationIDs[clientID] = lmid
+	}
+	return rows.Err()
+}
+
+// checkClientGroup returns ErrClientGroupMismatch if any of clientIDs is
+// already registered under a client group other than clientGroupID. This
+// happens when corrupted client storage reuses a clientID, and the client is
+// never silently moved to the new group.
+func (rep *Replicache) checkClientGroup(ctx context.Context, tx *sql.Tx, clientGroupID string, clientIDs []string) error {
+	defer rep.observeQuery(ctx, queryCheckClientGroup, clientGroupID)()
+	args := []any{clientGroupID}
+	for _, clientID := range clientIDs {
+		args = append(args, clientID)
+	}
+	var clientID, existing string
+	err := tx.QueryRowContext(ctx,
+		`SELECT client_id, client_group_id FROM replicache_clients WHERE client_group_id <> $1 AND client_id IN (`+placeholders(2, len(clientIDs))+`) LIMIT 1`,
+		args...,
+	).Scan(&clientID, &existing)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	rep.logger.Error("replicache client registered in another client group",
+		slog.String("client_id", clientID),
+		slog.String("client_group_id", clientGroupID),
+		slog.String("registered_client_group_id", existing),
+	)
+	return fmt.Errorf("%w: client %s is registered in group %s, not %s", ErrClientGroupMismatch, clientID, existing, clientGroupID)
+}
+
+// placeholders returns n comma separated placeholders numbered from first.
+func placeholders(first, n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = "$" + strconv.Itoa(first+i)
+	}
+	return strings.Join(p, ", ")
+}
+
+// fireClientEvents runs the new client and client group hooks. It must only
+// be called after the transaction that created them has committed.
+func (rep *Replicache) fireClientEvents(ctx context.Context, info ClientInfo, events clientEvents) {
+	if events.groupCreated && rep.newGroupHook != nil {
+		rep.runHook(ctx, hookNewGroup, info.ClientGroupID, func(ctx context.Context) {
+			rep.newGroupHook(ctx, info)
+		})
+	}
+	if rep.newClientHook != nil {
+		for _, clientID := range events.clientsCreated {
+			rep.runHook(ctx, hookNewClient, info.ClientGroupID, func(ctx context.Context) {
+				rep.newClientHook(ctx, info, clientID)
+			})
+		}
+	}
+}
+
+func (rep *Replicache) createClient(ctx context.Context, tx *sql.Tx, info ClientInfo, clientID string) (ClientRecord, error) {
+	recs, err := rep.createClients(ctx, tx, info, []string{clientID})
+	if err != nil {
+		return ClientRecord{}, err
+	}
+	if len(recs) == 0 {
+		return ClientRecord{}, fmt.Errorf("replicache: client %s already exists", clientID)
+	}
+	return recs[0], nil
+}
+
+// createClients inserts a record for each of clientIDs with one statement and
+// returns the records that were inserted. Clients created concurrently since
+// they were found missing are left out.
+func (rep *Replicache) createClients(ctx context.Context, tx *sql.Tx, info ClientInfo, clientIDs []string) ([]ClientRecord, error) {
+	defer rep.observeQuery(ctx, queryCreateClients, info.ClientGroupID)()
+	now := rep.clock.Now()
+	recs := make([]ClientRecord, 0, len(clientIDs))
+	values := make([]string, 0, len(clientIDs))
+	var args []any
+	for _, clientID := range clientIDs {
+		rec, err := rep.clientFactory(info, clientID)
+		if err != nil {
+			return nil, err
+		}
+		// the factory may enrich the record but can't move it to another client or group
+		rec.ClientGroupID = info.ClientGroupID
+		rec.ClientID = clientID
+
+		if rec.CreatedAt.IsZero() {
+			rec.CreatedAt = now
+		}
+		if rec.UpdatedAt.IsZero() {
+			rec.UpdatedAt = now
+		}
+
+		var metadata any
+		if len(rec.Metadata) > 0 {
+			metadata = []byte(rec.Metadata)
+		}
+		values = append(values, "("+placeholders(len(args)+1, 6)+")")
+		args = append(args, rec.ClientID, rec.ClientGroupID, rec.LastMutationID, metadata, rec.CreatedAt, rec.UpdatedAt)
+		recs = append(recs, rec)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`INSERT INTO replicache_clients (client_id, client_group_id, last_mutation_id, metadata, created_at, updated_at)
+		VALUES `+strings.Join(values, ", ")+`
+		ON CONFLICT (client_group_id, client_id) DO NOTHING
+		RETURNING client_id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	inserted := make(map[string]bool, len(recs))
+	for rows.Next() {
+		var clientID string
+		if err := rows.Scan(&clientID); err != nil {
+			return nil, err
+		}
+		inserted[clientID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(recs, func(rec ClientRecord) bool { return !inserted[rec.ClientID] }), nil
+}
+
+// setLastMutationID advances the stored last mutation ID of a client. It
+// never moves it backward; a stored value above lastMutationID is reported
+// as a regression.
+func (rep *Replicache) setLastMutationID(ctx context.Context, tx *sql.Tx, clientGroupID, clientID string, lastMutationID, version int64) error {
+	var stored int64
+	err := tx.QueryRowContext(ctx,
+		`UPDATE replicache_clients SET last_mutation_id = $1, last_modified_version = $2, updated_at = $3
+		WHERE client_group_id = $4 AND client_id = $5 AND last_mutation_id <= $1
+		RETURNING last_mutation_id`,
+		lastMutationID, version, rep.clock.Now(), clientGroupID, clientID,
+	).Scan(&stored)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx,
+		`SELECT last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND client_id = $2`,
+		clientGroupID, clientID,
+	).Scan(&stored); err != nil {
+		return err
+	}
+	return rep.regression(ctx, clientGroupID, clientID, stored, lastMutationID)
+}
+
+// saveLastMutationIDs writes the last mutation ID of every client that
+// changed from previous. When any did, the client group version is bumped
+// and recorded on the changed clients so pulls can find them.
+func (rep *Replicache) saveLastMutationIDs(ctx context.Context, tx *sql.Tx, clientGroupID string, previous, current map[string]int64) error {
+	defer rep.observeQuery(ctx, querySaveLastMutationIDs, clientGroupID)()
+	var version int64
+	for clientID, lmid := range current {
+		if lmid == previous[clientID] {
+			continue
+		}
+		if version == 0 {
+			if err := tx.QueryRowContext(ctx,
+				`INSERT INTO replicache_version (client_group_id, version) VALUES ($1, 1)
+				ON CONFLICT (client_group_id) DO UPDATE SET version = replicache_version.version + 1
+				RETURNING version`,
+				clientGroupID,
+			).Scan(&version); err != nil {
+				return err
+			}
+		}
+		if err := rep.setLastMutationID(ctx, tx, clientGroupID, clientID, lmid, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getLastMutationIDChangesSince returns the last mutation ID of every client
+// in the group changed after the given client group version.
+func getLastMutationIDChangesSince(ctx context.Context, tx DBTX, clientGroupID string, version int64) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND last_modified_version > $2`,
+		clientGroupID, version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make(map[string]int64)
+	for rows.Next() {
+		var clientID string
+		var lmid int64
+		if err := rows.Scan(&clientID, &lmid); err != nil {
+			return nil, err
+		}
+		changes[clientID] = lmid
+	}
+	return changes, rows.Err()
+}
+
+// pendingMutations drops mutations that have already been applied and
+// advances lastMutationIDs to the IDs that will be applied. Skipped
+// mutations are logged since they usually point to a client that failed to
+// persist its pending mutations across a restart. A mutation further ahead
+// of the stored ID than the configured gap is reported as a regression.
+func (rep *Replicache) pendingMutations(ctx context.Context, clientGroupID string, mutations []Mutation, lastMutationIDs map[string]int64) ([]Mutation, error) {
+	var pending []Mutation
+	for _, m := range mutations {
+		expected := lastMutationIDs[m.ClientID] + 1
+		switch {
+		case int64(m.ID) < expected:
+			rep.logger.Log(ctx, rep.logLevel(LogEventMutationSkipped, slog.LevelWarn), "replicache skipped already applied mutation", slog.Any("mutation", m))
+			rep.counters.skippedMutations.Add(1)
+			continue
+		case rep.lastMutationIDGap > 0 && int64(m.ID)-lastMutationIDs[m.ClientID] > rep.lastMutationIDGap:
+			return nil, rep.regression(ctx, clientGroupID, m.ClientID, lastMutationIDs[m.ClientID], int64(m.ID))
+		case int64(m.ID) > expected:
+			return nil, fmt.Errorf("%w: client %s expected mutation %d, got %d", ErrMutationOutOfOrder, m.ClientID, expected, m.ID)
+		}
+		lastMutationIDs[m.ClientID] = int64(m.ID)
+		pending = append(pending, m)
+	}
+	return pending, nil
+}
+
+// MoveClient moves a client from one client group to another, keeping its
+// last mutation ID. It is intended for support cases where a client was
+// deliberately re-homed and returns ErrClientNotFound if the client isn't
+// registered in fromGroupID.
+func (rep *Replicache) MoveClient(ctx context.Context, clientID, fromGroupID, toGroupID string) error {
+	rep, err := rep.routeGroups(ctx, fromGroupID, toGroupID)
+	if err != nil {
+		return err
+	}
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE replicache_clients SET client_group_id = $1, updated_at = $2 WHERE client_group_id = $3 AND client_id = $4`,
+		toGroupID, rep.clock.Now(), fromGroupID, clientID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s in group %s", ErrClientNotFound, clientID, fromGroupID)
+	}
+	if err := rep.commit(tx); err != nil {
+		return err
+	}
+	rep.clientCache.invalidate(fromGroupID, toGroupID)
+	rep.forgetDuplicates(clientID)
+	rep.logger.Info("replicache client moved",
+		slog.String("client_id", clientID),
+		slog.String("from_client_group_id", fromGroupID),
+		slog.String("to_client_group_id", toGroupID),
+	)
+	return nil
+}
+
+// ClientMigration describes the clients moved by MigrateClients.
+type ClientMigration struct {
+	// Moved lists the clients that only existed in the source group.
+	Moved []string
+
+	// Merged lists the clients registered in both groups. They keep the
+	// higher of their two last mutation IDs.
+	Merged []string
+}
+
+// MigrateClients moves every client of fromGroupID into toGroupID in one
+// transaction and deletes fromGroupID, for example when two user accounts are
+// merged. A client registered in both groups keeps the higher last mutation
+// ID. The moved clients are reported in the next pull of toGroupID. A
+// toGroupID that doesn't exist yet takes over the profile and owner of
+// fromGroupID.
+func (rep *Replicache) MigrateClients(ctx context.Context, fromGroupID, toGroupID string) error {
+	_, err := rep.migrateClients(ctx, fromGroupID, toGroupID, false)
+	return err
+}
+
+// MigrateClientsDryRun reports what MigrateClients would do without changing
+// anything.
+func (rep *Replicache) MigrateClientsDryRun(ctx context.Context, fromGroupID, toGroupID string) (ClientMigration, error) {
+	return rep.migrateClients(ctx, fromGroupID, toGroupID, true)
+}
+
+func (rep *Replicache) migrateClients(ctx context.Context, fromGroupID, toGroupID string, dryRun bool) (ClientMigration, error) {
+	var migration ClientMigration
+	if fromGroupID == toGroupID {
+		return migration, errors.New("replicache: cannot migrate clients into their own client group")
+	}
+	rep, err := rep.routeGroups(ctx, fromGroupID, toGroupID)
+	if err != nil {
+		return migration, err
+	}
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return migration, err
+	}
+	defer tx.Rollback()
+
+	from, err := lockGroupClients(ctx, tx, fromGroupID)
+	if err != nil {
+		return migration, err
+	}
+	to, err := lockGroupClients(ctx, tx, toGroupID)
+	if err != nil {
+		return migration, err
+	}
+	for clientID := range from {
+		if _, ok := to[clientID]; ok {
+			migration.Merged = append(migration.Merged, clientID)
+		} else {
+			migration.Moved = append(migration.Moved, clientID)
+		}
+	}
+	sort.Strings(migration.Moved)
+	sort.Strings(migration.Merged)
+	if dryRun {
+		return migration, nil
+	}
+	if len(from) == 0 {
+		// an empty group is still merged away, a missing one is left alone
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM replicache_version WHERE client_group_id = $1)`,
+			fromGroupID,
+		).Scan(&exists); err != nil || !exists {
+			return migration, err
+		}
+	}
+
+	if err := inheritClientGroup(ctx, tx, fromGroupID, toGroupID); err != nil {
+		return migration, err
+	}
+	var version int64
+	if err := tx.QueryRowContext(ctx,
+		`UPDATE replicache_version SET version = version + 1 WHERE client_group_id = $1 RETURNING version`,
+		toGroupID,
+	).Scan(&version); err != nil {
+		return migration, err
+	}
+	now := rep.clock.Now()
+	for _, clientID := range migration.Merged {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE replicache_clients SET last_mutation_id = $1, last_modified_version = $2, updated_at = $3 WHERE client_group_id = $4 AND client_id = $5`,
+			max(from[clientID], to[clientID]), version, now, toGroupID, clientID,
+		); err != nil {
+			return migration, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM replicache_clients WHERE client_group_id = $1 AND client_id = $2`,
+			fromGroupID, clientID,
+		); err != nil {
+			return migration, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE replicache_clients SET client_group_id = $1, last_modified_version = $2, updated_at = $3 WHERE client_group_id = $4`,
+		toGroupID, version, now, fromGroupID,
+	); err != nil {
+		return migration, err
+	}
+	if err := deleteClientGroup(ctx, tx, fromGroupID); err != nil {
+		return migration, err
+	}
+	if err := rep.commit(tx); err != nil {
+		return migration, err
+	}
+	rep.clientCache.invalidate(fromGroupID, toGroupID)
+	rep.forgetDuplicates(migration.Moved...)
+	rep.forgetDuplicates(migration.Merged...)
+	rep.logger.InfoContext(ctx, "replicache clients migrated",
+		slog.String("from_client_group_id", fromGroupID),
+		slog.String("to_client_group_id", toGroupID),
+		slog.Int("moved", len(migration.Moved)),
+		slog.Int("merged", len(migration.Merged)),
+	)
+	return migration, nil
+}
+
+// lockGroupClients locks and returns the last mutation IDs of every client in
+// the client group.
+func lockGroupClients(ctx context.Context, tx *sql.Tx, clientGroupID string) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 FOR UPDATE`,
+		clientGroupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastMutationIDs := make(map[string]int64)
+	for rows.Next() {
+		var clientID string
+		var lmid int64
+		if err := rows.Scan(&clientID, &lmid); err != nil {
+			return nil, err
+		}
+		lastMutationIDs[clientID] = lmid
+	}
+	return lastMutationIDs, rows.Err()
+}
+
+// LastMutationIDs returns the last mutation ID of every client in the client
+// group. It is meant for tests and admin tooling that need to check client
+// state after a push.
+func (rep *Replicache) LastMutationIDs(ctx context.Context, clientGroupID string) (map[string]int64, error) {
+	rep, err := rep.routeGroup(ctx, clientGroupID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := rep.db.QueryContext(ctx,
+		`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1`,
+		clientGroupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastMutationIDs := make(map[string]int64)
+	for rows.Next() {
+		var clientID string
+		var lmid int64
+		if err := rows.Scan(&clientID, &lmid); err != nil {
+			return nil, err
+		}
+		lastMutationIDs[clientID] = lmid
+	}
+	return lastMutationIDs, rows.Err()
+}
+
+// ListClientIDs returns the IDs of every client in the client group, most
+// recently changed first.
+func (rep *Replicache) ListClientIDs(ctx context.Context, clientGroupID string) ([]string, error) {
+	rep, err := rep.routeGroup(ctx, clientGroupID)
+	if err != nil {
+		return nil, err
+	}
+	return queryClientIDs(ctx, rep.db,
+		`SELECT client_id FROM replicache_clients WHERE client_group_id = $1 ORDER BY last_modified_version DESC, client_id`,
+		clientGroupID,
+	)
+}
+
+// ListClientIDsPage returns up to limit client IDs of the client group that
+// sort after the given ID, in ID order. Pass an empty after for the first
+// page and the last ID of a page for the next one; a short page is the last.
+func (rep *Replicache) ListClientIDsPage(ctx context.Context, clientGroupID, after string, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, errors.New("replicache: limit must be positive")
+	}
+	rep, err := rep.routeGroup(ctx, clientGroupID)
+	if err != nil {
+		return nil, err
+	}
+	return queryClientIDs(ctx, rep.db,
+		`SELECT client_id FROM replicache_clients WHERE client_group_id = $1 AND client_id > $2 ORDER BY client_id LIMIT $3`,
+		clientGroupID, after, limit,
+	)
+}
+
+func queryClientIDs(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clientIDs []string
+	for rows.Next() {
+		var clientID string
+		if err := rows.Scan(&clientID); err != nil {
+			return nil, err
+		}
+		clientIDs = append(clientIDs, clientID)
+	}
+	return clientIDs, rows.Err()
+}
@@ -0,0 +1,220 @@
+package replicache
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientFactory(t *testing.T) {
+	f, db := newFakeDB(t)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithClientFactory(func(info ClientInfo, clientID string) (ClientRecord, error) {
+			return ClientRecord{
+				// the factory can't move the client to another group
+				ClientGroupID: "other",
+				CreatedAt:     created,
+				Metadata:      json.RawMessage(`{"tenant":"acme"}`),
+			}, nil
+		}),
+	)
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "create")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	c, ok := f.client("group", "client")
+	if !ok {
+		t.Fatal("client wasn't created in its push's group")
+	}
+	if string(c.metadata) != `{"tenant":"acme"}` {
+		t.Errorf("metadata = %s, want the factory's", c.metadata)
+	}
+	if !c.createdAt.Equal(created) {
+		t.Errorf("created_at = %v, want %v", c.createdAt, created)
+	}
+	if c.updatedAt.IsZero() {
+		t.Error("updated_at wasn't defaulted")
+	}
+	if c.lastMutationID != 1 {
+		t.Errorf("last mutation ID = %d, want 1", c.lastMutationID)
+	}
+}
+
+func TestNewClientHooks(t *testing.T) {
+	_, db := newFakeDB(t)
+	var groups, clients []string
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithNewGroupHook(func(_ context.Context, info ClientInfo) {
+			groups = append(groups, info.ClientGroupID)
+		}),
+		WithNewClientHook(func(_ context.Context, info ClientInfo, clientID string) {
+			clients = append(clients, clientID)
+		}),
+	)
+
+	for i, mutations := range [][]Mutation{
+		{mutation("a", 1, "m")},
+		{mutation("a", 2, "m"), mutation("b", 1, "m")},
+		{mutation("a", 3, "m"), mutation("b", 2, "m")},
+	} {
+		if w := post(t, rep.PushHandler(), pushRequest("group", mutations...)); w.Code != http.StatusOK {
+			t.Fatalf("push %d status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	if !slices.Equal(groups, []string{"group"}) {
+		t.Errorf("new group hook saw %q, want the group once", groups)
+	}
+	if !slices.Equal(clients, []string{"a", "b"}) {
+		t.Errorf("new client hook saw %q, want each client once", clients)
+	}
+}
+
+func TestNewClientHookSkippedOnRollback(t *testing.T) {
+	_, db := newFakeDB(t)
+	var clients []string
+	rep := newTestReplicache(t, db, testHandler{push: func(context.Context, PushRequest) error {
+		return errors.New("failed")
+	}},
+		WithClientOnPush(true),
+		WithNewClientHook(func(_ context.Context, _ ClientInfo, clientID string) {
+			clients = append(clients, clientID)
+		}),
+	)
+
+	post(t, rep.PushHandler(), pushRequest("group", mutation("a", 1, "m")))
+	if len(clients) != 0 {
+		t.Errorf("new client hook fired for a rolled back client: %q", clients)
+	}
+}
+
+func TestFirstPushCreatesClientsInBatch(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{}, WithClientOnPush(true))
+
+	w := post(t, rep.PushHandler(), pushRequest("group",
+		mutation("main", 1, "m"), mutation("worker", 1, "m"), mutation("main", 2, "m"), mutation("tab", 1, "m"),
+	))
+	if w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	for _, tc := range []struct {
+		stmt string
+		want int
+	}{
+		{`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND client_id IN ($2, $3, $4) FOR UPDATE`, 1},
+		{`SELECT client_id, client_group_id FROM replicache_clients`, 1},
+		{`INSERT INTO replicache_clients`, 1},
+		{`INSERT INTO replicache_version (client_group_id, profile_id, owner, owner_issued_at)`, 1},
+	} {
+		if got := f.count(tc.stmt); got != tc.want {
+			t.Errorf("%d statements like %q, want %d", got, tc.stmt, tc.want)
+		}
+	}
+	if got := len(f.statements()); got > 12 {
+		t.Errorf("first push ran %d statements:\n%s", got, strings.Join(f.statements(), "\n"))
+	}
+}
+
+func TestCreateClientsRace(t *testing.T) {
+	f, db := newFakeDB(t)
+	insert := defaultRule(`INSERT INTO replicache_clients`)
+	f.on(`INSERT INTO replicache_clients`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+		// a concurrent push creates b between the read and the insert
+		s.clients[fakeClientKey{"group", "b"}] = &fakeClient{lastMutationID: 1}
+		return insert(s, args)
+	})
+	var created []string
+	var applied []Mutation
+	rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+		applied = append(applied, pr.Mutations...)
+		return nil
+	}},
+		WithClientOnPush(true),
+		WithNewClientHook(func(_ context.Context, _ ClientInfo, clientID string) {
+			created = append(created, clientID)
+		}),
+	)
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("a", 1, "m"), mutation("b", 1, "m"), mutation("b", 2, "m")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !slices.Equal(created, []string{"a"}) {
+		t.Errorf("new client hook saw %q, want only a", created)
+	}
+	if got := f.count(`FROM replicache_clients WHERE client_group_id = $1 AND client_id IN ($2) FOR UPDATE`); got != 1 {
+		t.Errorf("raced client was re-read %d times, want once with a lock", got)
+	}
+	// b's first mutation was applied by the concurrent push
+	if len(applied) != 2 || applied[1].ClientID != "b" || applied[1].ID != 2 {
+		t.Errorf("applied %v, want a/1 and b/2", applied)
+	}
+}
+
+func TestMigrateClientsInheritsProfile(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.addClient("from", "a", 3)
+	f.addClient("from", "b", 1)
+	f.addClient("other", "x", 1)
+	f.mu.Lock()
+	f.state.groups["from"].profileID = "profile"
+	f.state.groups["from"].owner = "alice"
+	f.mu.Unlock()
+	rep := newTestReplicache(t, db, testHandler{},
+		WithIdentityChangePolicy(func(context.Context, ClientInfo) (Identity, error) {
+			t.Error("identity asked for while migrating, without a request")
+			return Identity{}, nil
+		}, IdentityChangeReject),
+	)
+
+	if err := rep.MigrateClients(context.Background(), "from", "to"); err != nil {
+		t.Fatal(err)
+	}
+	g, ok := f.group("to")
+	if !ok || g.profileID != "profile" || g.owner != "alice" {
+		t.Errorf("target group = %+v, want the profile and owner of the source group", g)
+	}
+	if c, ok := f.client("to", "a"); !ok || c.lastMutationID != 3 {
+		t.Errorf("client a wasn't moved: %+v", c)
+	}
+	if _, ok := f.group("from"); ok {
+		t.Error("source group still exists")
+	}
+}
+
+func TestMigrateClientsEmptyGroup(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.addClient("to", "a", 1)
+	f.mu.Lock()
+	f.state.groups["from"] = &fakeGroup{version: 3, profileID: "profile"}
+	f.mu.Unlock()
+	rep := newTestReplicache(t, db, testHandler{})
+
+	if err := rep.MigrateClients(context.Background(), "from", "to"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.group("from"); ok {
+		t.Error("empty source group still exists")
+	}
+	if c, ok := f.client("to", "a"); !ok || c.lastMutationID != 1 {
+		t.Errorf("client of the target group changed: %+v", c)
+	}
+
+	// a source group that doesn't exist isn't created or merged
+	if err := rep.MigrateClients(context.Background(), "missing", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.group("new"); ok {
+		t.Error("migrating a missing group created the target group")
+	}
+}
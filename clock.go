@@ -0,0 +1,31 @@
+package replicache
+
+import (
+	"context"
+	"time"
+)
+
+// clock is the source of time for push retries, the circuit breaker and the
+// duplicate detector, so tests can control it.
+type clock interface {
+	Now() time.Time
+
+	// Sleep waits for d, returning early with ctx's error if it is done
+	// first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,141 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// CookieValue is a typed pull cookie. It encodes to a compact base64url
+// string so it stays opaque to clients while remaining readable when
+// debugging. With WithServerID set, pull responses carry a CookieValue
+// instead of a bare version, and a cookie issued by another server is
+// treated as NilCookie to force a full pull.
+type CookieValue struct {
+	Version  int64
+	IssuedAt time.Time
+	ServerID string
+}
+
+type cookieValueJSON struct {
+	Version  int64  `json:"v"`
+	IssuedAt int64  `json:"t,omitempty"`
+	ServerID string `json:"s,omitempty"`
+}
+
+// String returns the encoded cookie.
+func (c CookieValue) String() string {
+	v := cookieValueJSON{Version: c.Version, ServerID: c.ServerID}
+	if !c.IssuedAt.IsZero() {
+		v.IssuedAt = c.IssuedAt.UnixMilli()
+	}
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CookieFromString decodes a cookie encoded by CookieValue.String.
+func CookieFromString(s string) (CookieValue, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return CookieValue{}, fmt.Errorf("replicache: invalid cookie: %w", err)
+	}
+	var v cookieValueJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return CookieValue{}, fmt.Errorf("replicache: invalid cookie: %w", err)
+	}
+	c := CookieValue{Version: v.Version, ServerID: v.ServerID}
+	if v.IssuedAt != 0 {
+		c.IssuedAt = time.UnixMilli(v.IssuedAt)
+	}
+	return c, nil
+}
+
+func (c CookieValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *CookieValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := CookieFromString(s)
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+// decodeCookie reads a pull request cookie, which is null, a version or a
+// CookieValue encoded by this package. A CookieValue from another server
+// decodes as NilCookie. Any other string is returned unchanged as raw, with
+// NilCookie, for handlers with their own cookie scheme.
+func (rep *Replicache) decodeCookie(ctx context.Context, cookie json.RawMessage) (c Cookie, raw json.RawMessage, err error) {
+	cookie = bytes.TrimSpace(cookie)
+	if len(cookie) == 0 {
+		return NilCookie, nil, nil
+	}
+	if cookie[0] != '"' {
+		err := json.Unmarshal(cookie, &c)
+		return c, nil, err
+	}
+	var s string
+	if err := json.Unmarshal(cookie, &s); err != nil {
+		return NilCookie, nil, err
+	}
+	cv, ok := parseCookieValue(s)
+	if !ok {
+		return NilCookie, cookie, nil
+	}
+	if rep.serverID != "" && cv.ServerID != rep.serverID {
+		rep.logger.DebugContext(ctx, "replicache cookie from another server, forcing full pull",
+			slog.String("cookie_server_id", cv.ServerID),
+		)
+		return NilCookie, nil, nil
+	}
+	return Cookie(cv.Version), nil, nil
+}
+
+// parseCookieValue decodes s if it is exactly what CookieValue.String
+// produces, so strings that merely happen to be base64url JSON aren't
+// mistaken for this package's cookies.
+func parseCookieValue(s string) (CookieValue, bool) {
+	cv, err := CookieFromString(s)
+	if err != nil || cv.Version <= 0 || cv.String() != s {
+		return CookieValue{}, false
+	}
+	return cv, true
+}
+
+// encodeCookie replaces the cookie of a PullResponse with a CookieValue
+// issued by this server when WithServerID is set.
+func (rep *Replicache) encodeCookie(resp any) any {
+	if rep.serverID == "" {
+		return resp
+	}
+	var pr PullResponse
+	switch v := resp.(type) {
+	case PullResponse:
+		pr = v
+	case *PullResponse:
+		if v == nil {
+			return resp
+		}
+		pr = *v
+	default:
+		return resp
+	}
+	var cookie any
+	if pr.Cookie != NilCookie {
+		cookie = CookieValue{Version: int64(pr.Cookie), IssuedAt: time.Now(), ServerID: rep.serverID}
+	}
+	return struct {
+		PullResponse
+		Cookie any `json:"cookie"`
+	}{pr, cookie}
+}
@@ -0,0 +1,158 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// CVRStore persists the client view record of each client group: the
+// version of every key the client group was last sent. It lets handlers
+// compute an incremental patch against what the client already has.
+type CVRStore interface {
+	GetCVR(ctx context.Context, tx *sql.Tx, clientGroupID string) (map[string]string, error)
+	SetCVR(ctx context.Context, tx *sql.Tx, clientGroupID string, cvr map[string]string) error
+}
+
+// SQLCVRStore stores client view records as JSON in the replicache_cvr table.
+type SQLCVRStore struct{}
+
+func (SQLCVRStore) GetCVR(ctx context.Context, tx *sql.Tx, clientGroupID string) (map[string]string, error) {
+	var raw []byte
+	err := tx.QueryRowContext(ctx,
+		`SELECT cvr FROM replicache_cvr WHERE client_group_id = $1`,
+		clientGroupID,
+	).Scan(&raw)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return map[string]string{}, nil
+	case err != nil:
+		return nil, err
+	}
+	cvr := make(map[string]string)
+	if err := json.Unmarshal(raw, &cvr); err != nil {
+		return nil, err
+	}
+	return cvr, nil
+}
+
+func (SQLCVRStore) SetCVR(ctx context.Context, tx *sql.Tx, clientGroupID string, cvr map[string]string) error {
+	raw, err := json.Marshal(cvr)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO replicache_cvr (client_group_id, cvr, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (client_group_id) DO UPDATE SET cvr = EXCLUDED.cvr, updated_at = EXCLUDED.updated_at`,
+		clientGroupID, raw, time.Now(),
+	)
+	return err
+}
+
+// responseCVR returns the CVR set on a PullResponse returned by the handler.
+func responseCVR(resp any) (map[string]string, bool) {
+	switch v := resp.(type) {
+	case PullResponse:
+		return v.CVR, v.CVR != nil
+	case *PullResponse:
+		if v != nil {
+			return v.CVR, v.CVR != nil
+		}
+	}
+	return nil, false
+}
+
+// FilterCVR returns the entries of cvr whose keys start with one of prefixes.
+// An empty prefix list matches every key.
+func FilterCVR(cvr map[string]string, prefixes []string) map[string]string {
+	if len(prefixes) == 0 {
+		return cvr
+	}
+	filtered := make(map[string]string)
+	for key, version := range cvr {
+		if inScope(key, prefixes) {
+			filtered[key] = version
+		}
+	}
+	return filtered
+}
+
+// mergeCVR replaces the in-scope entries of stored with next, keeping the
+// entries outside the scope of the pull.
+func mergeCVR(stored, next map[string]string, prefixes []string) map[string]string {
+	if len(prefixes) == 0 {
+		return next
+	}
+	merged := make(map[string]string, len(stored)+len(next))
+	for key, version := range stored {
+		if !inScope(key, prefixes) {
+			merged[key] = version
+		}
+	}
+	for key, version := range next {
+		merged[key] = version
+	}
+	return merged
+}
+
+func inScope(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForceFullPull makes every client of a client group do a full pull on its
+// next sync, for when the server's data was replaced wholesale. It bumps the
+// client group version and records it as the group's reset version: with
+// WithVersionCookies, pulls with an older cookie are handed to the pull
+// handler with NilCookie. With a CVRStore configured, it also clears the
+// group's CVR so the next pull is computed against an empty baseline. It returns ErrClientNotFound if the
+// group doesn't exist.
+func (rep *Replicache) ForceFullPull(ctx context.Context, clientGroupID string) error {
+	rep, err := rep.routeGroup(ctx, clientGroupID)
+	if err != nil {
+		return err
+	}
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	err = tx.QueryRowContext(ctx,
+		`UPDATE replicache_version SET version = version + 1, reset_version = version + 1 WHERE client_group_id = $1 RETURNING version`,
+		clientGroupID,
+	).Scan(&version)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: client group %s", ErrClientNotFound, clientGroupID)
+	case err != nil:
+		return err
+	}
+	if rep.cvrStore != nil {
+		if err := rep.cvrStore.SetCVR(ctx, tx, clientGroupID, map[string]string{}); err != nil {
+			return err
+		}
+	}
+	if err := rep.commit(tx); err != nil {
+		return err
+	}
+	rep.clientCache.invalidate(clientGroupID)
+	rep.logger.InfoContext(ctx, "replicache forced full pull",
+		slog.String("client_group_id", clientGroupID),
+		slog.Int64("version", version),
+	)
+	return nil
+}
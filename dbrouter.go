@@ -0,0 +1,167 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// DBRouter picks the database of a request by its profile ID, for
+// deployments that keep each tenant in its own database. Every registered
+// database has its own connection pool and must have CreateSchema run
+// against it, which WithAutoMigrate does for databases registered before
+// NewReplicache.
+type DBRouter struct {
+	mu        sync.RWMutex
+	databases map[string]*sql.DB
+	fallback  *sql.DB
+}
+
+func NewDBRouter() *DBRouter {
+	return &DBRouter{databases: make(map[string]*sql.DB)}
+}
+
+// Register routes requests from profileID to db.
+func (dr *DBRouter) Register(profileID string, db *sql.DB) *DBRouter {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.databases[profileID] = db
+	return dr
+}
+
+// RegisterDefault routes requests from unregistered profiles to db.
+func (dr *DBRouter) RegisterDefault(db *sql.DB) *DBRouter {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.fallback = db
+	return dr
+}
+
+func (dr *DBRouter) lookup(profileID string) (*sql.DB, bool) {
+	dr.mu.RLock()
+	defer dr.mu.RUnlock()
+	if db, ok := dr.databases[profileID]; ok {
+		return db, true
+	}
+	return dr.fallback, dr.fallback != nil
+}
+
+// all returns every registered database once, including the default.
+func (dr *DBRouter) all() []*sql.DB {
+	dr.mu.RLock()
+	defer dr.mu.RUnlock()
+	seen := make(map[*sql.DB]bool)
+	var dbs []*sql.DB
+	for _, db := range dr.databases {
+		if !seen[db] {
+			seen[db] = true
+			dbs = append(dbs, db)
+		}
+	}
+	if dr.fallback != nil && !seen[dr.fallback] {
+		dbs = append(dbs, dr.fallback)
+	}
+	return dbs
+}
+
+// routeDB returns a copy of rep using the database routed to info's profile,
+// or rep itself when no DBRouter is configured. It returns
+// ErrClientNotFound when the profile has no database.
+func (rep *Replicache) routeDB(info ClientInfo) (*Replicache, error) {
+	if rep.dbRouter == nil {
+		return rep, nil
+	}
+	db, ok := rep.dbRouter.lookup(info.ProfileID)
+	if !ok {
+		return nil, fmt.Errorf("%w: no database for profile %s", ErrClientNotFound, info.ProfileID)
+	}
+	return rep.withDB(db), nil
+}
+
+// withDB returns a copy of rep using db and its circuit breaker.
+func (rep *Replicache) withDB(db *sql.DB) *Replicache {
+	r := *rep
+	r.db = db
+	if rep.breakers != nil {
+		r.breaker = rep.breakers.forDB(db)
+	}
+	return &r
+}
+
+// databases returns the database of rep, if any, and every routed database
+// once.
+func (rep *Replicache) databases() []*sql.DB {
+	var dbs []*sql.DB
+	if rep.db != nil {
+		dbs = append(dbs, rep.db)
+	}
+	if rep.dbRouter != nil {
+		for _, db := range rep.dbRouter.all() {
+			if db != rep.db {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+	return dbs
+}
+
+// routeGroup returns a copy of rep using the database that holds
+// clientGroupID, for admin calls that only know the group. Without a
+// DBRouter, or when no database holds the group, it returns rep, or
+// ErrClientNotFound if rep has no database of its own.
+func (rep *Replicache) routeGroup(ctx context.Context, clientGroupID string) (*Replicache, error) {
+	if rep.dbRouter == nil {
+		return rep, nil
+	}
+	r, ok, err := rep.findGroup(ctx, clientGroupID)
+	switch {
+	case err != nil:
+		return nil, err
+	case ok:
+		return r, nil
+	case rep.db == nil:
+		return nil, fmt.Errorf("%w: client group %s", ErrClientNotFound, clientGroupID)
+	}
+	return rep, nil
+}
+
+// findGroup returns a copy of rep using the database that holds
+// clientGroupID, and whether any does.
+func (rep *Replicache) findGroup(ctx context.Context, clientGroupID string) (*Replicache, bool, error) {
+	for _, db := range rep.databases() {
+		var exists bool
+		if err := db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM replicache_version WHERE client_group_id = $1)
+			OR EXISTS (SELECT 1 FROM replicache_clients WHERE client_group_id = $1)`,
+			clientGroupID,
+		).Scan(&exists); err != nil {
+			return nil, false, err
+		}
+		if exists {
+			return rep.withDB(db), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// routeGroups is like routeGroup for calls that move clients from one client
+// group to another. Both groups must be in the same database; a target group
+// that doesn't exist yet is created next to the source group.
+func (rep *Replicache) routeGroups(ctx context.Context, fromGroupID, toGroupID string) (*Replicache, error) {
+	if rep.dbRouter == nil {
+		return rep, nil
+	}
+	from, err := rep.routeGroup(ctx, fromGroupID)
+	if err != nil {
+		return nil, err
+	}
+	to, ok, err := rep.findGroup(ctx, toGroupID)
+	if err != nil {
+		return nil, err
+	}
+	if ok && to.db != from.db {
+		return nil, fmt.Errorf("replicache: client groups %s and %s are in different databases", fromGroupID, toGroupID)
+	}
+	return from, nil
+}
@@ -0,0 +1,198 @@
+package replicache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDBRouterBreakerPerDatabase(t *testing.T) {
+	_, defaultDB := newFakeDB(t)
+	tenant, tenantDB := newFakeDB(t)
+	rep := newTestReplicache(t, defaultDB, testHandler{},
+		WithClientOnPush(true),
+		WithDBRouter(NewDBRouter().Register("tenant", tenantDB).RegisterDefault(defaultDB)),
+		WithCircuitBreaker(2, time.Minute, 10*time.Second),
+	)
+	push := func(profileID string) int {
+		req := pushRequest("group-"+profileID, mutation("client-"+profileID, 1, "m"))
+		req.ProfileID = profileID
+		return post(t, rep.PushHandler(), req).Code
+	}
+
+	tenant.failBegin(sqlStateError("08006"))
+	push("tenant")
+	push("tenant")
+	if code := push("tenant"); code != http.StatusServiceUnavailable {
+		t.Errorf("push to the failing database got %d, want 503", code)
+	}
+	if code := push("other"); code != http.StatusOK {
+		t.Errorf("push to the default database got %d while another database failed", code)
+	}
+	if s := rep.Stats(); s.CircuitState != CircuitOpen || s.CircuitOpens != 1 {
+		t.Errorf("stats report breaker %v after %d opens, want open once", s.CircuitState, s.CircuitOpens)
+	}
+}
+
+func TestDBRouterAdminCalls(t *testing.T) {
+	def, defaultDB := newFakeDB(t)
+	tenant, tenantDB := newFakeDB(t)
+	def.addClient("default-group", "a", 1)
+	tenant.addClient("tenant-group", "b", 2)
+	for _, f := range []*fakeDB{def, tenant} {
+		f.on(`SELECT profile_id, groups FROM replicache_profile_groups`, func(*fakeState, []driver.Value) (fakeResult, error) {
+			return rows([]driver.Value{"shared", int64(1)}), nil
+		})
+	}
+	rep := newTestReplicache(t, defaultDB, testHandler{},
+		WithDBRouter(NewDBRouter().Register("tenant", tenantDB).RegisterDefault(defaultDB)),
+	)
+	ctx := context.Background()
+
+	lmids, err := rep.LastMutationIDs(ctx, "tenant-group")
+	if err != nil || lmids["b"] != 2 {
+		t.Errorf("LastMutationIDs of the tenant group = %v, %v, want b at 2", lmids, err)
+	}
+	if lmids, _ := rep.LastMutationIDs(ctx, "default-group"); lmids["a"] != 1 {
+		t.Errorf("LastMutationIDs of the default group = %v, want a at 1", lmids)
+	}
+	counts, err := rep.ClientGroupCounts(ctx)
+	if err != nil || counts["shared"] != 2 {
+		t.Errorf("ClientGroupCounts = %v, %v, want the groups of both databases", counts, err)
+	}
+}
+
+func TestDBRouterGroupAdminCalls(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name  string
+		call  func(rep *Replicache) error
+		check func(t *testing.T, tenant *fakeDB)
+	}{
+		{
+			name: "ForceFullPull",
+			call: func(rep *Replicache) error { return rep.ForceFullPull(ctx, "tenant-group") },
+			check: func(t *testing.T, tenant *fakeDB) {
+				if g, _ := tenant.group("tenant-group"); g.resetVersion == 0 {
+					t.Error("reset version of the tenant group wasn't set")
+				}
+			},
+		},
+		{
+			name: "ForceLastMutationID",
+			call: func(rep *Replicache) error { return rep.ForceLastMutationID(ctx, "tenant-group", "b", 7) },
+			check: func(t *testing.T, tenant *fakeDB) {
+				if c, _ := tenant.client("tenant-group", "b"); c.lastMutationID != 7 {
+					t.Errorf("last mutation ID of b = %d, want 7", c.lastMutationID)
+				}
+			},
+		},
+		{
+			name: "MoveClient",
+			call: func(rep *Replicache) error { return rep.MoveClient(ctx, "b", "tenant-group", "tenant-other") },
+			check: func(t *testing.T, tenant *fakeDB) {
+				if _, ok := tenant.client("tenant-other", "b"); !ok {
+					t.Error("b wasn't moved in the tenant database")
+				}
+			},
+		},
+		{
+			name: "MigrateClients",
+			call: func(rep *Replicache) error { return rep.MigrateClients(ctx, "tenant-group", "tenant-other") },
+			check: func(t *testing.T, tenant *fakeDB) {
+				if _, ok := tenant.client("tenant-other", "b"); !ok {
+					t.Error("b wasn't migrated in the tenant database")
+				}
+			},
+		},
+		{
+			name: "ExportClientGroup",
+			call: func(rep *Replicache) error {
+				export, err := rep.ExportClientGroup(ctx, "tenant-group")
+				if err == nil && (len(export.Clients) != 1 || export.Clients[0].ClientID != "b") {
+					return fmt.Errorf("exported clients %v, want b", export.Clients)
+				}
+				return err
+			},
+		},
+		{
+			name: "ImportClientGroup",
+			call: func(rep *Replicache) error {
+				return rep.ImportClientGroup(ctx, GroupExport{
+					Format:        groupExportFormat,
+					ClientGroupID: "tenant-new",
+					ProfileID:     "tenant",
+					Version:       3,
+					Clients:       []ClientExport{{ClientID: "c", LastMutationID: 2, LastModifiedVersion: 3}},
+				}, false)
+			},
+			check: func(t *testing.T, tenant *fakeDB) {
+				if _, ok := tenant.client("tenant-new", "c"); !ok {
+					t.Error("new group wasn't imported into the tenant database")
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, withDefault := range []bool{true, false} {
+				def, defaultDB := newFakeDB(t)
+				tenant, tenantDB := newFakeDB(t)
+				def.addClient("default-group", "a", 1)
+				tenant.addClient("tenant-group", "b", 2)
+				router := NewDBRouter().Register("tenant", tenantDB)
+				db := defaultDB
+				if withDefault {
+					router.RegisterDefault(defaultDB)
+				} else {
+					db = nil
+				}
+				rep := newTestReplicache(t, db, testHandler{}, WithDBRouter(router))
+
+				if err := tc.call(rep); err != nil {
+					t.Fatalf("default database %v: %v", withDefault, err)
+				}
+				if tc.check != nil {
+					tc.check(t, tenant)
+				}
+				if begins, _, _ := def.txCounts(); begins != 0 {
+					t.Errorf("call began %d transactions on the default database", begins)
+				}
+			}
+		})
+	}
+}
+
+func TestDBRouterListClientGroups(t *testing.T) {
+	def, defaultDB := newFakeDB(t)
+	tenant, tenantDB := newFakeDB(t)
+	def.addClient("a", "a1", 1)
+	def.addClient("c", "c1", 1)
+	tenant.addClient("b", "b1", 2)
+	tenant.addClient("d", "d1", 2)
+	rep := newTestReplicache(t, defaultDB, testHandler{},
+		WithDBRouter(NewDBRouter().Register("tenant", tenantDB).RegisterDefault(defaultDB)),
+	)
+
+	var ids []string
+	cursor := ""
+	for {
+		groups, next, err := rep.ListClientGroups(context.Background(), cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, g := range groups {
+			ids = append(ids, g.ClientGroupID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !slices.Equal(ids, []string{"a", "b", "c", "d"}) {
+		t.Errorf("listed groups %q, want those of both databases in order", ids)
+	}
+}
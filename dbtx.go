@@ -0,0 +1,43 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is the set of query methods sqlc generated code expects, matching the
+// DBTX interface sqlc emits for database/sql. The Tx of PushRequest and
+// PullRequest satisfies it, so generated queries can run in the push or pull
+// transaction without an adapter:
+//
+//	func (h *handler) HandlePush(ctx context.Context, pr replicache.PushRequest) error {
+//		q := db.New(pr.Tx) // sqlc generated package
+//		...
+//	}
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// the transaction handed to handlers must keep satisfying DBTX
+var _ DBTX = (*sql.Tx)(nil)
+
+// savepoint, rollbackToSavepoint and releaseSavepoint wrap the Postgres
+// savepoint statements. name is interpolated into the SQL, so it must be a
+// constant identifier.
+func savepoint(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, `SAVEPOINT `+name)
+	return err
+}
+
+func rollbackToSavepoint(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT `+name)
+	return err
+}
+
+func releaseSavepoint(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT `+name)
+	return err
+}
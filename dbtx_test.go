@@ -0,0 +1,54 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"testing"
+)
+
+// sqlcDBTX is the interface sqlc emits for database/sql, copied verbatim so
+// that a drift between it and the transaction handed to handlers fails to
+// compile.
+type sqlcDBTX interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+var (
+	_ sqlcDBTX = (*sql.Tx)(nil)
+	_ sqlcDBTX = DBTX(nil)
+	_ DBTX     = sqlcDBTX(nil)
+)
+
+// sqlcQueries mimics a sqlc generated package.
+type sqlcQueries struct{ db sqlcDBTX }
+
+func newSQLCQueries(db sqlcDBTX) *sqlcQueries { return &sqlcQueries{db: db} }
+
+func (q *sqlcQueries) CreateTodo(ctx context.Context, title string) error {
+	_, err := q.db.ExecContext(ctx, `INSERT INTO todos (title) VALUES ($1)`, title)
+	return err
+}
+
+func TestSQLCQueriesInPushTransaction(t *testing.T) {
+	f, db := newFakeDB(t)
+	var titles []string
+	f.on(`INSERT INTO todos`, func(_ *fakeState, args []driver.Value) (fakeResult, error) {
+		titles = append(titles, str(args[0]))
+		return fakeResult{affected: 1}, nil
+	})
+	rep := newTestReplicache(t, db, testHandler{push: func(ctx context.Context, pr PushRequest) error {
+		return newSQLCQueries(pr.Tx).CreateTodo(ctx, "buy milk")
+	}}, WithClientOnPush(true))
+
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "create"))); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(titles) != 1 || titles[0] != "buy milk" {
+		t.Errorf("generated query inserted %q", titles)
+	}
+}
@@ -0,0 +1,94 @@
+package replicache
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const defaultDeduplicateByArgsTTL = 5 * time.Minute
+
+// argsHash hashes the name and canonical args of m, so re-sends that only
+// reformat their args are still recognized.
+func argsHash(m Mutation) string {
+	args := m.Args
+	if canonical, err := CanonicalizeArgs(args); err == nil {
+		args = canonical
+	}
+	h := sha256.New()
+	h.Write([]byte(m.Name))
+	h.Write([]byte{0})
+	h.Write(args)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// dropDuplicateArgs removes mutations whose name and args match a mutation
+// applied for the same client within the deduplication TTL, or an earlier
+// mutation of the same push. Skipped mutations still count as applied for
+// the purposes of lastMutationID. The args of the kept mutations are only
+// recorded by recordArgs once they were applied.
+func (rep *Replicache) dropDuplicateArgs(ctx context.Context, tx *sql.Tx, clientGroupID string, mutations []Mutation) ([]Mutation, error) {
+	if !rep.dedupeByArgs {
+		return mutations, nil
+	}
+
+	type argsKey struct{ clientID, hash string }
+	cutoff := rep.clock.Now().Add(-rep.dedupeByArgsTTL)
+	kept := mutations[:0:0]
+	seen := make(map[argsKey]bool, len(mutations))
+	for _, m := range mutations {
+		key := argsKey{m.ClientID, argsHash(m)}
+		if seen[key] {
+			continue
+		}
+
+		var found int
+		err := tx.QueryRowContext(ctx,
+			`SELECT 1 FROM replicache_mutation_args_cache WHERE client_group_id = $1 AND client_id = $2 AND args_hash = $3 AND applied_at > $4`,
+			clientGroupID, m.ClientID, key.hash, cutoff,
+		).Scan(&found)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return nil, err
+		default:
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, m)
+	}
+	return kept, nil
+}
+
+// recordArgs remembers the args of mutations the handler applied within tx,
+// and drops the expired ones of their clients.
+func (rep *Replicache) recordArgs(ctx context.Context, tx *sql.Tx, clientGroupID string, applied []Mutation) error {
+	if !rep.dedupeByArgs {
+		return nil
+	}
+
+	now := rep.clock.Now()
+	purged := make(map[string]bool)
+	for _, m := range applied {
+		if !purged[m.ClientID] {
+			if _, err := tx.ExecContext(ctx,
+				`DELETE FROM replicache_mutation_args_cache WHERE client_group_id = $1 AND client_id = $2 AND applied_at <= $3`,
+				clientGroupID, m.ClientID, now.Add(-rep.dedupeByArgsTTL),
+			); err != nil {
+				return err
+			}
+			purged[m.ClientID] = true
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO replicache_mutation_args_cache (client_group_id, client_id, args_hash, applied_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (client_group_id, client_id, args_hash) DO UPDATE SET applied_at = EXCLUDED.applied_at`,
+			clientGroupID, m.ClientID, argsHash(m), now,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
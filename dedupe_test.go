@@ -0,0 +1,94 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// rejectFirst is a QuotaChecker that rejects the first mutation it sees.
+type rejectFirst struct{ checked *int }
+
+func (q rejectFirst) Check(context.Context, *sql.Tx, ClientInfo, int64) error {
+	*q.checked++
+	if *q.checked == 1 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func TestDeduplicateByArgs(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		_, db := newFakeDB(t)
+		clock := newFakeClock()
+		var applied []string
+		options := []Option{
+			WithClientOnPush(true),
+			WithDeduplicateByArgs(true),
+			withClock(clock),
+		}
+		if streaming {
+			options = append(options, WithStreamingPush())
+		}
+		rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+			for _, m := range pr.Mutations {
+				applied = append(applied, pr.ClientGroupID+"/"+m.ClientID)
+			}
+			return nil
+		}}, options...)
+		push := func(group string, mutations ...Mutation) {
+			t.Helper()
+			if w := post(t, rep.PushHandler(), pushRequest(group, mutations...)); w.Code != http.StatusOK {
+				t.Fatalf("streaming %v: push status = %d: %s", streaming, w.Code, w.Body)
+			}
+		}
+
+		// a re-send under new IDs, in the same push and in a later one
+		push("a", mutation("client", 1, "m"), mutation("client", 2, "m"))
+		push("a", mutation("client", 3, "m"))
+		// args are kept per client group, so a client moved to another
+		// group starts over
+		push("b", mutation("other", 1, "m"))
+		if err := rep.MoveClient(context.Background(), "client", "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		push("b", mutation("client", 4, "m"))
+		// and the args are forgotten after the TTL
+		clock.Advance(defaultDeduplicateByArgsTTL)
+		push("b", mutation("client", 5, "m"))
+
+		if got, want := strings.Join(applied, " "), "a/client b/other b/client b/client"; got != want {
+			t.Errorf("streaming %v: applied %s, want %s", streaming, got, want)
+		}
+	}
+}
+
+func TestDeduplicateByArgsAfterRejection(t *testing.T) {
+	f, db := newFakeDB(t)
+	var checked, handled int
+	rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+		handled += len(pr.Mutations)
+		return nil
+	}},
+		WithClientOnPush(true),
+		WithDeduplicateByArgs(true),
+		WithQuotaChecker(rejectFirst{&checked}, QuotaPerMutation),
+	)
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+	if !strings.Contains(w.Body.String(), "quota_exceeded") {
+		t.Fatalf("first push got %d %s, want quota_exceeded", w.Code, w.Body)
+	}
+	if n := f.count("INSERT INTO replicache_mutation_args_cache"); n != 0 {
+		t.Errorf("args of a rejected mutation were recorded %d times", n)
+	}
+	// the client retries the rejected mutation under a new ID
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 2, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("retry status = %d: %s", w.Code, w.Body)
+	}
+	if handled != 1 {
+		t.Errorf("handler applied %d mutations, want the retry of the rejected one", handled)
+	}
+}
@@ -0,0 +1,158 @@
+package replicache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultDuplicateWindowTTL is how long an idle client's window is kept when
+// no client purge duration is configured.
+const defaultDuplicateWindowTTL = 24 * time.Hour
+
+// DuplicateDetector remembers the last N applied mutation IDs of each client
+// in memory, so re-sent mutations can be skipped without a database lookup.
+// Windows of clients that have been idle for the TTL are evicted.
+type DuplicateDetector struct {
+	size      int
+	ttl       time.Duration
+	clock     clock
+	clients   sync.Map // client ID -> *mutationWindow
+	lastSweep atomic.Int64
+}
+
+// mutationWindow is a ring buffer of the most recently applied mutation IDs
+// of one client.
+type mutationWindow struct {
+	mu       sync.Mutex
+	ids      []int64
+	head     int
+	last     int64
+	lastSeen time.Time
+
+	// evicted is set when a sweep removes the window, so a Record that
+	// loaded it just before stores a new one instead.
+	evicted bool
+}
+
+// NewDuplicateDetector returns a detector that keeps the last size mutation
+// IDs of each client and forgets clients idle for ttl.
+func NewDuplicateDetector(size int, ttl time.Duration) *DuplicateDetector {
+	return newDuplicateDetector(size, ttl, realClock{})
+}
+
+func newDuplicateDetector(size int, ttl time.Duration, clock clock) *DuplicateDetector {
+	d := &DuplicateDetector{size: size, ttl: ttl, clock: clock}
+	d.lastSweep.Store(clock.Now().UnixNano())
+	return d
+}
+
+// Seen reports whether mutation id of clientID is in the client's window.
+func (d *DuplicateDetector) Seen(clientID string, id int64) bool {
+	v, ok := d.clients.Load(clientID)
+	if !ok {
+		return false
+	}
+	w := v.(*mutationWindow)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, seen := range w.ids {
+		if seen == id && id != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Record adds every ID up to lastMutationID that hasn't been recorded yet to
+// the window of clientID. It must only be called once the mutations have
+// been committed.
+func (d *DuplicateDetector) Record(clientID string, lastMutationID int64) {
+	now := d.clock.Now()
+	var w *mutationWindow
+	for {
+		v, _ := d.clients.LoadOrStore(clientID, &mutationWindow{ids: make([]int64, d.size)})
+		w = v.(*mutationWindow)
+		w.mu.Lock()
+		if !w.evicted {
+			break
+		}
+		w.mu.Unlock()
+	}
+	first := max(w.last+1, lastMutationID-int64(d.size)+1)
+	for id := first; id <= lastMutationID; id++ {
+		w.ids[w.head] = id
+		w.head = (w.head + 1) % d.size
+	}
+	w.last = max(w.last, lastMutationID)
+	if now.After(w.lastSeen) {
+		w.lastSeen = now
+	}
+	w.mu.Unlock()
+
+	d.sweep(now)
+}
+
+// Forget drops the window of clientID, for clients whose last mutation ID
+// was changed outside of a push.
+func (d *DuplicateDetector) Forget(clientID string) {
+	d.clients.Delete(clientID)
+}
+
+// sweep evicts idle windows at most once per TTL. Windows recorded after
+// the sweep started are never idle, however long the sweep takes.
+func (d *DuplicateDetector) sweep(start time.Time) {
+	last := d.lastSweep.Load()
+	if start.Sub(time.Unix(0, last)) < d.ttl || !d.lastSweep.CompareAndSwap(last, start.UnixNano()) {
+		return
+	}
+	d.clients.Range(func(key, v any) bool {
+		w := v.(*mutationWindow)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.lastSeen.Before(start) && start.Sub(w.lastSeen) >= d.ttl {
+			w.evicted = true
+			d.clients.CompareAndDelete(key, w)
+		}
+		return true
+	})
+}
+
+// dropSeenMutations removes mutations found in the duplicate detector's
+// windows.
+func (rep *Replicache) dropSeenMutations(mutations []Mutation) []Mutation {
+	if rep.duplicates == nil {
+		return mutations
+	}
+	fresh := make([]Mutation, 0, len(mutations))
+	for _, m := range mutations {
+		if rep.duplicates.Seen(m.ClientID, int64(m.ID)) {
+			rep.counters.skippedMutations.Add(1)
+			continue
+		}
+		fresh = append(fresh, m)
+	}
+	return fresh
+}
+
+// recordApplied adds committed last mutation IDs to the duplicate detector.
+func (rep *Replicache) recordApplied(lastMutationIDs map[string]int64) {
+	if rep.duplicates == nil {
+		return
+	}
+	for clientID, lmid := range lastMutationIDs {
+		rep.duplicates.Record(clientID, lmid)
+	}
+}
+
+// forgetDuplicates drops the duplicate detector's windows of clients whose
+// state was changed outside of a push. It must only be called once the
+// change has been committed.
+func (rep *Replicache) forgetDuplicates(clientIDs ...string) {
+	if rep.duplicates == nil {
+		return
+	}
+	for _, clientID := range clientIDs {
+		rep.duplicates.Forget(clientID)
+	}
+}
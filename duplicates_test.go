@@ -0,0 +1,76 @@
+package replicache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuplicateDetectorPurge(t *testing.T) {
+	clock := newFakeClock()
+	d := newDuplicateDetector(4, time.Hour, clock)
+
+	d.Record("a", 1)
+	clock.Advance(30 * time.Minute)
+	d.Record("b", 1)
+	clock.Advance(31 * time.Minute)
+	// sweeps, a has been idle for an hour
+	d.Record("c", 1)
+	if d.Seen("a", 1) {
+		t.Error("idle window of a wasn't purged")
+	}
+	if !d.Seen("b", 1) || !d.Seen("c", 1) {
+		t.Error("windows of b and c were purged before their TTL")
+	}
+
+	// b is idle now, but the last sweep was only 30 minutes ago
+	clock.Advance(30 * time.Minute)
+	d.Record("c", 2)
+	if !d.Seen("b", 1) {
+		t.Error("window of b was purged before the next sweep was due")
+	}
+	clock.Advance(31 * time.Minute)
+	d.Record("c", 3)
+	if d.Seen("b", 1) {
+		t.Error("idle window of b wasn't purged by the next sweep")
+	}
+	if !d.Seen("c", 3) {
+		t.Error("window of c was purged while in use")
+	}
+
+	// a purged client starts a new window
+	d.Record("a", 5)
+	if !d.Seen("a", 5) {
+		t.Error("window of a wasn't recreated after the purge")
+	}
+}
+
+func TestDuplicateDetectorSweepSkipsRecentWindows(t *testing.T) {
+	clock := newFakeClock()
+	d := newDuplicateDetector(4, time.Hour, clock)
+	start := clock.Now()
+	clock.Advance(2 * time.Hour)
+	d.Record("a", 1)
+
+	// a was recorded while a sweep that started earlier was still running
+	d.lastSweep.Store(start.Add(-2 * time.Hour).UnixNano())
+	d.sweep(start)
+	if !d.Seen("a", 1) {
+		t.Error("sweep purged a window recorded after it started")
+	}
+}
+
+func TestDuplicateDetectorUsesClock(t *testing.T) {
+	_, db := newFakeDB(t)
+	clock := newFakeClock()
+	rep := newTestReplicache(t, db, testHandler{},
+		WithDuplicateWindowSize(4),
+		withClock(clock),
+	)
+
+	rep.recordApplied(map[string]int64{"a": 1})
+	clock.Advance(defaultDuplicateWindowTTL)
+	rep.recordApplied(map[string]int64{"b": 1})
+	if rep.duplicates.Seen("a", 1) {
+		t.Error("window of a wasn't purged after the fake clock passed its TTL")
+	}
+}
@@ -0,0 +1,28 @@
+package replicache
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Encoder writes JSON values. *json.Encoder and the encoders of most
+// third-party JSON packages satisfy it.
+type Encoder interface {
+	Encode(v any) error
+}
+
+// defaultEncoder is the standard library encoder without HTML escaping, so
+// <, > and & in values aren't inflated to \u003c escapes.
+func defaultEncoder(w io.Writer) Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc
+}
+
+// writeJSON writes v with the configured encoder.
+func (rep *Replicache) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	rep.newEncoder(w).Encode(v)
+}
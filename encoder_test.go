@@ -0,0 +1,96 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// richTextPull is a pull response of rich-text documents, the payload HTML
+// escaping inflates the most.
+func richTextPull() *PullResponse {
+	resp := &PullResponse{Cookie: 7, LastMutationIDChanges: map[string]int64{"client": 3}}
+	for i := 0; i < 200; i++ {
+		var doc bytes.Buffer
+		defaultEncoder(&doc).Encode(map[string]any{
+			"title": fmt.Sprintf("Document %d", i),
+			"body":  strings.Repeat(`<p>Tom &amp; Jerry <b>bold</b> <a href="https://example.com/?a=1&b=2">link</a></p>`, 20),
+		})
+		resp.Patch = append(resp.Patch, PatchOperation{Op: "put", Key: fmt.Sprintf("doc/%d", i), Value: doc.Bytes()})
+	}
+	return resp
+}
+
+func TestDefaultEncoderKeepsHTML(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{pull: func(context.Context, PullRequest) (any, error) {
+		return &PullResponse{Patch: []PatchOperation{{Op: "put", Key: "doc", Value: json.RawMessage(`"<b>a & b</b>"`)}}}, nil
+	}})
+	w := post(t, rep.PullHandler(), pullRequest("g", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("pull status = %d: %s", w.Code, w.Body)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"<b>a & b</b>"`) {
+		t.Errorf("pull body escaped HTML: %s", body)
+	}
+}
+
+// prefixEncoder marks everything it writes, so tests can tell it was used.
+type prefixEncoder struct{ w io.Writer }
+
+func (e prefixEncoder) Encode(v any) error {
+	io.WriteString(e.w, "custom:")
+	return json.NewEncoder(e.w).Encode(v)
+}
+
+func TestWithJSONEncoder(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{}, WithJSONEncoder(func(w io.Writer) Encoder { return prefixEncoder{w} }))
+
+	if w := post(t, rep.PullHandler(), pullRequest("g", nil)); !strings.HasPrefix(w.Body.String(), "custom:") {
+		t.Errorf("pull response wasn't written by the configured encoder: %s", w.Body)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+	r.Header.Set("Content-Encoding", "br")
+	w := httptest.NewRecorder()
+	rep.PushHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType || !strings.HasPrefix(w.Body.String(), "custom:") {
+		t.Errorf("error body wasn't written by the configured encoder: %d %s", w.Code, w.Body)
+	}
+	if _, err := NewReplicache(db, testHandler{}, WithJSONEncoder(nil)); err == nil {
+		t.Error("WithJSONEncoder(nil) was accepted")
+	}
+}
+
+// BenchmarkEncodePull compares the size and cost of a rich-text pull
+// response with and without HTML escaping.
+func BenchmarkEncodePull(b *testing.B) {
+	resp := richTextPull()
+	for _, bc := range []struct {
+		name       string
+		escapeHTML bool
+	}{
+		{"default", false},
+		{"escapeHTML", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				enc := json.NewEncoder(&buf)
+				enc.SetEscapeHTML(bc.escapeHTML)
+				if err := enc.Encode(resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len()), "bytes/response")
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package replicache
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxDecompressedBytes caps the decompressed size of compressed
+// request bodies unless WithMaxDecompressedBodyBytes is set.
+const defaultMaxDecompressedBytes = 32 << 20
+
+var (
+	// ErrUnsupportedEncoding is returned, with a 415 response, for request
+	// bodies with a Content-Encoding other than gzip or identity. zstd is
+	// not supported since the standard library has no decoder for it.
+	ErrUnsupportedEncoding = errors.New("replicache: unsupported content encoding")
+
+	// ErrBodyTooLarge is returned, with a 413 response, when a compressed
+	// request body decompresses to more than the configured cap.
+	ErrBodyTooLarge = errors.New("replicache: request body too large")
+)
+
+// decodeRequestBody replaces r.Body with its decompressed form according to
+// its Content-Encoding. Any limit already applied to r.Body, such as an
+// http.MaxBytesReader, keeps applying to the compressed bytes, and the
+// decompressed bytes are capped separately so a small body can't expand
+// without bound.
+func (rep *Replicache) decodeRequestBody(r *http.Request) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return fmt.Errorf("replicache: invalid gzip body: %w", err)
+		}
+		r.Body = io.NopCloser(&cappedReader{r: zr, left: rep.maxDecompressedBytes})
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// cappedReader fails with ErrBodyTooLarge once more than left bytes have
+// been read from r.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		var one [1]byte
+		if n, _ := io.ReadFull(c.r, one[:]); n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+// writeDecodeError responds to a request body that couldn't be decoded.
+// Bodies over the decompression cap get a 413; anything else is a 500, as
+// before compressed bodies were supported.
+func (rep *Replicache) writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrBodyTooLarge) {
+		rep.writeError(w, r, err)
+		return
+	}
+	rep.respond(w, r, http.StatusInternalServerError, nil, err)
+}
@@ -0,0 +1,117 @@
+package replicache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipped compresses b.
+func gzipped(t testing.TB, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// postEncoded sends body with the given Content-Encoding and no
+// Content-Length to h.
+func postEncoded(h http.Handler, encoding string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Encoding", encoding)
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestGzipPushCountsCompressedSize(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		_, db := newFakeDB(t)
+		var size int64
+		options := []Option{
+			WithClientOnPush(true),
+			WithPushSizeAccounting(func(_ context.Context, _ string, n int64) { size = n }),
+		}
+		if streaming {
+			options = append(options, WithStreamingPush())
+		}
+		rep := newTestReplicache(t, db, testHandler{}, options...)
+		b, err := json.Marshal(pushRequest("group", mutation("client", 1, "m"), mutation("client", 2, "m")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := gzipped(t, b)
+
+		if w := postEncoded(rep.PushHandler(), "gzip", body); w.Code != http.StatusOK {
+			t.Fatalf("streaming %v: push status = %d: %s", streaming, w.Code, w.Body)
+		}
+		if size != int64(len(body)) {
+			t.Errorf("streaming %v: accounted %d bytes, want the %d compressed bytes", streaming, size, len(body))
+		}
+	}
+}
+
+func TestGzipBomb(t *testing.T) {
+	// 16 MiB of JSON that compresses to a few KiB
+	var b strings.Builder
+	b.WriteString(`{"pushVersion":1,"clientGroupID":"group","profileID":"profile","mutations":[{"clientID":"client","id":1,"name":"m","args":"`)
+	b.WriteString(strings.Repeat("0", 16<<20))
+	b.WriteString(`"}]}`)
+	body := gzipped(t, []byte(b.String()))
+	if len(body) > 64<<10 {
+		t.Fatalf("bomb compressed to %d bytes", len(body))
+	}
+
+	for _, streaming := range []bool{false, true} {
+		_, db := newFakeDB(t)
+		var called bool
+		options := []Option{WithClientOnPush(true), WithMaxDecompressedBodyBytes(1 << 20)}
+		if streaming {
+			options = append(options, WithStreamingPush())
+		}
+		rep := newTestReplicache(t, db, testHandler{push: func(context.Context, PushRequest) error {
+			called = true
+			return nil
+		}}, options...)
+		// the compressed body is well within the request limit
+		limited := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+			rep.PushHandler().ServeHTTP(w, r)
+		})
+
+		w := postEncoded(limited, "gzip", body)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("streaming %v: push status = %d, want %d", streaming, w.Code, http.StatusRequestEntityTooLarge)
+		}
+		if called {
+			t.Errorf("streaming %v: handler ran for a body over the decompressed cap", streaming)
+		}
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{})
+	b, err := json.Marshal(pullRequest("group", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, h := range map[string]http.Handler{"push": rep.PushHandler(), "pull": rep.PullHandler()} {
+		w := postEncoded(h, "zstd", b)
+		if w.Code != http.StatusUnsupportedMediaType || !strings.Contains(w.Body.String(), "UnsupportedContentEncoding") {
+			t.Errorf("%s with zstd body got %d %s, want %d", name, w.Code, w.Body, http.StatusUnsupportedMediaType)
+		}
+	}
+}
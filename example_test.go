@@ -0,0 +1,40 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+)
+
+// LastMutationIDs lets a test check which clients a push advanced without
+// querying the replicache tables itself.
+func ExampleReplicache_LastMutationIDs() {
+	_, db := openFakeDB() // a Postgres database in a real test
+	defer db.Close()
+
+	rep, err := NewReplicache(db, testHandler{}, WithClientOnPush(true))
+	if err != nil {
+		panic(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"pushVersion":   1,
+		"clientGroupID": "group",
+		"mutations": []Mutation{
+			{ClientID: "tab", ID: 1, Name: "createTodo"},
+			{ClientID: "tab", ID: 2, Name: "createTodo"},
+			{ClientID: "worker", ID: 1, Name: "syncTodos"},
+		},
+	})
+	w := httptest.NewRecorder()
+	rep.PushHandler().ServeHTTP(w, httptest.NewRequest("POST", "/replicache/push", bytes.NewReader(body)))
+
+	lastMutationIDs, err := rep.LastMutationIDs(context.Background(), "group")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(w.Code, lastMutationIDs)
+	// Output: 200 map[tab:2 worker:1]
+}
@@ -0,0 +1,268 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// groupExportFormat is the GroupExport format written by ExportClientGroup.
+// Bump it when fields change meaning so old exports are refused.
+const groupExportFormat = 1
+
+// ErrClientGroupExists is returned by ImportClientGroup when the client group
+// already has state and force isn't set.
+var ErrClientGroupExists = errors.New("replicache: client group already exists")
+
+// GroupExport is the sync state of a client group as written by
+// ExportClientGroup. It encodes to JSON for moving a group between
+// environments.
+type GroupExport struct {
+	Format        int            `json:"format"`
+	ExportedAt    time.Time      `json:"exportedAt"`
+	ClientGroupID string         `json:"clientGroupID"`
+	ProfileID     string         `json:"profileID,omitempty"`
+	Owner         string         `json:"owner,omitempty"`
+	OwnerIssuedAt *time.Time     `json:"ownerIssuedAt,omitempty"`
+	SchemaVersion string         `json:"schemaVersion,omitempty"`
+	Version       int64          `json:"version"`
+	ResetVersion  int64          `json:"resetVersion,omitempty"`
+	Clients       []ClientExport `json:"clients"`
+
+	// CVR is only exported when a CVRStore is configured.
+	CVR map[string]string `json:"cvr,omitempty"`
+}
+
+// ClientExport is one client of a GroupExport.
+type ClientExport struct {
+	ClientID            string          `json:"clientID"`
+	LastMutationID      int64           `json:"lastMutationID"`
+	LastModifiedVersion int64           `json:"lastModifiedVersion"`
+	Metadata            json.RawMessage `json:"metadata,omitempty"`
+}
+
+// ExportClientGroup reads the sync state of a client group from one snapshot.
+// It returns ErrClientNotFound if the group doesn't exist.
+func (rep *Replicache) ExportClientGroup(ctx context.Context, clientGroupID string) (GroupExport, error) {
+	rep, err := rep.routeGroup(ctx, clientGroupID)
+	if err != nil {
+		return GroupExport{}, err
+	}
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return GroupExport{}, err
+	}
+	defer tx.Rollback()
+
+	export := GroupExport{
+		Format:        groupExportFormat,
+		ExportedAt:    time.Now().UTC(),
+		ClientGroupID: clientGroupID,
+		Clients:       []ClientExport{},
+	}
+	var profileID, owner, schemaVersion sql.NullString
+	var ownerIssuedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`SELECT version, reset_version, profile_id, owner, owner_issued_at, schema_version FROM replicache_version WHERE client_group_id = $1`,
+		clientGroupID,
+	).Scan(&export.Version, &export.ResetVersion, &profileID, &owner, &ownerIssuedAt, &schemaVersion)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return GroupExport{}, fmt.Errorf("%w: client group %s", ErrClientNotFound, clientGroupID)
+	case err != nil:
+		return GroupExport{}, err
+	}
+	export.ProfileID = profileID.String
+	export.Owner = owner.String
+	if ownerIssuedAt.Valid {
+		export.OwnerIssuedAt = &ownerIssuedAt.Time
+	}
+	export.SchemaVersion = schemaVersion.String
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT client_id, last_mutation_id, last_modified_version, metadata FROM replicache_clients WHERE client_group_id = $1 ORDER BY client_id`,
+		clientGroupID,
+	)
+	if err != nil {
+		return GroupExport{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ClientExport
+		var metadata []byte
+		if err := rows.Scan(&c.ClientID, &c.LastMutationID, &c.LastModifiedVersion, &metadata); err != nil {
+			return GroupExport{}, err
+		}
+		c.Metadata = metadata
+		export.Clients = append(export.Clients, c)
+	}
+	if err := rows.Err(); err != nil {
+		return GroupExport{}, err
+	}
+
+	if rep.cvrStore != nil {
+		if export.CVR, err = rep.cvrStore.GetCVR(ctx, tx, clientGroupID); err != nil {
+			return GroupExport{}, err
+		}
+	}
+	return export, nil
+}
+
+// ImportClientGroup writes an export made by ExportClientGroup in one
+// transaction. The export is checked for consistency before anything is
+// written. A group that already has state is refused with
+// ErrClientGroupExists unless force is set, in which case its state is
+// replaced, and clients registered in another group are refused with
+// ErrClientGroupMismatch. Imported groups count towards their profile's group limit but
+// never evict other groups.
+func (rep *Replicache) ImportClientGroup(ctx context.Context, export GroupExport, force bool) error {
+	if err := export.validate(); err != nil {
+		return err
+	}
+	rep, err := rep.routeImport(ctx, export)
+	if err != nil {
+		return err
+	}
+
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM replicache_version WHERE client_group_id = $1)
+		OR EXISTS (SELECT 1 FROM replicache_clients WHERE client_group_id = $1)`,
+		export.ClientGroupID,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if len(export.Clients) > 0 {
+		clientIDs := make([]string, len(export.Clients))
+		for i, c := range export.Clients {
+			clientIDs[i] = c.ClientID
+		}
+		if err := rep.checkClientGroup(ctx, tx, export.ClientGroupID, clientIDs); err != nil {
+			return err
+		}
+	}
+	var replaced map[string]int64
+	if exists {
+		if !force {
+			return fmt.Errorf("%w: %s", ErrClientGroupExists, export.ClientGroupID)
+		}
+		if replaced, err = lockGroupClients(ctx, tx, export.ClientGroupID); err != nil {
+			return err
+		}
+		if err := deleteClientGroup(ctx, tx, export.ClientGroupID); err != nil {
+			return err
+		}
+	}
+
+	var ownerIssuedAt sql.NullTime
+	if export.OwnerIssuedAt != nil {
+		ownerIssuedAt = nullTime(*export.OwnerIssuedAt)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO replicache_version (client_group_id, version, reset_version, profile_id, owner, owner_issued_at, schema_version) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		export.ClientGroupID, export.Version, export.ResetVersion, nullString(export.ProfileID), nullString(export.Owner), ownerIssuedAt, nullString(export.SchemaVersion),
+	); err != nil {
+		return err
+	}
+	if export.ProfileID != "" {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO replicache_profile_groups (profile_id, groups) VALUES ($1, 1)
+			ON CONFLICT (profile_id) DO UPDATE SET groups = replicache_profile_groups.groups + 1`,
+			export.ProfileID,
+		); err != nil {
+			return err
+		}
+	}
+	now := rep.clock.Now()
+	for _, c := range export.Clients {
+		var metadata any
+		if len(c.Metadata) > 0 {
+			metadata = []byte(c.Metadata)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO replicache_clients (client_id, client_group_id, last_mutation_id, last_modified_version, metadata, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+			c.ClientID, export.ClientGroupID, c.LastMutationID, c.LastModifiedVersion, metadata, now,
+		); err != nil {
+			return err
+		}
+	}
+	if rep.cvrStore != nil && export.CVR != nil {
+		if err := rep.cvrStore.SetCVR(ctx, tx, export.ClientGroupID, export.CVR); err != nil {
+			return err
+		}
+	}
+	if err := rep.commit(tx); err != nil {
+		return err
+	}
+	rep.clientCache.invalidate(export.ClientGroupID)
+	for clientID := range replaced {
+		rep.forgetDuplicates(clientID)
+	}
+	for _, c := range export.Clients {
+		rep.forgetDuplicates(c.ClientID)
+	}
+	return nil
+}
+
+// routeImport returns a copy of rep using the database that holds the
+// client group of export or, for a new group, the database of its profile.
+func (rep *Replicache) routeImport(ctx context.Context, export GroupExport) (*Replicache, error) {
+	if rep.dbRouter == nil {
+		return rep, nil
+	}
+	r, ok, err := rep.findGroup(ctx, export.ClientGroupID)
+	if err != nil || ok {
+		return r, err
+	}
+	return rep.routeDB(ClientInfo{ProfileID: export.ProfileID})
+}
+
+// validate checks that an export is internally consistent.
+func (e GroupExport) validate() error {
+	if e.Format != groupExportFormat {
+		return fmt.Errorf("replicache: unsupported export format %d", e.Format)
+	}
+	if e.ClientGroupID == "" {
+		return errors.New("replicache: export has no client group ID")
+	}
+	if e.Version < 0 {
+		return fmt.Errorf("replicache: export has negative version %d", e.Version)
+	}
+	if e.ResetVersion < 0 || e.ResetVersion > e.Version {
+		return fmt.Errorf("replicache: export has reset version %d outside of the group version %d", e.ResetVersion, e.Version)
+	}
+	if e.OwnerIssuedAt != nil && e.Owner == "" {
+		return errors.New("replicache: export has an owner issue time without owner")
+	}
+	seen := make(map[string]bool, len(e.Clients))
+	for _, c := range e.Clients {
+		switch {
+		case c.ClientID == "":
+			return errors.New("replicache: export has a client without ID")
+		case seen[c.ClientID]:
+			return fmt.Errorf("replicache: export has client %s twice", c.ClientID)
+		case c.LastMutationID < 0:
+			return fmt.Errorf("replicache: client %s has negative last mutation ID", c.ClientID)
+		case c.LastModifiedVersion > e.Version:
+			return fmt.Errorf("replicache: client %s was modified at version %d, after the group version %d", c.ClientID, c.LastModifiedVersion, e.Version)
+		case len(c.Metadata) > 0 && !json.Valid(c.Metadata):
+			return fmt.Errorf("replicache: client %s has invalid metadata", c.ClientID)
+		}
+		seen[c.ClientID] = true
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,112 @@
+package replicache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithIdentityChangePolicy(sessionIdentity, IdentityChangeReject),
+	)
+	req := pushRequest("group", mutation("a", 1, "m"), mutation("a", 2, "m"), mutation("b", 1, "m"))
+	req.SchemaVersion = "v1"
+	if w := postAs(t, rep.PushHandler(), "alice@1700000000", req); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	if err := rep.ForceFullPull(context.Background(), "group"); err != nil {
+		t.Fatal(err)
+	}
+	export, err := rep.ExportClientGroup(context.Background(), "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.OwnerIssuedAt == nil || !export.OwnerIssuedAt.Equal(time.Unix(1700000000, 0)) || export.ResetVersion == 0 {
+		t.Errorf("export = %+v, want the owner's issue time and the reset version", export)
+	}
+
+	// through JSON, as between environments
+	b, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded GroupExport
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	g, db2 := newFakeDB(t)
+	rep2 := newTestReplicache(t, db2, testHandler{})
+	if err := rep2.ImportClientGroup(context.Background(), decoded, false); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := f.group("group")
+	got, ok := g.group("group")
+	if !ok {
+		t.Fatal("imported group doesn't exist")
+	}
+	if got.version != want.version || got.resetVersion != want.resetVersion || got.profileID != want.profileID ||
+		got.owner != want.owner || got.schemaVersion != want.schemaVersion {
+		t.Errorf("imported group = %+v, want %+v", got, want)
+	}
+	if iat, ok := got.ownerIssuedAt.(time.Time); !ok || !iat.Equal(want.ownerIssuedAt.(time.Time)) {
+		t.Errorf("imported owner issued at %v, want %v", got.ownerIssuedAt, want.ownerIssuedAt)
+	}
+	for _, id := range []string{"a", "b"} {
+		want, _ := f.client("group", id)
+		got, ok := g.client("group", id)
+		if !ok || got.lastMutationID != want.lastMutationID || got.lastModifiedVersion != want.lastModifiedVersion {
+			t.Errorf("imported client %s = %+v, want %+v", id, got, want)
+		}
+	}
+
+	// and exported again unchanged
+	again, err := rep2.ExportClientGroup(context.Background(), "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.ExportedAt = export.ExportedAt
+	if !reflect.DeepEqual(again, decoded) {
+		t.Errorf("re-export = %+v, want %+v", again, decoded)
+	}
+}
+
+func TestImportRejectsForeignClients(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.addClient("other", "a", 1)
+	rep := newTestReplicache(t, db, testHandler{})
+	export := GroupExport{
+		Format:        groupExportFormat,
+		ClientGroupID: "group",
+		Version:       1,
+		Clients:       []ClientExport{{ClientID: "a", LastMutationID: 5}},
+	}
+
+	for _, force := range []bool{false, true} {
+		if err := rep.ImportClientGroup(context.Background(), export, force); !errors.Is(err, ErrClientGroupMismatch) {
+			t.Errorf("force %v: import of a client of another group = %v, want ErrClientGroupMismatch", force, err)
+		}
+	}
+	if _, ok := f.group("group"); ok {
+		t.Error("refused import created the group")
+	}
+	if c, _ := f.client("other", "a"); c.lastMutationID != 1 {
+		t.Errorf("client of the other group changed to %+v", c)
+	}
+}
+
+func TestImportValidatesResetVersion(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{})
+	export := GroupExport{Format: groupExportFormat, ClientGroupID: "group", Version: 2, ResetVersion: 3}
+	if err := rep.ImportClientGroup(context.Background(), export, false); err == nil {
+		t.Error("import of a reset version ahead of the group version succeeded")
+	}
+}
@@ -0,0 +1,931 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB is an in-memory database/sql driver standing in for Postgres in
+// tests. Each statement is answered by the first rule whose pattern is
+// contained in it, after collapsing whitespace. The default rules model the
+// replicache tables closely enough for the push and pull paths; tests add
+// rules with on, which take precedence. Transactions work on a copy of the
+// state that replaces it on commit, so rolled back work disappears, and
+// savepoints nest the same way. Unmatched statements affect no rows and
+// return no rows.
+type fakeDB struct {
+	mu        sync.Mutex
+	state     *fakeState
+	rules     []fakeRule
+	log       []string
+	begins    int
+	commits   int
+	rollbacks int
+	beginErr  error
+}
+
+// fakeState is the content of the fake database.
+type fakeState struct {
+	groups        map[string]*fakeGroup
+	clients       map[fakeClientKey]*fakeClient
+	profileGroups map[string]int64
+	cvrs          map[string][]byte
+	args          map[fakeArgsKey]time.Time
+}
+
+type fakeClientKey struct{ group, client string }
+
+type fakeArgsKey struct{ group, client, hash string }
+
+type fakeGroup struct {
+	version       int64
+	profileID     any
+	owner         any
+	ownerIssuedAt any
+	schemaVersion any
+	resetVersion  int64
+	lastPushAt    time.Time
+	lastPullAt    time.Time
+}
+
+type fakeClient struct {
+	lastMutationID      int64
+	lastModifiedVersion int64
+	metadata            []byte
+	createdAt           time.Time
+	updatedAt           time.Time
+}
+
+type fakeRule struct {
+	pattern string
+	fn      fakeRuleFunc
+}
+
+// fakeRuleFunc answers a statement. Queries see rows, Exec sees affected,
+// which defaults to the number of rows.
+type fakeRuleFunc func(s *fakeState, args []driver.Value) (fakeResult, error)
+
+type fakeResult struct {
+	rows     [][]driver.Value
+	affected int64
+}
+
+func rows(values ...[]driver.Value) fakeResult {
+	return fakeResult{rows: values, affected: int64(len(values))}
+}
+
+func row(values ...driver.Value) fakeResult { return rows(values) }
+
+// newFakeDB returns an empty fake database and a *sql.DB using it.
+func newFakeDB(t testing.TB) (*fakeDB, *sql.DB) {
+	f, db := openFakeDB()
+	t.Cleanup(func() { db.Close() })
+	return f, db
+}
+
+// openFakeDB is newFakeDB for examples, which must close db themselves.
+func openFakeDB() (*fakeDB, *sql.DB) {
+	f := &fakeDB{state: newFakeState(), rules: defaultFakeRules()}
+	return f, sql.OpenDB(f)
+}
+
+func newFakeState() *fakeState {
+	return &fakeState{
+		groups:        make(map[string]*fakeGroup),
+		clients:       make(map[fakeClientKey]*fakeClient),
+		profileGroups: make(map[string]int64),
+		cvrs:          make(map[string][]byte),
+		args:          make(map[fakeArgsKey]time.Time),
+	}
+}
+
+func (s *fakeState) clone() *fakeState {
+	c := newFakeState()
+	for id, g := range s.groups {
+		g := *g
+		c.groups[id] = &g
+	}
+	for key, cl := range s.clients {
+		cl := *cl
+		c.clients[key] = &cl
+	}
+	c.profileGroups = maps.Clone(s.profileGroups)
+	c.cvrs = maps.Clone(s.cvrs)
+	c.args = maps.Clone(s.args)
+	return c
+}
+
+// on answers statements containing pattern with fn ahead of every rule
+// added before.
+func (f *fakeDB) on(pattern string, fn fakeRuleFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append([]fakeRule{{normalizeSQL(pattern), fn}}, f.rules...)
+}
+
+// fail makes statements containing pattern fail with err.
+func (f *fakeDB) fail(pattern string, err error) {
+	f.on(pattern, func(*fakeState, []driver.Value) (fakeResult, error) { return fakeResult{}, err })
+}
+
+// failBegin makes beginning transactions fail with err until it is called
+// with nil.
+func (f *fakeDB) failBegin(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.beginErr = err
+}
+
+// addClient stores a client, creating its group.
+func (f *fakeDB) addClient(group, client string, lastMutationID int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.state.groups[group] == nil {
+		f.state.groups[group] = &fakeGroup{}
+	}
+	f.state.clients[fakeClientKey{group, client}] = &fakeClient{lastMutationID: lastMutationID}
+}
+
+func (f *fakeDB) client(group, client string) (fakeClient, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.state.clients[fakeClientKey{group, client}]
+	if !ok {
+		return fakeClient{}, false
+	}
+	return *c, true
+}
+
+func (f *fakeDB) group(id string) (fakeGroup, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, ok := f.state.groups[id]
+	if !ok {
+		return fakeGroup{}, false
+	}
+	return *g, true
+}
+
+// count returns how many logged statements contain pattern.
+func (f *fakeDB) count(pattern string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pattern = normalizeSQL(pattern)
+	n := 0
+	for _, q := range f.log {
+		if strings.Contains(q, pattern) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeDB) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.log...)
+}
+
+func (f *fakeDB) txCounts() (begins, commits, rollbacks int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.begins, f.commits, f.rollbacks
+}
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func normalizeSQL(q string) string { return strings.TrimSpace(spaces.ReplaceAllString(q, " ")) }
+
+// run answers query against s.
+func (f *fakeDB) run(s *fakeState, query string, args []driver.NamedValue) (fakeResult, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	f.mu.Lock()
+	f.log = append(f.log, query)
+	rules := f.rules
+	f.mu.Unlock()
+	for _, r := range rules {
+		if strings.Contains(query, r.pattern) {
+			return r.fn(s, values)
+		}
+	}
+	return fakeResult{}, nil
+}
+
+// Connect implements driver.Connector.
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+
+// Driver implements driver.Connector.
+func (f *fakeDB) Driver() driver.Driver { return fakeDriver{f} }
+
+type fakeDriver struct{ db *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{db: d.db}, nil }
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+
+type fakeTx struct {
+	conn       *fakeConn
+	state      *fakeState
+	savepoints []fakeSavepoint
+}
+
+type fakeSavepoint struct {
+	name  string
+	state *fakeState
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if c.db.beginErr != nil {
+		return nil, c.db.beginErr
+	}
+	c.db.begins++
+	c.tx = &fakeTx{conn: c, state: c.db.state.clone()}
+	return c.tx, nil
+}
+
+func (tx *fakeTx) Commit() error {
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.commits++
+	db.state = tx.state
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.rollbacks++
+	tx.conn.tx = nil
+	return nil
+}
+
+// savepoint handles the savepoint statements, reporting whether query was
+// one.
+func (tx *fakeTx) savepoint(query string) (bool, error) {
+	switch {
+	case strings.HasPrefix(query, "SAVEPOINT "):
+		tx.savepoints = append(tx.savepoints, fakeSavepoint{strings.TrimPrefix(query, "SAVEPOINT "), tx.state.clone()})
+	case strings.HasPrefix(query, "ROLLBACK TO SAVEPOINT "):
+		i := tx.findSavepoint(strings.TrimPrefix(query, "ROLLBACK TO SAVEPOINT "))
+		if i < 0 {
+			return true, errors.New("fakedb: no such savepoint")
+		}
+		tx.savepoints = tx.savepoints[:i+1]
+		tx.state = tx.savepoints[i].state.clone()
+	case strings.HasPrefix(query, "RELEASE SAVEPOINT "):
+		i := tx.findSavepoint(strings.TrimPrefix(query, "RELEASE SAVEPOINT "))
+		if i < 0 {
+			return true, errors.New("fakedb: no such savepoint")
+		}
+		tx.savepoints = tx.savepoints[:i]
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (tx *fakeTx) findSavepoint(name string) int {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// exec runs query in the connection's transaction, or on its own against
+// the committed state.
+func (c *fakeConn) exec(ctx context.Context, query string, args []driver.NamedValue) (fakeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return fakeResult{}, err
+	}
+	query = normalizeSQL(query)
+	if c.tx != nil {
+		if ok, err := c.tx.savepoint(query); ok {
+			c.db.mu.Lock()
+			c.db.log = append(c.db.log, query)
+			c.db.mu.Unlock()
+			return fakeResult{}, err
+		}
+		return c.db.run(c.tx.state, query, args)
+	}
+	// statements outside a transaction see and publish the committed state
+	// atomically
+	c.db.mu.Lock()
+	s := c.db.state.clone()
+	c.db.mu.Unlock()
+	res, err := c.db.run(s, query, args)
+	if err == nil {
+		c.db.mu.Lock()
+		c.db.state = s
+		c.db.mu.Unlock()
+	}
+	return res, err
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.exec(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.exec(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: res.rows}, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, a := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: a}
+	}
+	return nv
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	i    int
+}
+
+func (r *fakeRows) Columns() []string {
+	n := 1
+	if len(r.rows) > 0 {
+		n = len(r.rows[0])
+	}
+	cols := make([]string, n)
+	for i := range cols {
+		cols[i] = fmt.Sprintf("col%d", i)
+	}
+	return cols
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
+
+// sqlStateError is a database error carrying a Postgres SQLSTATE.
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "fakedb: SQLSTATE " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func str(v driver.Value) string {
+	s, _ := v.(string)
+	return s
+}
+
+func timeOrNil(t time.Time) driver.Value {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func num(v driver.Value) int64 {
+	n, _ := v.(int64)
+	return n
+}
+
+// clientRows returns client_id, last_mutation_id rows for the clients of
+// group matching keep, ordered by client ID.
+func (s *fakeState) clientRows(group string, keep func(id string, c *fakeClient) bool) fakeResult {
+	var ids []string
+	for key, c := range s.clients {
+		if key.group == group && keep(key.client, c) {
+			ids = append(ids, key.client)
+		}
+	}
+	sort.Strings(ids)
+	res := fakeResult{}
+	for _, id := range ids {
+		res.rows = append(res.rows, []driver.Value{id, s.clients[fakeClientKey{group, id}].lastMutationID})
+	}
+	res.affected = int64(len(res.rows))
+	return res
+}
+
+func (s *fakeState) group(id string) *fakeGroup {
+	g, ok := s.groups[id]
+	if !ok {
+		g = &fakeGroup{}
+		s.groups[id] = g
+	}
+	return g
+}
+
+// defaultRule returns the default rule for statements containing pattern, so
+// tests can wrap it.
+func defaultRule(pattern string) fakeRuleFunc {
+	pattern = normalizeSQL(pattern)
+	for _, r := range defaultFakeRules() {
+		if strings.Contains(pattern, r.pattern) || strings.Contains(r.pattern, pattern) {
+			return r.fn
+		}
+	}
+	panic("fakedb: no default rule for " + pattern)
+}
+
+// defaultFakeRules model the statements of the push and pull paths. More
+// specific patterns come first.
+func defaultFakeRules() []fakeRule {
+	rules := []fakeRule{
+		{`SELECT client_group_id, COUNT(*), SUM(last_mutation_id), MAX(updated_at) FROM replicache_clients WHERE client_group_id > $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			type summary struct {
+				clients, mutations int64
+				active             time.Time
+			}
+			groups := make(map[string]*summary)
+			for key, c := range s.clients {
+				if key.group <= str(args[0]) {
+					continue
+				}
+				g, ok := groups[key.group]
+				if !ok {
+					g = &summary{}
+					groups[key.group] = g
+				}
+				g.clients++
+				g.mutations += c.lastMutationID
+				if c.updatedAt.After(g.active) {
+					g.active = c.updatedAt
+				}
+			}
+			var ids []string
+			for id := range groups {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			var res fakeResult
+			for _, id := range ids[:min(len(ids), int(num(args[1])))] {
+				g := groups[id]
+				res.rows = append(res.rows, []driver.Value{id, g.clients, g.mutations, g.active})
+			}
+			return res, nil
+		}},
+		{`SELECT client_id, last_mutation_id, last_modified_version, metadata FROM replicache_clients WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			var res fakeResult
+			for _, r := range s.clientRows(str(args[0]), func(string, *fakeClient) bool { return true }).rows {
+				c := s.clients[fakeClientKey{str(args[0]), str(r[0])}]
+				res.rows = append(res.rows, []driver.Value{r[0], c.lastMutationID, c.lastModifiedVersion, c.metadata})
+			}
+			return res, nil
+		}},
+		{`SELECT version, reset_version, profile_id, owner, owner_issued_at, schema_version FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[0])]; ok {
+				return row(g.version, g.resetVersion, g.profileID, g.owner, g.ownerIssuedAt, g.schemaVersion), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`INSERT INTO replicache_version (client_group_id, version, reset_version, profile_id, owner, owner_issued_at, schema_version)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			s.groups[str(args[0])] = &fakeGroup{version: num(args[1]), resetVersion: num(args[2]), profileID: args[3], owner: args[4], ownerIssuedAt: args[5], schemaVersion: args[6]}
+			return fakeResult{affected: 1}, nil
+		}},
+		{`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND client_id IN (`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			want := make(map[string]bool)
+			for _, a := range args[1:] {
+				want[str(a)] = true
+			}
+			return s.clientRows(str(args[0]), func(id string, _ *fakeClient) bool { return want[id] }), nil
+		}},
+		{`SELECT client_id, client_group_id FROM replicache_clients WHERE client_group_id <> $1 AND client_id IN (`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			for _, a := range args[1:] {
+				for key := range s.clients {
+					if key.client == str(a) && key.group != str(args[0]) {
+						return row(key.client, key.group), nil
+					}
+				}
+			}
+			return fakeResult{}, nil
+		}},
+		{`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND last_modified_version > $2`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			return s.clientRows(str(args[0]), func(_ string, c *fakeClient) bool { return c.lastModifiedVersion > num(args[1]) }), nil
+		}},
+		{`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			return s.clientRows(str(args[0]), func(string, *fakeClient) bool { return true }), nil
+		}},
+		{`SELECT last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND client_id = $2`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if c, ok := s.clients[fakeClientKey{str(args[0]), str(args[1])}]; ok {
+				return row(c.lastMutationID), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`INSERT INTO replicache_clients (client_id, client_group_id, last_mutation_id, metadata, created_at, updated_at) VALUES`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			var res fakeResult
+			for i := 0; i+6 <= len(args); i += 6 {
+				key := fakeClientKey{str(args[i+1]), str(args[i])}
+				if _, ok := s.clients[key]; ok {
+					continue
+				}
+				metadata, _ := args[i+3].([]byte)
+				created, _ := args[i+4].(time.Time)
+				updated, _ := args[i+5].(time.Time)
+				s.clients[key] = &fakeClient{lastMutationID: num(args[i+2]), metadata: metadata, createdAt: created, updatedAt: updated}
+				res.rows = append(res.rows, []driver.Value{key.client})
+			}
+			res.affected = int64(len(res.rows))
+			return res, nil
+		}},
+		{`INSERT INTO replicache_clients (client_id, client_group_id, last_mutation_id, last_modified_version, metadata, created_at, updated_at)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			metadata, _ := args[4].([]byte)
+			s.clients[fakeClientKey{str(args[1]), str(args[0])}] = &fakeClient{
+				lastMutationID:      num(args[2]),
+				lastModifiedVersion: num(args[3]),
+				metadata:            metadata,
+				createdAt:           args[5].(time.Time),
+				updatedAt:           args[5].(time.Time),
+			}
+			return fakeResult{affected: 1}, nil
+		}},
+		{`UPDATE replicache_clients SET last_mutation_id = $1, last_modified_version = $2, updated_at = $3 WHERE client_group_id = $4 AND client_id = $5 AND last_mutation_id <= $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			c, ok := s.clients[fakeClientKey{str(args[3]), str(args[4])}]
+			if !ok || c.lastMutationID > num(args[0]) {
+				return fakeResult{}, nil
+			}
+			c.lastMutationID, c.lastModifiedVersion = num(args[0]), num(args[1])
+			return row(c.lastMutationID), nil
+		}},
+		{`UPDATE replicache_clients SET last_mutation_id = $1, last_modified_version = $2, updated_at = $3 WHERE client_group_id = $4 AND client_id = $5`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			c, ok := s.clients[fakeClientKey{str(args[3]), str(args[4])}]
+			if !ok {
+				return fakeResult{}, nil
+			}
+			c.lastMutationID, c.lastModifiedVersion = num(args[0]), num(args[1])
+			return fakeResult{affected: 1}, nil
+		}},
+		{`UPDATE replicache_clients SET client_group_id = $1, updated_at = $2 WHERE client_group_id = $3 AND client_id = $4`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			key := fakeClientKey{str(args[2]), str(args[3])}
+			c, ok := s.clients[key]
+			if !ok {
+				return fakeResult{}, nil
+			}
+			delete(s.clients, key)
+			s.clients[fakeClientKey{str(args[0]), key.client}] = c
+			return fakeResult{affected: 1}, nil
+		}},
+		{`UPDATE replicache_clients SET client_group_id = $1, last_modified_version = $2, updated_at = $3 WHERE client_group_id = $4`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			var res fakeResult
+			for key, c := range s.clients {
+				if key.group == str(args[3]) {
+					delete(s.clients, key)
+					c.lastModifiedVersion = num(args[1])
+					s.clients[fakeClientKey{str(args[0]), key.client}] = c
+					res.affected++
+				}
+			}
+			return res, nil
+		}},
+		{`SELECT 1 FROM replicache_mutation_args_cache WHERE client_group_id = $1 AND client_id = $2 AND args_hash = $3 AND applied_at > $4`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if at, ok := s.args[fakeArgsKey{str(args[0]), str(args[1]), str(args[2])}]; ok && at.After(args[3].(time.Time)) {
+				return row(int64(1)), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`INSERT INTO replicache_mutation_args_cache (client_group_id, client_id, args_hash, applied_at)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			s.args[fakeArgsKey{str(args[0]), str(args[1]), str(args[2])}] = args[3].(time.Time)
+			return fakeResult{affected: 1}, nil
+		}},
+		{`DELETE FROM replicache_mutation_args_cache WHERE client_group_id = $1 AND client_id = $2 AND applied_at <= $3`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			var res fakeResult
+			for key, at := range s.args {
+				if key.group == str(args[0]) && key.client == str(args[1]) && !at.After(args[2].(time.Time)) {
+					delete(s.args, key)
+					res.affected++
+				}
+			}
+			return res, nil
+		}},
+		{`DELETE FROM replicache_mutation_args_cache WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			var res fakeResult
+			for key := range s.args {
+				if key.group == str(args[0]) {
+					delete(s.args, key)
+					res.affected++
+				}
+			}
+			return res, nil
+		}},
+		{`DELETE FROM replicache_clients WHERE client_group_id = $1 AND client_id = $2`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			delete(s.clients, fakeClientKey{str(args[0]), str(args[1])})
+			return fakeResult{affected: 1}, nil
+		}},
+		{`DELETE FROM replicache_clients WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			var res fakeResult
+			for key := range s.clients {
+				if key.group == str(args[0]) {
+					delete(s.clients, key)
+					res.affected++
+				}
+			}
+			return res, nil
+		}},
+		{`DELETE FROM replicache_version WHERE client_group_id = $1 RETURNING profile_id`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			g, ok := s.groups[str(args[0])]
+			if !ok {
+				return fakeResult{}, nil
+			}
+			delete(s.groups, str(args[0]))
+			return row(g.profileID), nil
+		}},
+		{`SELECT profile_id, owner, owner_issued_at FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[0])]; ok {
+				return row(g.profileID, g.owner, g.ownerIssuedAt), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`INSERT INTO replicache_version (client_group_id, version) VALUES ($1, 1)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			g := s.group(str(args[0]))
+			g.version++
+			return row(g.version), nil
+		}},
+		{`INSERT INTO replicache_version (client_group_id, profile_id, owner, owner_issued_at)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if _, ok := s.groups[str(args[0])]; ok {
+				return fakeResult{}, nil
+			}
+			s.groups[str(args[0])] = &fakeGroup{profileID: args[1], owner: args[2], ownerIssuedAt: args[3]}
+			return fakeResult{affected: 1}, nil
+		}},
+		{`SELECT client_group_id FROM replicache_version WHERE profile_id = $1 AND client_group_id <> $2`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			var oldest string
+			var seen time.Time
+			for id, g := range s.groups {
+				if g.profileID != args[0] || id == str(args[1]) {
+					continue
+				}
+				last := g.lastPushAt
+				if g.lastPullAt.After(last) {
+					last = g.lastPullAt
+				}
+				if oldest == "" || last.Before(seen) || last.Equal(seen) && id < oldest {
+					oldest, seen = id, last
+				}
+			}
+			if oldest == "" {
+				return fakeResult{}, nil
+			}
+			return row(oldest), nil
+		}},
+		{`INSERT INTO replicache_profile_groups (profile_id, groups) VALUES ($1, 1)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			s.profileGroups[str(args[0])]++
+			return row(s.profileGroups[str(args[0])]), nil
+		}},
+		{`UPDATE replicache_version SET version = version + 1 WHERE client_group_id = $1 RETURNING version`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			g, ok := s.groups[str(args[0])]
+			if !ok {
+				return fakeResult{}, nil
+			}
+			g.version++
+			return row(g.version), nil
+		}},
+		{`SELECT EXISTS (SELECT 1 FROM replicache_version WHERE client_group_id = $1)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			_, ok := s.groups[str(args[0])]
+			return row(ok), nil
+		}},
+		{`SELECT 1 FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if _, ok := s.groups[str(args[0])]; ok {
+				return row(int64(1)), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`UPDATE replicache_version SET version = version + 1, reset_version = version + 1 WHERE client_group_id = $1 RETURNING version`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			g, ok := s.groups[str(args[0])]
+			if !ok {
+				return fakeResult{}, nil
+			}
+			g.version++
+			g.resetVersion = g.version
+			return row(g.version), nil
+		}},
+		{`UPDATE replicache_version SET last_push_at = GREATEST(last_push_at, $2) WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			g, ok := s.groups[str(args[0])]
+			if !ok {
+				return fakeResult{}, nil
+			}
+			if t := args[1].(time.Time); t.After(g.lastPushAt) {
+				g.lastPushAt = t
+			}
+			return fakeResult{affected: 1}, nil
+		}},
+		{`UPDATE replicache_version SET last_pull_at = GREATEST(last_pull_at, $2) WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			g, ok := s.groups[str(args[0])]
+			if !ok {
+				return fakeResult{}, nil
+			}
+			if t := args[1].(time.Time); t.After(g.lastPullAt) {
+				g.lastPullAt = t
+			}
+			return fakeResult{affected: 1}, nil
+		}},
+		{`SELECT last_push_at, last_pull_at FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[0])]; ok {
+				return row(timeOrNil(g.lastPushAt), timeOrNil(g.lastPullAt)), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`SELECT version, reset_version FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[0])]; ok {
+				return row(g.version, g.resetVersion), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`SELECT version FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[0])]; ok {
+				return row(g.version), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`SELECT owner, owner_issued_at FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[0])]; ok {
+				return row(g.owner, g.ownerIssuedAt), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`UPDATE replicache_version SET owner = $1, owner_issued_at = $2 WHERE client_group_id = $3`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[2])]; ok {
+				g.owner, g.ownerIssuedAt = args[0], args[1]
+				return fakeResult{affected: 1}, nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`SELECT schema_version FROM replicache_version WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if g, ok := s.groups[str(args[0])]; ok {
+				return row(g.schemaVersion), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`UPDATE replicache_version SET schema_version = $1 WHERE client_group_id = $2`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			s.group(str(args[1])).schemaVersion = args[0]
+			return fakeResult{affected: 1}, nil
+		}},
+		{`SELECT cvr FROM replicache_cvr WHERE client_group_id = $1`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			if cvr, ok := s.cvrs[str(args[0])]; ok {
+				return row(cvr), nil
+			}
+			return fakeResult{}, nil
+		}},
+		{`INSERT INTO replicache_cvr (client_group_id, cvr, updated_at)`, func(s *fakeState, args []driver.Value) (fakeResult, error) {
+			cvr, _ := args[1].([]byte)
+			s.cvrs[str(args[0])] = cvr
+			return fakeResult{affected: 1}, nil
+		}},
+	}
+	for i := range rules {
+		rules[i].pattern = normalizeSQL(rules[i].pattern)
+	}
+	return rules
+}
+
+// pushFunc and pullFunc adapt functions to the handler interfaces.
+type pushFunc func(ctx context.Context, pr PushRequest) error
+
+func (f pushFunc) HandlePush(ctx context.Context, pr PushRequest) error { return f(ctx, pr) }
+
+type pullFunc func(ctx context.Context, pr PullRequest) (any, error)
+
+func (f pullFunc) HandlePull(ctx context.Context, pr PullRequest) (any, error) { return f(ctx, pr) }
+
+// testHandler is a Handler whose push and pull succeed unless set.
+type testHandler struct {
+	push pushFunc
+	pull pullFunc
+}
+
+func (h testHandler) HandlePush(ctx context.Context, pr PushRequest) error {
+	if h.push == nil {
+		return nil
+	}
+	return h.push(ctx, pr)
+}
+
+func (h testHandler) HandlePull(ctx context.Context, pr PullRequest) (any, error) {
+	if h.pull == nil {
+		return nil, nil
+	}
+	return h.pull(ctx, pr)
+}
+
+func newTestReplicache(t testing.TB, db *sql.DB, h Handler, options ...Option) *Replicache {
+	t.Helper()
+	rep, err := NewReplicache(db, h, options...)
+	if err != nil {
+		t.Fatalf("NewReplicache: %v", err)
+	}
+	return rep
+}
+
+// testPush is a push body as sent by the Replicache client, with fields in
+// the order streaming push expects.
+type testPush struct {
+	PushVersion   int        `json:"pushVersion"`
+	ClientGroupID string     `json:"clientGroupID"`
+	ProfileID     string     `json:"profileID"`
+	SchemaVersion string     `json:"schemaVersion,omitempty"`
+	Mutations     []Mutation `json:"mutations"`
+}
+
+func pushRequest(clientGroupID string, mutations ...Mutation) testPush {
+	if mutations == nil {
+		mutations = []Mutation{}
+	}
+	return testPush{PushVersion: 1, ClientGroupID: clientGroupID, ProfileID: "profile", Mutations: mutations}
+}
+
+// testPull is a pull body as sent by the Replicache client.
+type testPull struct {
+	PullVersion   int    `json:"pullVersion"`
+	ClientGroupID string `json:"clientGroupID"`
+	Cookie        any    `json:"cookie"`
+	ProfileID     string `json:"profileID"`
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+}
+
+func pullRequest(clientGroupID string, cookie any) testPull {
+	return testPull{PullVersion: 1, ClientGroupID: clientGroupID, Cookie: cookie, ProfileID: "profile"}
+}
+
+func mutation(clientID string, id int, name string) Mutation {
+	return Mutation{ClientID: clientID, ID: id, Name: name, Args: NullArgs}
+}
+
+// post sends body, marshaled unless it is a string, to h.
+func post(t testing.TB, h http.Handler, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var b []byte
+	if s, ok := body.(string); ok {
+		b = []byte(s)
+	} else {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+	return w
+}
@@ -0,0 +1,29 @@
+package replicache
+
+// freezer is implemented by handlers that accept configuration until they
+// serve their first request, like MutationRouter.
+type freezer interface {
+	Freeze()
+}
+
+// Freeze ends the configuration of rep: the push and pull handlers, and every
+// handler of a HandlerChain, stop accepting registrations.
+// It is called by the first push or pull, so registrations can't race with
+// requests; call it at the end of startup to make late registrations fail
+// there instead. Calling it again has no effect. A DBRouter stays open,
+// since tenants may be registered while serving.
+func (rep *Replicache) Freeze() {
+	rep.freeze.Do(func() {
+		freezeHandler(rep.pushHandler)
+		freezeHandler(rep.pullHandler)
+		if rep.shadow != nil {
+			freezeHandler(rep.shadow.handler)
+		}
+	})
+}
+
+func freezeHandler(h any) {
+	if f, ok := h.(freezer); ok {
+		f.Freeze()
+	}
+}
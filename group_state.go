@@ -0,0 +1,78 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GroupState is the stored state of a client group.
+type GroupState struct {
+	ClientGroupID string
+	ProfileID     string
+
+	// Owner is the identity the group is bound to by
+	// WithIdentityChangePolicy, if any.
+	Owner string
+
+	// Version is the client group version, bumped by every push that
+	// advances a last mutation ID.
+	Version int64
+
+	Clients map[string]ClientState
+}
+
+// ClientState is the stored state of one client of a GroupState.
+type ClientState struct {
+	LastMutationID int64
+
+	// LastSeen is when the client's last mutation ID last changed.
+	LastSeen time.Time
+}
+
+// ClientGroupState returns the stored state of a client group, for admin
+// tooling. It returns ErrClientNotFound if the group has no stored state.
+func (rep *Replicache) ClientGroupState(ctx context.Context, clientGroupID string) (GroupState, error) {
+	state, err := loadGroupState(ctx, rep.db, clientGroupID)
+	if err == nil && state.Version == 0 && len(state.Clients) == 0 {
+		return GroupState{}, ErrClientNotFound
+	}
+	return state, err
+}
+
+// loadGroupState reads the state of a client group through q, which is the
+// pull transaction when the state is handed to a pull handler. A group that
+// doesn't exist yet has an empty state.
+func loadGroupState(ctx context.Context, q DBTX, clientGroupID string) (GroupState, error) {
+	state := GroupState{ClientGroupID: clientGroupID, Clients: make(map[string]ClientState)}
+
+	var profileID, owner sql.NullString
+	err := q.QueryRowContext(ctx,
+		`SELECT version, profile_id, owner FROM replicache_version WHERE client_group_id = $1`,
+		clientGroupID,
+	).Scan(&state.Version, &profileID, &owner)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return GroupState{}, err
+	}
+	state.ProfileID = profileID.String
+	state.Owner = owner.String
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT client_id, last_mutation_id, updated_at FROM replicache_clients WHERE client_group_id = $1`,
+		clientGroupID,
+	)
+	if err != nil {
+		return GroupState{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var clientID string
+		var client ClientState
+		if err := rows.Scan(&clientID, &client.LastMutationID, &client.LastSeen); err != nil {
+			return GroupState{}, err
+		}
+		state.Clients[clientID] = client
+	}
+	return state, rows.Err()
+}
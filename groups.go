@@ -0,0 +1,263 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// ErrTooManyClientGroups is returned, with a 403 response, when a profile
+// would exceed the limit set by WithMaxClientGroupsPerProfile.
+var ErrTooManyClientGroups = errors.New("replicache: too many client groups for profile")
+
+// GroupLimitPolicy decides what happens when a profile creates more client
+// groups than allowed.
+type GroupLimitPolicy int
+
+const (
+	// GroupLimitReject refuses the request that would create the group.
+	GroupLimitReject GroupLimitPolicy = iota
+
+	// GroupLimitEvictOldest deletes the profile's longest idle client group
+	// to make room. Clients of the evicted group get ClientStateNotFound
+	// and start over.
+	GroupLimitEvictOldest
+)
+
+// ensureClientGroup creates the client group's row in replicache_version and
+// reports whether it didn't exist before. New groups count towards their
+// profile's limit, which is enforced here so the check and the insert share
+// a transaction.
+func (rep *Replicache) ensureClientGroup(ctx context.Context, tx *sql.Tx, info ClientInfo) (bool, error) {
+	defer rep.observeQuery(ctx, queryEnsureClientGroup, info.ClientGroupID)()
+	var profileID any
+	if info.ProfileID != "" {
+		profileID = info.ProfileID
+	}
+	owner, issuedAt, err := rep.identityOf(ctx, info)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO replicache_version (client_group_id, profile_id, owner, owner_issued_at) VALUES ($1, $2, $3, $4) ON CONFLICT (client_group_id) DO NOTHING`,
+		info.ClientGroupID, profileID, owner, issuedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 || info.ProfileID == "" {
+		return n == 1, err
+	}
+
+	// concurrent creations for one profile conflict on the counter row, so
+	// the limit can't be overshot
+	var groups int64
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO replicache_profile_groups (profile_id, groups) VALUES ($1, 1)
+		ON CONFLICT (profile_id) DO UPDATE SET groups = replicache_profile_groups.groups + 1
+		RETURNING groups`,
+		info.ProfileID,
+	).Scan(&groups); err != nil {
+		return false, err
+	}
+	if rep.maxGroupsPerProfile <= 0 || groups <= int64(rep.maxGroupsPerProfile) {
+		return true, nil
+	}
+
+	if rep.groupLimitPolicy == GroupLimitReject {
+		rep.logger.WarnContext(ctx, "replicache client group limit reached",
+			slog.String("profile_id", info.ProfileID),
+			slog.String("client_group_id", info.ClientGroupID),
+			slog.Int("limit", rep.maxGroupsPerProfile),
+		)
+		return false, fmt.Errorf("%w: %s has %d client groups", ErrTooManyClientGroups, info.ProfileID, groups-1)
+	}
+
+	var oldest string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT client_group_id FROM replicache_version
+		WHERE profile_id = $1 AND client_group_id <> $2
+		ORDER BY GREATEST(created_at, last_push_at, last_pull_at) LIMIT 1`,
+		info.ProfileID, info.ClientGroupID,
+	).Scan(&oldest); err != nil {
+		return false, err
+	}
+	if err := deleteClientGroup(ctx, tx, oldest); err != nil {
+		return false, err
+	}
+	rep.clientCache.invalidate(oldest)
+	rep.logger.WarnContext(ctx, "replicache evicted idle client group",
+		slog.String("profile_id", info.ProfileID),
+		slog.String("client_group_id", info.ClientGroupID),
+		slog.String("evicted_client_group_id", oldest),
+		slog.Int("limit", rep.maxGroupsPerProfile),
+	)
+	return true, nil
+}
+
+// inheritClientGroup creates toGroupID, if it doesn't exist, with the
+// profile and owner of fromGroupID. There is no request to take them from
+// when clients are migrated, and fromGroupID is deleted afterwards, so the
+// profile's group count is unchanged and no group is evicted.
+func inheritClientGroup(ctx context.Context, tx *sql.Tx, fromGroupID, toGroupID string) error {
+	var profileID, owner sql.NullString
+	var issuedAt sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		`SELECT profile_id, owner, owner_issued_at FROM replicache_version WHERE client_group_id = $1`,
+		fromGroupID,
+	).Scan(&profileID, &owner, &issuedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO replicache_version (client_group_id, profile_id, owner, owner_issued_at) VALUES ($1, $2, $3, $4) ON CONFLICT (client_group_id) DO NOTHING`,
+		toGroupID, profileID, owner, issuedAt,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 || !profileID.Valid {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO replicache_profile_groups (profile_id, groups) VALUES ($1, 1)
+		ON CONFLICT (profile_id) DO UPDATE SET groups = replicache_profile_groups.groups + 1`,
+		profileID.String,
+	)
+	return err
+}
+
+// deleteClientGroup deletes a client group with its clients and CVR.
+func deleteClientGroup(ctx context.Context, tx *sql.Tx, clientGroupID string) error {
+	for _, stmt := range []string{
+		`DELETE FROM replicache_mutation_args_cache WHERE client_group_id = $1`,
+		`DELETE FROM replicache_clients WHERE client_group_id = $1`,
+		`DELETE FROM replicache_cvr WHERE client_group_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, clientGroupID); err != nil {
+			return err
+		}
+	}
+
+	var profileID sql.NullString
+	err := tx.QueryRowContext(ctx,
+		`DELETE FROM replicache_version WHERE client_group_id = $1 RETURNING profile_id`,
+		clientGroupID,
+	).Scan(&profileID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows), err == nil && !profileID.Valid:
+		return nil
+	case err != nil:
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE replicache_profile_groups SET groups = groups - 1 WHERE profile_id = $1`,
+		profileID.String,
+	)
+	return err
+}
+
+// ClientGroupCounts returns the number of client groups created by each
+// profile, to help spot profiles creating groups abusively. With a DBRouter
+// the counts of every routed database are added up.
+func (rep *Replicache) ClientGroupCounts(ctx context.Context) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, db := range rep.databases() {
+		if err := countClientGroups(ctx, db, counts); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+func countClientGroups(ctx context.Context, db *sql.DB, counts map[string]int64) error {
+	rows, err := db.QueryContext(ctx, `SELECT profile_id, groups FROM replicache_profile_groups WHERE groups > 0`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profileID string
+		var groups int64
+		if err := rows.Scan(&profileID, &groups); err != nil {
+			return err
+		}
+		counts[profileID] += groups
+	}
+	return rows.Err()
+}
+
+// ClientGroupSummary describes a client group in ListClientGroups.
+type ClientGroupSummary struct {
+	ClientGroupID string
+	Clients       int64
+
+	// TotalMutations is the sum of the last mutation IDs of the group's
+	// clients, which is the number of mutations the group has pushed.
+	TotalMutations int64
+
+	// LastActive is when a client of the group last changed.
+	LastActive time.Time
+}
+
+// ListClientGroups returns up to limit client groups that have clients,
+// ordered by ID, starting after the page that returned cursor. Pass an empty
+// cursor for the first page. nextCursor is empty after the last page. Pages
+// are found by ID rather than offset, so listing stays fast on large tables.
+// With a DBRouter, the groups of every routed database are listed together.
+func (rep *Replicache) ListClientGroups(ctx context.Context, cursor string, limit int) (groups []ClientGroupSummary, nextCursor string, err error) {
+	if limit <= 0 {
+		return nil, "", errors.New("replicache: limit must be positive")
+	}
+	after, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("replicache: invalid cursor: %w", err)
+	}
+
+	// every database returns its first page, of which the overall first
+	// page is taken
+	for _, db := range rep.databases() {
+		page, err := listClientGroups(ctx, db, string(after), limit)
+		if err != nil {
+			return nil, "", err
+		}
+		groups = append(groups, page...)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ClientGroupID < groups[j].ClientGroupID })
+	groups = groups[:min(len(groups), limit)]
+	if len(groups) == limit {
+		nextCursor = base64.RawURLEncoding.EncodeToString([]byte(groups[len(groups)-1].ClientGroupID))
+	}
+	return groups, nextCursor, nil
+}
+
+func listClientGroups(ctx context.Context, db *sql.DB, after string, limit int) ([]ClientGroupSummary, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT client_group_id, COUNT(*), SUM(last_mutation_id), MAX(updated_at)
+		FROM replicache_clients WHERE client_group_id > $1
+		GROUP BY client_group_id ORDER BY client_group_id LIMIT $2`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []ClientGroupSummary
+	for rows.Next() {
+		var g ClientGroupSummary
+		if err := rows.Scan(&g.ClientGroupID, &g.Clients, &g.TotalMutations, &g.LastActive); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
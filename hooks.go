@@ -0,0 +1,71 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrHookTimeout is returned when a hook that runs inside the request
+// transaction overran the timeout set by WithHookTimeout.
+var ErrHookTimeout = errors.New("replicache: hook exceeded timeout")
+
+// hookName identifies a user hook in slow hook logs and Stats.
+type hookName string
+
+const (
+	hookNewGroup      hookName = "new_group"
+	hookNewClient     hookName = "new_client"
+	hookPreRollback   hookName = "pre_rollback"
+	hookSchemaUpgrade hookName = "schema_upgrade"
+	hookQuota         hookName = "quota"
+	hookIdentity      hookName = "identity"
+)
+
+// runHook runs fn with a context bounded by the hook timeout, if one is set,
+// and logs the hook when it overruns. Hooks are not interrupted: the deadline
+// only tells fn to give up, and an overrunning hook never fails the request.
+func (rep *Replicache) runHook(ctx context.Context, name hookName, clientGroupID string, fn func(ctx context.Context)) {
+	rep.timeHook(ctx, name, clientGroupID, func(ctx context.Context) error {
+		fn(ctx)
+		return nil
+	})
+}
+
+// runTxHook runs a hook that takes part in the request transaction like
+// runHook, but fails with ErrHookTimeout when the hook overruns, so the
+// request is aborted instead of committing late.
+func (rep *Replicache) runTxHook(ctx context.Context, name hookName, clientGroupID string, fn func(ctx context.Context) error) error {
+	elapsed, err := rep.timeHook(ctx, name, clientGroupID, fn)
+	if rep.hookTimeout > 0 && elapsed >= rep.hookTimeout {
+		return fmt.Errorf("%w: %s hook took %s", ErrHookTimeout, name, elapsed)
+	}
+	return err
+}
+
+// timeHook runs fn for runHook and runTxHook and records how long it took.
+func (rep *Replicache) timeHook(ctx context.Context, name hookName, clientGroupID string, fn func(ctx context.Context) error) (time.Duration, error) {
+	if rep.hookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rep.hookTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := fn(ctx)
+	elapsed := time.Since(start)
+	rep.counters.hooks.record(name, elapsed)
+	if rep.hookTimeout <= 0 || elapsed < rep.hookTimeout {
+		return elapsed, err
+	}
+	rep.counters.slowHooks.Add(1)
+	rep.logger.WarnContext(ctx, "replicache hook exceeded timeout",
+		slog.String("hook", string(name)),
+		slog.Duration("duration", elapsed),
+		slog.Duration("timeout", rep.hookTimeout),
+		slog.String("client_group_id", clientGroupID),
+	)
+	return elapsed, err
+}
@@ -0,0 +1,82 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowQuota is a QuotaChecker that waits for its context to expire and then
+// accepts the push anyway.
+type slowQuota struct{}
+
+func (slowQuota) Check(ctx context.Context, _ *sql.Tx, _ ClientInfo, _ int64) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestSlowTxHookAbortsPush(t *testing.T) {
+	for _, tc := range []struct {
+		hook   string
+		option Option
+	}{
+		{"quota", WithQuotaChecker(slowQuota{}, QuotaPerPush)},
+		{"identity", WithIdentityChangePolicy(func(ctx context.Context, _ ClientInfo) (Identity, error) {
+			<-ctx.Done()
+			return Identity{ID: "alice"}, nil
+		}, IdentityChangeReject)},
+		{"schema_upgrade", WithSchemaVersionUpgradeHandler(func(ctx context.Context, _ *sql.Tx, _, _ string) error {
+			<-ctx.Done()
+			return nil
+		})},
+	} {
+		f, db := newFakeDB(t)
+		f.on(`INSERT INTO replicache_schema_migrations`, func(*fakeState, []driver.Value) (fakeResult, error) {
+			return fakeResult{affected: 1}, nil
+		})
+		var called bool
+		rep := newTestReplicache(t, db, testHandler{push: func(context.Context, PushRequest) error {
+			called = true
+			return nil
+		}}, WithClientOnPush(true), WithHookTimeout(10*time.Millisecond), tc.option)
+		req := pushRequest("group", mutation("client", 1, "m"))
+		req.SchemaVersion = "v1"
+
+		_, commits, _ := f.txCounts()
+		if w := post(t, rep.PushHandler(), req); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: push with a slow hook status = %d, want %d", tc.hook, w.Code, http.StatusServiceUnavailable)
+		}
+		if _, c, _ := f.txCounts(); c != commits {
+			t.Errorf("%s: push with a slow hook committed", tc.hook)
+		}
+		if called {
+			t.Errorf("%s: push handler ran after a slow hook", tc.hook)
+		}
+		stats := rep.Stats()
+		if stats.SlowHookCount != 1 || stats.Hooks[tc.hook].Calls != 1 || stats.Hooks[tc.hook].Max < 10*time.Millisecond {
+			t.Errorf("%s: stats = %d slow hooks, timings %+v", tc.hook, stats.SlowHookCount, stats.Hooks)
+		}
+	}
+}
+
+func TestHookTimings(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithQuotaChecker(SQLQuotaChecker{LimitBytes: 1 << 20}, QuotaPerMutation),
+		WithNewClientHook(func(context.Context, ClientInfo, string) {}),
+	)
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m"), mutation("client", 2, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	hooks := rep.Stats().Hooks
+	if hooks["quota"].Calls != 2 || hooks["new_client"].Calls != 1 || len(hooks) != 2 {
+		t.Errorf("hook timings = %+v, want 2 quota and 1 new_client calls", hooks)
+	}
+	if hooks["quota"].Total < hooks["quota"].Max {
+		t.Errorf("quota total %s is below its max %s", hooks["quota"].Total, hooks["quota"].Max)
+	}
+}
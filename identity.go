@@ -0,0 +1,151 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrIdentityChanged is returned, with a 403 response, when a client group is
+// used by a different identity than the one it is bound to and the policy is
+// IdentityChangeReject.
+var ErrIdentityChanged = errors.New("replicache: client group bound to another identity")
+
+// Identity is the user making a request.
+type Identity struct {
+	ID string
+
+	// IssuedAt is when the user's session was issued, such as the iat
+	// claim of a JWT. Under IdentityChangeRebind a client group only moves
+	// to a session issued after the one it is bound to, so pushes still in
+	// flight from the previous session can't take it back. A group bound
+	// without IssuedAt moves to any other identity.
+	IssuedAt time.Time
+}
+
+// IdentityFunc returns the identity of the user making a request, usually
+// derived from info.Auth. Client groups are bound to the identity that
+// created them.
+type IdentityFunc func(ctx context.Context, info ClientInfo) (Identity, error)
+
+// IdentityChangePolicy decides what happens when a client group is used by a
+// different identity than the one it is bound to, typically because another
+// user logged in on the same browser profile.
+type IdentityChangePolicy int
+
+const (
+	// IdentityChangeReject refuses the request.
+	IdentityChangeReject IdentityChangePolicy = iota
+
+	// IdentityChangeRebind binds the client group to the new identity and
+	// logs the change.
+	IdentityChangeRebind
+
+	// IdentityChangeReset deletes the client group's server state and
+	// answers ClientStateNotFound, so the client starts a new group.
+	IdentityChangeReset
+)
+
+// identify calls the identity function as a transaction hook.
+func (rep *Replicache) identify(ctx context.Context, info ClientInfo) (identity Identity, err error) {
+	err = rep.runTxHook(ctx, hookIdentity, info.ClientGroupID, func(ctx context.Context) error {
+		identity, err = rep.identity(ctx, info)
+		return err
+	})
+	return identity, err
+}
+
+// identityOf returns the identity of the request and its session's issue
+// time, or nils when identities aren't tracked, for storing in
+// replicache_version.
+func (rep *Replicache) identityOf(ctx context.Context, info ClientInfo) (owner, issuedAt any, err error) {
+	if rep.identity == nil {
+		return nil, nil, nil
+	}
+	identity, err := rep.identify(ctx, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return identity.ID, nullTime(identity.IssuedAt), nil
+}
+
+// checkIdentity applies the identity change policy to an existing client
+// group within the request transaction tx. It reports reset when the policy
+// deleted the group's state, in which case the caller must commit tx with
+// commitIdentityReset instead of serving the request. Groups created before
+// identities were tracked are bound to the first identity that uses them.
+func (rep *Replicache) checkIdentity(ctx context.Context, tx *sql.Tx, info ClientInfo) (reset bool, err error) {
+	if rep.identity == nil {
+		return false, nil
+	}
+	identity, err := rep.identify(ctx, info)
+	if err != nil {
+		return false, err
+	}
+
+	var owner sql.NullString
+	var issuedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`SELECT owner, owner_issued_at FROM replicache_version WHERE client_group_id = $1 FOR UPDATE`,
+		info.ClientGroupID,
+	).Scan(&owner, &issuedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// bound when the request creates it
+		return false, nil
+	case err != nil:
+		return false, err
+	case owner.Valid && owner.String == identity.ID:
+		return false, nil
+	case !owner.Valid:
+		return false, bindIdentity(ctx, tx, info.ClientGroupID, identity)
+	}
+
+	switch rep.identityPolicy {
+	case IdentityChangeRebind:
+		if issuedAt.Valid && !identity.IssuedAt.After(issuedAt.Time) {
+			// a request of an earlier session, still in flight after
+			// the group moved to a newer one
+			return false, fmt.Errorf("%w: %s is bound to a newer session", ErrIdentityChanged, info.ClientGroupID)
+		}
+		rep.logger.InfoContext(ctx, "replicache client group rebound to new identity",
+			slog.String("client_group_id", info.ClientGroupID),
+			slog.String("profile_id", info.ProfileID),
+		)
+		return false, bindIdentity(ctx, tx, info.ClientGroupID, identity)
+	case IdentityChangeReset:
+		return true, deleteClientGroup(ctx, tx, info.ClientGroupID)
+	default:
+		return false, fmt.Errorf("%w: %s", ErrIdentityChanged, info.ClientGroupID)
+	}
+}
+
+// commitIdentityReset commits tx after checkIdentity reset its client group
+// and returns the error that answers the request with ClientStateNotFound,
+// so the client starts a new group.
+func (rep *Replicache) commitIdentityReset(ctx context.Context, tx *sql.Tx, info ClientInfo) error {
+	if err := rep.commit(tx); err != nil {
+		return err
+	}
+	rep.clientCache.invalidate(info.ClientGroupID)
+	rep.logger.InfoContext(ctx, "replicache client group reset after identity change",
+		slog.String("client_group_id", info.ClientGroupID),
+		slog.String("profile_id", info.ProfileID),
+	)
+	return fmt.Errorf("%w: client group %s reset after identity change", ErrClientNotFound, info.ClientGroupID)
+}
+
+func bindIdentity(ctx context.Context, tx *sql.Tx, clientGroupID string, identity Identity) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE replicache_version SET owner = $1, owner_issued_at = $2 WHERE client_group_id = $3`,
+		identity.ID, nullTime(identity.IssuedAt), clientGroupID,
+	)
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,195 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionIdentity reads identities from Authorization headers of the form
+// "user@issuedAt", with issuedAt in Unix seconds.
+func sessionIdentity(_ context.Context, info ClientInfo) (Identity, error) {
+	id, iat, _ := strings.Cut(info.Auth, "@")
+	sec, err := strconv.ParseInt(iat, 10, 64)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{ID: id, IssuedAt: time.Unix(sec, 0)}, nil
+}
+
+// postAs posts body to h with auth as Authorization header.
+func postAs(t testing.TB, h http.Handler, auth string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+	r.Header.Set("Authorization", auth)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdentityRejectAfterLogin(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithIdentityChangePolicy(sessionIdentity, IdentityChangeReject),
+	)
+
+	if w := postAs(t, rep.PushHandler(), "alice@1", pushRequest("group", mutation("client", 1, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("alice's push status = %d: %s", w.Code, w.Body)
+	}
+	// alice logs out and bob logs in on the same browser profile
+	begins, _, _ := f.txCounts()
+	if w := postAs(t, rep.PushHandler(), "bob@2", pushRequest("group", mutation("client", 2, "m"))); w.Code != http.StatusForbidden {
+		t.Errorf("bob's push status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if n, _, _ := f.txCounts(); n-begins != 1 {
+		t.Errorf("rejected push began %d transactions, want 1", n-begins)
+	}
+	if c, _ := f.client("group", "client"); c.lastMutationID != 1 {
+		t.Errorf("last mutation ID = %d after a rejected push, want 1", c.lastMutationID)
+	}
+	// and alice logs in again
+	if w := postAs(t, rep.PushHandler(), "alice@3", pushRequest("group", mutation("client", 2, "m"))); w.Code != http.StatusOK {
+		t.Errorf("alice's push after logging in again status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestIdentityRebindOnlyForward(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithIdentityChangePolicy(sessionIdentity, IdentityChangeRebind),
+	)
+	push := func(auth string, id int) int {
+		t.Helper()
+		return postAs(t, rep.PushHandler(), auth, pushRequest("group", mutation("client", id, "m"))).Code
+	}
+	owner := func() any {
+		g, _ := f.group("group")
+		return g.owner
+	}
+
+	// alice, bob and alice again log in on the same browser profile
+	for i, auth := range []string{"alice@1", "bob@2", "alice@3"} {
+		begins, _, _ := f.txCounts()
+		if code := push(auth, i+1); code != http.StatusOK {
+			t.Fatalf("push as %s status = %d", auth, code)
+		}
+		if n, _, _ := f.txCounts(); n-begins != 1 {
+			t.Errorf("push as %s began %d transactions, want 1", auth, n-begins)
+		}
+		if id, _, _ := strings.Cut(auth, "@"); owner() != id {
+			t.Errorf("owner after push as %s = %v", auth, owner())
+		}
+	}
+
+	// a push of bob's earlier session still in flight doesn't take the
+	// group back
+	if code := push("bob@2", 4); code != http.StatusForbidden {
+		t.Errorf("push of bob's earlier session status = %d, want %d", code, http.StatusForbidden)
+	}
+	if owner() != "alice" {
+		t.Errorf("owner = %v after a push of an earlier session, want alice", owner())
+	}
+	if c, _ := f.client("group", "client"); c.lastMutationID != 3 {
+		t.Errorf("last mutation ID = %d, want 3", c.lastMutationID)
+	}
+}
+
+func TestIdentityRebindConcurrentSessions(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithIdentityChangePolicy(sessionIdentity, IdentityChangeRebind),
+	)
+	if w := postAs(t, rep.PushHandler(), "alice@1", pushRequest("group", mutation("client", 1, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("first push status = %d: %s", w.Code, w.Body)
+	}
+
+	// pushes of alternating sessions race; the group only moves forward
+	var mu sync.Mutex
+	var rejected int64
+	var wg sync.WaitGroup
+	for i := 2; i <= 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := "alice"
+			if i%2 == 0 {
+				user = "bob"
+			}
+			w := postAs(t, rep.PushHandler(), fmt.Sprintf("%s@%d", user, i), pushRequest("group"))
+			switch w.Code {
+			case http.StatusOK:
+			case http.StatusForbidden:
+				mu.Lock()
+				rejected = max(rejected, int64(i))
+				mu.Unlock()
+			default:
+				t.Errorf("push status = %d: %s", w.Code, w.Body)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// a push is only rejected by a newer session, and the group stays
+	// bound to at least that session
+	g, _ := f.group("group")
+	iat, ok := g.ownerIssuedAt.(time.Time)
+	if !ok || iat.Unix() < rejected {
+		t.Errorf("owner issued at %v, want at least the session that rejected %d", g.ownerIssuedAt, rejected)
+	}
+	want := "alice"
+	if iat.Unix()%2 == 0 {
+		want = "bob"
+	}
+	if g.owner != want {
+		t.Errorf("owner = %v issued at %v, want %s", g.owner, iat, want)
+	}
+}
+
+func TestIdentityResetAfterLogin(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithIdentityChangePolicy(sessionIdentity, IdentityChangeReset),
+	)
+
+	if w := postAs(t, rep.PushHandler(), "alice@1", pushRequest("group", mutation("client", 1, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("alice's push status = %d: %s", w.Code, w.Body)
+	}
+	begins, commits, _ := f.txCounts()
+	w := postAs(t, rep.PushHandler(), "bob@2", pushRequest("group", mutation("client", 2, "m")))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ClientStateNotFound") {
+		t.Errorf("bob's push got %d %s, want ClientStateNotFound", w.Code, w.Body)
+	}
+	if n, c, _ := f.txCounts(); n-begins != 1 || c-commits != 1 {
+		t.Errorf("reset began %d and committed %d transactions, want 1 each", n-begins, c-commits)
+	}
+	if _, ok := f.group("group"); ok {
+		t.Error("group still exists after reset")
+	}
+	if _, ok := f.client("group", "client"); ok {
+		t.Error("client still exists after reset")
+	}
+
+	// bob's client starts a new group
+	if w := postAs(t, rep.PushHandler(), "bob@2", pushRequest("group2", mutation("client2", 1, "m"))); w.Code != http.StatusOK {
+		t.Errorf("bob's push to a new group status = %d: %s", w.Code, w.Body)
+	}
+	if g, _ := f.group("group2"); g.owner != "bob" {
+		t.Errorf("new group owner = %v, want bob", g.owner)
+	}
+}
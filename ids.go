@@ -0,0 +1,168 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// NormalizeUUID lowercases id if it is a UUID in canonical 8-4-4-4-12 form,
+// and returns any other id unchanged. Use it with WithIDNormalizer when
+// client builds disagree on the case of generated UUIDs.
+func NormalizeUUID(id string) string {
+	if len(id) != 36 {
+		return id
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return id
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return id
+			}
+		}
+	}
+	return strings.ToLower(id)
+}
+
+func (rep *Replicache) normalizeID(id string) string {
+	if rep.idNormalizer == nil {
+		return id
+	}
+	return rep.idNormalizer(id)
+}
+
+// normalizeIDs applies the ID normalizer to the client group, profile and
+// client IDs of a decoded request, in place.
+func (rep *Replicache) normalizeIDs(info *ClientInfo, mutations []Mutation) {
+	info.ClientGroupID = rep.normalizeID(info.ClientGroupID)
+	info.ProfileID = rep.normalizeID(info.ProfileID)
+	for i := range mutations {
+		mutations[i].ClientID = rep.normalizeID(mutations[i].ClientID)
+	}
+}
+
+// normalizeClientIDKeys applies the ID normalizer to the keys of a map keyed
+// by client ID.
+func (rep *Replicache) normalizeClientIDKeys(m map[string]int64) map[string]int64 {
+	if rep.idNormalizer == nil || m == nil {
+		return m
+	}
+	normalized := make(map[string]int64, len(m))
+	for clientID, v := range m {
+		clientID = rep.idNormalizer(clientID)
+		normalized[clientID] = max(normalized[clientID], v)
+	}
+	return normalized
+}
+
+// NormalizeStoredIDs rewrites stored client group and client IDs through
+// normalize, so WithIDNormalizer can be turned on against data written
+// without it. Client groups whose IDs normalize to the same value are merged
+// with MigrateClients, and clients whose IDs then collide within a group are
+// merged keeping the higher last mutation ID. Stored profile IDs are not
+// rewritten. Run it once, with the same function as WithIDNormalizer, right
+// after deploying the normalizer; it is safe to run again.
+func (rep *Replicache) NormalizeStoredIDs(ctx context.Context, normalize func(string) string) error {
+	groups, err := rep.storedClientGroupIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, groupID := range groups {
+		if normalized := normalize(groupID); normalized != groupID {
+			if err := rep.MigrateClients(ctx, groupID, normalized); err != nil {
+				return err
+			}
+		}
+	}
+
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT client_group_id, client_id, last_mutation_id FROM replicache_clients ORDER BY client_group_id, client_id FOR UPDATE`,
+	)
+	if err != nil {
+		return err
+	}
+	type clientKey struct{ group, client string }
+	stored := make(map[clientKey]int64)
+	var renames []clientKey
+	for rows.Next() {
+		var k clientKey
+		var lmid int64
+		if err := rows.Scan(&k.group, &k.client, &lmid); err != nil {
+			rows.Close()
+			return err
+		}
+		stored[k] = lmid
+		if normalize(k.client) != k.client {
+			renames = append(renames, k)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := rep.clock.Now()
+	for _, from := range renames {
+		to := clientKey{from.group, normalize(from.client)}
+		if lmid, ok := stored[to]; ok {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE replicache_clients SET last_mutation_id = $1, updated_at = $2 WHERE client_group_id = $3 AND client_id = $4`,
+				max(lmid, stored[from]), now, to.group, to.client,
+			); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx,
+				`DELETE FROM replicache_clients WHERE client_group_id = $1 AND client_id = $2`,
+				from.group, from.client,
+			); err != nil {
+				return err
+			}
+			stored[to] = max(lmid, stored[from])
+		} else {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE replicache_clients SET client_id = $1, updated_at = $2 WHERE client_group_id = $3 AND client_id = $4`,
+				to.client, now, from.group, from.client,
+			); err != nil {
+				return err
+			}
+			stored[to] = stored[from]
+		}
+		delete(stored, from)
+	}
+	if err := rep.commit(tx); err != nil {
+		return err
+	}
+	for _, k := range renames {
+		rep.clientCache.invalidate(k.group)
+	}
+	return nil
+}
+
+func (rep *Replicache) storedClientGroupIDs(ctx context.Context) ([]string, error) {
+	rows, err := rep.db.QueryContext(ctx, `SELECT DISTINCT client_group_id FROM replicache_clients ORDER BY client_group_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var groupID string
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, err
+		}
+		groups = append(groups, groupID)
+	}
+	return groups, rows.Err()
+}
@@ -0,0 +1,59 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// LazyTx begins its transaction the first time it is used, so pull handlers
+// that answer from a cache never open one. Use Tx for methods of *sql.Tx
+// that LazyTx doesn't wrap, such as QueryRowContext.
+type LazyTx struct {
+	begin func(ctx context.Context) (*sql.Tx, error)
+
+	mu  sync.Mutex
+	tx  *sql.Tx
+	err error
+}
+
+// Tx returns the transaction, beginning it if needed.
+func (lt *LazyTx) Tx(ctx context.Context) (*sql.Tx, error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.tx == nil && lt.err == nil {
+		lt.tx, lt.err = lt.begin(ctx)
+	}
+	return lt.tx, lt.err
+}
+
+func (lt *LazyTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	tx, err := lt.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tx.ExecContext(ctx, query, args...)
+}
+
+func (lt *LazyTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	tx, err := lt.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tx.QueryContext(ctx, query, args...)
+}
+
+func (lt *LazyTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	tx, err := lt.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tx.PrepareContext(ctx, query)
+}
+
+// started returns the transaction if it has been begun.
+func (lt *LazyTx) started() *sql.Tx {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.tx
+}
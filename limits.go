@@ -0,0 +1,51 @@
+package replicache
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrIDTooLong is returned, with a 400 response, when a clientGroupID,
+// profileID or clientID exceeds its configured maximum length.
+var ErrIDTooLong = errors.New("replicache: identifier too long")
+
+// defaultMaxIDLength matches the VARCHAR width of the identifier columns
+// created by CreateSchema.
+const defaultMaxIDLength = 256
+
+// checkMaxIDLength validates a configured maximum length. Longer limits than
+// the column width would let IDs through that the insert then rejects.
+func checkMaxIDLength(field string, n int) error {
+	if n < 1 || n > defaultMaxIDLength {
+		return fmt.Errorf("replicache: max %s length must be between 1 and %d", field, defaultMaxIDLength)
+	}
+	return nil
+}
+
+func checkIDLength(field, id string, max int) error {
+	if max > 0 && utf8.RuneCountInString(id) > max {
+		return fmt.Errorf("%w: %s is longer than %d characters", ErrIDTooLong, field, max)
+	}
+	return nil
+}
+
+// checkClientInfoLengths validates the client group and profile IDs of a
+// request against the configured limits.
+func (rep *Replicache) checkClientInfoLengths(info ClientInfo) error {
+	if err := checkIDLength("clientGroupID", info.ClientGroupID, rep.maxClientGroupIDLength); err != nil {
+		return err
+	}
+	return checkIDLength("profileID", info.ProfileID, rep.maxProfileIDLength)
+}
+
+// checkClientIDLengths validates the client IDs of mutations against the
+// configured limit.
+func (rep *Replicache) checkClientIDLengths(mutations []Mutation) error {
+	for _, m := range mutations {
+		if err := checkIDLength("clientID", m.ClientID, rep.maxClientIDLength); err != nil {
+			return err
+		}
+	}
+	return nil
+}
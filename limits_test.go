@@ -0,0 +1,43 @@
+package replicache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaxIDLengthOptions(t *testing.T) {
+	_, db := newFakeDB(t)
+	for _, opt := range []func(int) Option{WithMaxClientGroupIDLength, WithMaxProfileIDLength, WithMaxClientIDLength} {
+		for _, n := range []int{-1, 0, 257} {
+			if _, err := NewReplicache(db, testHandler{}, opt(n)); err == nil {
+				t.Errorf("limit %d was accepted beyond the column width", n)
+			}
+		}
+		if _, err := NewReplicache(db, testHandler{}, opt(256)); err != nil {
+			t.Errorf("limit 256: %v", err)
+		}
+	}
+}
+
+func TestMaxIDLength(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{}, WithClientOnPush(true), WithMaxClientGroupIDLength(8))
+
+	for _, tc := range []struct {
+		name string
+		req  testPush
+		want int
+	}{
+		{"ok", pushRequest("group", mutation("client", 1, "m")), http.StatusOK},
+		{"long group", pushRequest("long-group", mutation("client", 1, "m")), http.StatusBadRequest},
+		{"long client", pushRequest("group", mutation(strings.Repeat("c", 257), 1, "m")), http.StatusBadRequest},
+	} {
+		if w := post(t, rep.PushHandler(), tc.req); w.Code != tc.want {
+			t.Errorf("%s: push status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+	if w := post(t, rep.PullHandler(), pullRequest("long-group", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("pull with a long group status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
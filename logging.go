@@ -0,0 +1,165 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// ErrorClass groups request errors by how noteworthy they are to operators.
+type ErrorClass int
+
+const (
+	// ErrorClassUnexpected is any failure that isn't classified otherwise,
+	// such as commit errors and handler errors.
+	ErrorClassUnexpected ErrorClass = iota
+
+	// ErrorClassProtocol is routine protocol churn that clients recover from
+	// on their own: ClientStateNotFound after a purge, unsupported versions,
+	// rejected anonymous or expired credentials, exceeded quotas, oversized
+	// identifiers, pushes during maintenance and canceled requests.
+	ErrorClassProtocol
+
+	// ErrorClassUnavailable is a database outage, including requests refused
+	// while the circuit breaker is open, a push that kept conflicting past
+	// its retry budget, or a database schema that is missing or stale.
+	ErrorClassUnavailable
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassProtocol:
+		return "protocol"
+	case ErrorClassUnavailable:
+		return "unavailable"
+	default:
+		return "unexpected"
+	}
+}
+
+// LogEvent identifies a log line written while handling pushes and pulls so
+// its level can be changed with WithLogLevel.
+type LogEvent int
+
+const (
+	// LogEventPushStart is logged at debug when a push starts.
+	LogEventPushStart LogEvent = iota
+
+	// LogEventPushSuccess is logged at debug when a push succeeds.
+	LogEventPushSuccess
+
+	// LogEventPushError is logged when a push fails, at the level chosen by
+	// the LogLevelMapper.
+	LogEventPushError
+
+	// LogEventMutationSkipped is logged at warn for each mutation skipped
+	// because it had already been applied.
+	LogEventMutationSkipped
+
+	// LogEventMutationApplied is logged at debug for each mutation once the
+	// push that applied it commits.
+	LogEventMutationApplied
+
+	// LogEventPullStart is logged at debug when a pull starts.
+	LogEventPullStart
+
+	// LogEventPullSuccess is logged at debug when a pull succeeds.
+	LogEventPullSuccess
+
+	// LogEventPullError is logged when a pull fails, at the level chosen by
+	// the LogLevelMapper.
+	LogEventPullError
+)
+
+// errorEventKey is the context key holding the LogEvent used to log a
+// failed request.
+type errorEventKey struct{}
+
+// withErrorEvent returns r with the event its failure is logged as.
+func withErrorEvent(r *http.Request, event LogEvent) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), errorEventKey{}, event))
+}
+
+// logLevel returns the level set for event by WithLogLevel, or def.
+func (rep *Replicache) logLevel(event LogEvent, def slog.Level) slog.Level {
+	if level, ok := rep.logLevels[event]; ok {
+		return level
+	}
+	return def
+}
+
+// LogLevelMapper chooses the level at which a failed request is logged.
+type LogLevelMapper func(err error, class ErrorClass) slog.Level
+
+func classifyError(err error) ErrorClass {
+	switch {
+	case errors.Is(err, ErrClientNotFound),
+		errors.Is(err, ErrVersionNotSupported),
+		errors.Is(err, ErrAnonymousProfile),
+		errors.Is(err, ErrQuotaExceeded),
+		errors.Is(err, ErrIDTooLong),
+		errors.Is(err, ErrSparsePullNotSupported),
+		errors.Is(err, ErrMaintenance),
+		errors.Is(err, ErrTooManyClientGroups),
+		errors.Is(err, ErrUnsupportedEncoding),
+		errors.Is(err, ErrBodyTooLarge),
+		errors.Is(err, context.Canceled):
+		return ErrorClassProtocol
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrRetryBudgetExceeded), isInfrastructureError(err),
+		errors.Is(err, ErrSchemaMissing), errors.Is(err, ErrSchemaStale):
+		return ErrorClassUnavailable
+	default:
+		return ErrorClassUnexpected
+	}
+}
+
+// defaultLogLevel logs protocol churn at info, outages at warn and
+// everything else at error.
+func defaultLogLevel(err error, class ErrorClass) slog.Level {
+	switch class {
+	case ErrorClassProtocol:
+		return slog.LevelInfo
+	case ErrorClassUnavailable:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+func (rep *Replicache) logRequestError(ctx context.Context, err error) {
+	if (errors.Is(err, ErrSchemaMissing) || errors.Is(err, ErrSchemaStale)) && !rep.logSchemaError() {
+		return
+	}
+	class := classifyError(err)
+	level := rep.logLevelMapper(err, class)
+	if event, ok := ctx.Value(errorEventKey{}).(LogEvent); ok {
+		level = rep.logLevel(event, level)
+	}
+	rep.logger.Log(ctx, level, "replicache request failed",
+		slog.String("class", class.String()),
+		slog.Any("err", err),
+	)
+}
+
+func (rep *Replicache) logPushSuccess(ctx context.Context, result PushResult) {
+	rep.logger.Log(ctx, rep.logLevel(LogEventPushSuccess, slog.LevelDebug), "replicache push succeeded",
+		slog.String("client_group_id", result.ClientGroupID),
+		slog.Int("applied", result.Applied),
+		slog.Int("skipped", result.Skipped),
+	)
+}
+
+// logApplied logs each mutation of a committed push.
+func (rep *Replicache) logApplied(ctx context.Context, clientGroupID string, mutations []Mutation) {
+	level := rep.logLevel(LogEventMutationApplied, slog.LevelDebug)
+	if !rep.logger.Enabled(ctx, level) {
+		return
+	}
+	for _, m := range mutations {
+		rep.logger.Log(ctx, level, "replicache applied mutation",
+			slog.String("client_group_id", clientGroupID),
+			slog.Any("mutation", m),
+		)
+	}
+}
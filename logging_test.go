@@ -0,0 +1,87 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// captureHandler is a slog.Handler that records the level of every message
+// at or above its own level.
+type captureHandler struct {
+	level slog.Level
+
+	mu     sync.Mutex
+	levels map[string]slog.Level
+}
+
+func newCaptureHandler(level slog.Level) *captureHandler {
+	return &captureHandler{level: level, levels: make(map[string]slog.Level)}
+}
+
+func (h *captureHandler) Enabled(_ context.Context, level slog.Level) bool { return level >= h.level }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.levels[r.Message] = r.Level
+	return nil
+}
+
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler      { return h }
+
+// logged returns the level msg was logged at and whether it was.
+func (h *captureHandler) logged(msg string) (slog.Level, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	level, ok := h.levels[msg]
+	return level, ok
+}
+
+func TestWithLogLevel(t *testing.T) {
+	_, db := newFakeDB(t)
+	logs := newCaptureHandler(slog.LevelInfo)
+	rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+		if pr.Mutations[0].Name == "fail" {
+			return errors.New("boom")
+		}
+		return nil
+	}},
+		WithClientOnPush(true),
+		WithLogger(slog.New(logs)),
+		// raised from debug
+		WithLogLevel(LogEventPushStart, slog.LevelInfo),
+		WithLogLevel(LogEventPushSuccess, slog.LevelWarn),
+		// lowered from warn and error
+		WithLogLevel(LogEventMutationSkipped, slog.LevelDebug),
+		WithLogLevel(LogEventPushError, slog.LevelDebug),
+	)
+	for _, req := range []testPush{
+		pushRequest("group", mutation("client", 1, "m")),
+		pushRequest("group", mutation("client", 1, "m"), mutation("client", 2, "fail")),
+	} {
+		if w := post(t, rep.PushHandler(), req); w.Code != http.StatusOK && w.Code != http.StatusInternalServerError {
+			t.Fatalf("push status = %d: %s", w.Code, w.Body)
+		}
+	}
+
+	for msg, want := range map[string]slog.Level{
+		"replicache push started":   slog.LevelInfo,
+		"replicache push succeeded": slog.LevelWarn,
+	} {
+		if level, ok := logs.logged(msg); !ok {
+			t.Errorf("%q wasn't logged, want it at %v", msg, want)
+		} else if level != want {
+			t.Errorf("%q logged at %v, want %v", msg, level, want)
+		}
+	}
+	for _, msg := range []string{"replicache skipped already applied mutation", "replicache request failed"} {
+		if level, ok := logs.logged(msg); ok {
+			t.Errorf("%q logged at %v, want it lowered below info", msg, level)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package replicache
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrMaintenance is returned, with a 503 response, for pushes received while
+// maintenance mode is on.
+var ErrMaintenance = errors.New("replicache: down for maintenance")
+
+// Maintenance describes a maintenance window during which pushes are
+// refused and pulls keep working.
+type Maintenance struct {
+	Until   time.Time
+	Message string
+}
+
+// MaintenanceError carries the maintenance window that refused a push.
+type MaintenanceError struct {
+	Maintenance
+}
+
+func (e *MaintenanceError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrMaintenance, e.Until.Format(time.RFC3339))
+}
+
+func (e *MaintenanceError) Unwrap() error { return ErrMaintenance }
+
+func (e *MaintenanceError) response() map[string]string {
+	return map[string]string{"error": "maintenance", "message": e.Message}
+}
+
+// maintenanceState is shared by every copy of a Replicache returned by
+// WithContext.
+type maintenanceState struct {
+	mu         sync.Mutex
+	current    Maintenance
+	refreshed  time.Time
+	refreshing bool
+}
+
+// SetMaintenance makes pushes respond 503 with a Retry-After header until
+// the given time, while pulls keep working. With WithSharedMaintenance the
+// window is stored in the database so every instance applies it.
+func (rep *Replicache) SetMaintenance(until time.Time, message string) error {
+	m := Maintenance{Until: until, Message: message}
+	if rep.maintenanceRefresh > 0 {
+		if _, err := rep.db.ExecContext(rep.ctx,
+			`INSERT INTO replicache_maintenance (id, until, message) VALUES (true, $1, $2)
+			ON CONFLICT (id) DO UPDATE SET until = EXCLUDED.until, message = EXCLUDED.message`,
+			until, message,
+		); err != nil {
+			return err
+		}
+	}
+	rep.maintenance.mu.Lock()
+	rep.maintenance.current = m
+	rep.maintenance.refreshed = rep.clock.Now()
+	rep.maintenance.mu.Unlock()
+	return nil
+}
+
+// ClearMaintenance ends maintenance mode early.
+func (rep *Replicache) ClearMaintenance() error {
+	return rep.SetMaintenance(time.Time{}, "")
+}
+
+// Maintenance returns the current maintenance window and whether it is
+// active. With WithSharedMaintenance the window is reread from the database
+// once per refresh interval, by one caller at a time, while other callers
+// keep using the last known window.
+func (rep *Replicache) Maintenance() (Maintenance, bool) {
+	now := rep.clock.Now()
+	if rep.maintenanceRefresh > 0 && rep.maintenance.startRefresh(now, rep.maintenanceRefresh) {
+		rep.refreshMaintenance(now)
+	}
+	rep.maintenance.mu.Lock()
+	m := rep.maintenance.current
+	rep.maintenance.mu.Unlock()
+	return m, m.Until.After(now)
+}
+
+// startRefresh reports whether the caller should reread the window, marking
+// a refresh as in progress if so.
+func (s *maintenanceState) startRefresh(now time.Time, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refreshing || now.Sub(s.refreshed) < interval {
+		return false
+	}
+	s.refreshing = true
+	return true
+}
+
+// refreshMaintenance rereads the shared window without holding the lock, so
+// a slow database doesn't stall every request checking maintenance mode.
+func (rep *Replicache) refreshMaintenance(start time.Time) {
+	var m Maintenance
+	err := rep.db.QueryRowContext(rep.ctx,
+		`SELECT until, message FROM replicache_maintenance WHERE id`,
+	).Scan(&m.Until, &m.Message)
+
+	rep.maintenance.mu.Lock()
+	defer rep.maintenance.mu.Unlock()
+	rep.maintenance.refreshing = false
+	if rep.maintenance.refreshed.After(start) {
+		// SetMaintenance ran meanwhile and is newer than what was read
+		return
+	}
+	switch {
+	case err == nil, errors.Is(err, sql.ErrNoRows):
+		rep.maintenance.current = m
+	default:
+		// keep the last known state rather than failing requests
+		rep.logger.Warn("replicache maintenance state refresh failed", slog.Any("err", err))
+	}
+	rep.maintenance.refreshed = start
+}
+
+func (rep *Replicache) checkMaintenance() error {
+	if m, ok := rep.Maintenance(); ok {
+		return &MaintenanceError{Maintenance: m}
+	}
+	return nil
+}
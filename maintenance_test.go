@@ -0,0 +1,74 @@
+package replicache
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{}, WithClientOnPush(true))
+
+	if err := rep.SetMaintenance(time.Now().Add(time.Minute), "upgrading"); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := rep.Maintenance(); !ok || m.Message != "upgrading" {
+		t.Errorf("Maintenance() = %v, %v, want the window", m, ok)
+	}
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("push during maintenance got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := post(t, rep.PullHandler(), pullRequest("group", nil)); w.Code != http.StatusOK {
+		t.Errorf("pull during maintenance got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if err := rep.ClearMaintenance(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rep.Maintenance(); ok {
+		t.Error("maintenance still active after ClearMaintenance")
+	}
+}
+
+func TestSharedMaintenanceRefreshDoesNotBlock(t *testing.T) {
+	f, db := newFakeDB(t)
+	until := time.Now().Add(time.Hour)
+	queried := make(chan struct{})
+	release := make(chan struct{})
+	f.on(`SELECT until, message FROM replicache_maintenance`, func(*fakeState, []driver.Value) (fakeResult, error) {
+		close(queried)
+		<-release
+		return row(until, "migrating"), nil
+	})
+	rep := newTestReplicache(t, db, testHandler{}, WithSharedMaintenance(time.Millisecond))
+
+	done := make(chan Maintenance)
+	go func() {
+		m, _ := rep.Maintenance()
+		done <- m
+	}()
+	<-queried
+
+	// other callers use the last known state while the refresh is slow
+	checked := make(chan bool)
+	go func() {
+		_, ok := rep.Maintenance()
+		checked <- ok
+	}()
+	select {
+	case ok := <-checked:
+		if ok {
+			t.Error("maintenance active before the refresh finished")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Maintenance blocked behind a refresh")
+	}
+
+	close(release)
+	if m := <-done; m.Message != "migrating" || !m.Until.Equal(until) {
+		t.Errorf("refreshed window = %v, want the stored one", m)
+	}
+}
@@ -0,0 +1,38 @@
+package replicache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MutationError records which mutation caused an error. Use errors.As to get
+// the mutation context from an error returned by a handler.
+type MutationError struct {
+	Mutation      Mutation
+	ClientGroupID string
+	Cause         error
+}
+
+func (e *MutationError) Error() string {
+	return fmt.Sprintf("replicache: mutation %s %d from client %s in group %s: %v",
+		e.Mutation.Name, e.Mutation.ID, e.Mutation.ClientID, e.ClientGroupID, e.Cause)
+}
+
+func (e *MutationError) Unwrap() error { return e.Cause }
+
+func (e *MutationError) MutationName() string { return e.Mutation.Name }
+
+func (e *MutationError) MutationID() int { return e.Mutation.ID }
+
+// wrapMutationError wraps err in a MutationError unless it already carries
+// mutation context.
+func wrapMutationError(err error, m Mutation, clientGroupID string) error {
+	if err == nil {
+		return nil
+	}
+	var me *MutationError
+	if errors.As(err, &me) {
+		return err
+	}
+	return &MutationError{Mutation: m, ClientGroupID: clientGroupID, Cause: err}
+}
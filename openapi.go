@@ -0,0 +1,181 @@
+package replicache
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	DefaultPushPath = "/replicache/push"
+	DefaultPullPath = "/replicache/pull"
+)
+
+type object = map[string]any
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+// OpenAPISchema returns an OpenAPI 3.0 document describing the push and pull
+// endpoints at the paths set by WithEndpointPaths.
+func (rep *Replicache) OpenAPISchema() ([]byte, error) {
+	return json.Marshal(rep.openAPIDocument())
+}
+
+func (rep *Replicache) openAPIDocument() object {
+	errorResponses := object{
+		"401": object{"description": "The request has no profile and anonymous requests are rejected."},
+		"500": object{"description": "The request could not be decoded or processing failed."},
+		"503": object{
+			"description": "The database is unavailable. Retry after the delay in the Retry-After header.",
+			"headers": object{
+				"Retry-After": object{"schema": object{"type": "integer"}, "description": "Seconds to wait before retrying."},
+			},
+		},
+	}
+	withErrors := func(ok object) object {
+		responses := object{"200": ok}
+		for code, resp := range errorResponses {
+			responses[code] = resp
+		}
+		return responses
+	}
+
+	doc := object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "Replicache",
+			"version": "1",
+		},
+		"security": []object{{"bearerAuth": []string{}}},
+		"paths": object{
+			rep.pushPath: object{
+				"post": object{
+					"operationId": "push",
+					"summary":     "Push mutations from a client group.",
+					"requestBody": object{"required": true, "content": jsonContent(ref("PushRequest"))},
+					"responses": withErrors(object{
+						"description": "The mutations were processed. The body is empty unless the push failed at the protocol level.",
+						"content": jsonContent(object{"oneOf": []object{
+							ref("ClientStateNotFoundResponse"),
+							ref("VersionNotSupportedResponse"),
+							ref("QuotaExceededResponse"),
+						}}),
+					}),
+				},
+			},
+			rep.pullPath: object{
+				"post": object{
+					"operationId": "pull",
+					"summary":     "Pull changes to the client view.",
+					"requestBody": object{"required": true, "content": jsonContent(ref("PullRequest"))},
+					"responses": withErrors(object{
+						"description": "The changes since the request cookie.",
+						"content": jsonContent(object{"oneOf": []object{
+							ref("PullResponse"),
+							ref("ClientStateNotFoundResponse"),
+							ref("VersionNotSupportedResponse"),
+						}}),
+					}),
+				},
+			},
+		},
+		"components": object{
+			"securitySchemes": object{
+				"bearerAuth": object{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": object{
+				"Mutation": object{
+					"type":     "object",
+					"required": []string{"clientID", "id", "name", "args"},
+					"properties": object{
+						"clientID":  object{"type": "string"},
+						"id":        object{"type": "integer"},
+						"name":      object{"type": "string"},
+						"args":      object{"description": "Arbitrary JSON arguments of the mutator."},
+						"timestamp": object{"type": "number"},
+					},
+				},
+				"PushRequest": object{
+					"type":     "object",
+					"required": []string{"pushVersion", "clientGroupID", "mutations", "profileID", "schemaVersion"},
+					"properties": object{
+						"pushVersion":   object{"type": "integer", "enum": rep.pushVersions},
+						"clientGroupID": object{"type": "string"},
+						"mutations":     object{"type": "array", "items": ref("Mutation")},
+						"profileID":     object{"type": "string"},
+						"schemaVersion": object{"type": "string"},
+					},
+				},
+				"PullRequest": object{
+					"type":     "object",
+					"required": []string{"pullVersion", "clientGroupID", "cookie", "profileID", "schemaVersion"},
+					"properties": object{
+						"pullVersion":   object{"type": "integer", "enum": rep.pullVersions},
+						"clientGroupID": object{"type": "string"},
+						"cookie":        object{"type": "integer", "nullable": true},
+						"profileID":     object{"type": "string"},
+						"schemaVersion": object{"type": "string"},
+					},
+				},
+				"PatchOperation": object{
+					"type":     "object",
+					"required": []string{"op"},
+					"properties": object{
+						"op":    object{"type": "string", "enum": []string{"put", "del", "clear"}},
+						"key":   object{"type": "string"},
+						"value": object{"description": "The JSON value for put operations."},
+					},
+				},
+				"PullResponse": object{
+					"type":     "object",
+					"required": []string{"cookie", "lastMutationIDChanges", "patch"},
+					"properties": object{
+						"cookie":                object{"type": "integer", "nullable": true},
+						"lastMutationIDChanges": object{"type": "object", "additionalProperties": object{"type": "integer"}},
+						"patch":                 object{"type": "array", "items": ref("PatchOperation")},
+					},
+				},
+				"ClientStateNotFoundResponse": object{
+					"type":       "object",
+					"required":   []string{"error"},
+					"properties": object{"error": object{"type": "string", "enum": []string{"ClientStateNotFound"}}},
+				},
+				"VersionNotSupportedResponse": object{
+					"type":     "object",
+					"required": []string{"error"},
+					"properties": object{
+						"error":       object{"type": "string", "enum": []string{"VersionNotSupported"}},
+						"versionType": object{"type": "string", "enum": []string{"push", "pull", "schema"}},
+					},
+				},
+				"QuotaExceededResponse": object{
+					"type":     "object",
+					"required": []string{"error", "mutations"},
+					"properties": object{
+						"error": object{"type": "string", "enum": []string{"quota_exceeded"}},
+						"mutations": object{"type": "array", "items": object{
+							"type": "object",
+							"properties": object{
+								"clientID": object{"type": "string"},
+								"id":       object{"type": "integer"},
+							},
+						}},
+					},
+				},
+			},
+		},
+	}
+	return doc
+}
+
+// OpenAPIHandler serves the document returned by OpenAPISchema.
+func (rep *Replicache) OpenAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep.writeJSON(w, http.StatusOK, rep.openAPIDocument())
+	})
+}
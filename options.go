@@ -0,0 +1,824 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Replicache) error {
+		if logger == nil {
+			return errors.New("replicache: logger must not be nil")
+		}
+		r.logger = logger
+		return nil
+	}
+}
+
+// WithClientOnPush creates clients referenced by a push that have not been
+// seen before instead of responding with ClientStateNotFound.
+func WithClientOnPush(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.clientOnPush = enabled
+		return nil
+	}
+}
+
+// WithClientFactory customizes the record inserted for clients created on
+// push. The client and client group IDs of the returned record are always
+// set by the package.
+func WithClientFactory(fn ClientFactory) Option {
+	return func(r *Replicache) error {
+		if fn == nil {
+			return errors.New("replicache: client factory must not be nil")
+		}
+		r.clientFactory = fn
+		return nil
+	}
+}
+
+// WithCircuitBreaker stops opening transactions after threshold consecutive
+// database infrastructure failures within window. While open, push and pull
+// respond 503 with a Retry-After header until cooldown has elapsed, after
+// which a single probe request is let through to test the database. Each
+// database routed by a DBRouter has its own breaker.
+func WithCircuitBreaker(threshold int, window, cooldown time.Duration) Option {
+	return func(r *Replicache) error {
+		if threshold < 1 || window <= 0 || cooldown <= 0 {
+			return errors.New("replicache: circuit breaker threshold, window and cooldown must be positive")
+		}
+		r.breakers = &breakerSet{
+			threshold: threshold,
+			window:    window,
+			cooldown:  cooldown,
+			byDB:      make(map[*sql.DB]*circuitBreaker),
+		}
+		return nil
+	}
+}
+
+// WithHTTP2ServerPush pushes paths to HTTP/2 clients after a pull whose
+// PullResponse.SchemaVersion differs from the schema version of the request.
+func WithHTTP2ServerPush(paths []string) Option {
+	return func(r *Replicache) error {
+		r.serverPushPaths = append([]string(nil), paths...)
+		return nil
+	}
+}
+
+// WithStreamingPush decodes push requests incrementally and calls HandlePush
+// once for each mutation as it is read from the body instead of buffering
+// the whole mutation array. Mutations must already be ordered per client and
+// pushVersion and clientGroupID must appear before mutations in the request
+// body.
+func WithStreamingPush() Option {
+	return func(r *Replicache) error {
+		r.streamingPush = true
+		return nil
+	}
+}
+
+// WithDeduplicateByArgs skips mutations whose name and args are identical to
+// a mutation already applied for the same client of the same client group
+// within the TTL set by WithDeduplicateByArgsTTL, even if the mutation ID is
+// new. Only enable this when re-applying an identical mutation is never
+// intended.
+func WithDeduplicateByArgs(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.dedupeByArgs = enabled
+		return nil
+	}
+}
+
+// WithDeduplicateByArgsTTL sets how long applied args are remembered by
+// WithDeduplicateByArgs. The default is 5 minutes.
+func WithDeduplicateByArgsTTL(ttl time.Duration) Option {
+	return func(r *Replicache) error {
+		if ttl <= 0 {
+			return errors.New("replicache: deduplication TTL must be positive")
+		}
+		r.dedupeByArgsTTL = ttl
+		return nil
+	}
+}
+
+// WithAnonymousPolicy sets how requests with an empty profileID are handled.
+// See AnonymousPolicy for the security implications of each policy.
+func WithAnonymousPolicy(policy AnonymousPolicy) Option {
+	return func(r *Replicache) error {
+		r.anonymousPolicy = policy
+		return nil
+	}
+}
+
+// WithQuotaChecker checks the size of pushed args against q before the
+// handler runs. Mutations rejected by the checker are recorded as applied so
+// clients stop retrying them, and the push responds with a quota_exceeded
+// error listing the rejected mutations.
+func WithQuotaChecker(q QuotaChecker, scope QuotaScope) Option {
+	return func(r *Replicache) error {
+		if q == nil {
+			return errors.New("replicache: quota checker must not be nil")
+		}
+		r.quota = q
+		r.quotaScope = scope
+		return nil
+	}
+}
+
+// WithPushSizeAccounting calls fn with the size of every decoded push
+// request body. The size is the request's Content-Length or, when that is
+// unknown, the number of bytes read; either way compressed bodies count
+// their compressed size. fn runs synchronously on the request
+// path and must be fast.
+func WithPushSizeAccounting(fn func(ctx context.Context, clientGroupID string, bytes int64)) Option {
+	return func(r *Replicache) error {
+		r.pushSizeAccounting = fn
+		return nil
+	}
+}
+
+// WithTransactionScope sets whether a push is applied in one transaction or
+// in one transaction per mutation. See TransactionPerMutation before using
+// anything other than the default TransactionPerPush.
+func WithTransactionScope(scope TransactionScope) Option {
+	return func(r *Replicache) error {
+		r.txScope = scope
+		return nil
+	}
+}
+
+// WithCVRStore loads the client view record before each pull and saves the
+// CVR set on the returned PullResponse after the handler runs.
+func WithCVRStore(s CVRStore) Option {
+	return func(r *Replicache) error {
+		r.cvrStore = s
+		return nil
+	}
+}
+
+// WithPullScopes decodes the optional scopes field of the pull body, a list
+// of key prefixes the client wants, into PullRequest.Scopes. With a CVRStore
+// configured, the CVR passed to the handler only covers the requested
+// scopes, and the CVR returned by the handler only replaces entries within
+// them, so pulls with different scopes each diff against their own
+// baseline.
+func WithPullScopes() Option {
+	return func(r *Replicache) error {
+		r.pullScopes = true
+		return nil
+	}
+}
+
+// WithProtocolVersions sets the push and pull protocol versions that are
+// accepted. Requests for any other version get a VersionNotSupported
+// response. Only version 1 of each is enabled by default.
+func WithProtocolVersions(push []int, pull []int) Option {
+	return func(r *Replicache) error {
+		if len(push) == 0 || len(pull) == 0 {
+			return errors.New("replicache: at least one push and pull version must be enabled")
+		}
+		r.pushVersions = append([]int(nil), push...)
+		r.pullVersions = append([]int(nil), pull...)
+		return nil
+	}
+}
+
+// WithEndpointPaths sets the paths the push and pull handlers are mounted at,
+// as reported by OpenAPISchema. It does not route requests.
+func WithEndpointPaths(push, pull string) Option {
+	return func(r *Replicache) error {
+		if push == "" || pull == "" {
+			return errors.New("replicache: endpoint paths must not be empty")
+		}
+		r.pushPath = push
+		r.pullPath = pull
+		return nil
+	}
+}
+
+// WithPullDistributedLock locks the client group's row in replicache_version
+// at the start of every pull, so concurrent pulls for the same client group
+// are serialized by the database across all replicas.
+func WithPullDistributedLock(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.pullLock = enabled
+		return nil
+	}
+}
+
+// WithPreRollback calls fn when a push fails, before its transaction is
+// rolled back, with the error that caused it. Use it to capture diagnostic
+// state; fn can't affect the rollback.
+func WithPreRollback(fn func(ctx context.Context, info ClientInfo, err error)) Option {
+	return func(r *Replicache) error {
+		r.preRollback = fn
+		return nil
+	}
+}
+
+// WithSelfTestMutator sets the name of the mutation pushed by SelfTest. The
+// handler must apply it as a no-op. The default is "replicacheSelfTest".
+func WithSelfTestMutator(name string) Option {
+	return func(r *Replicache) error {
+		if name == "" {
+			return errors.New("replicache: self test mutator name must not be empty")
+		}
+		r.selfTestMutator = name
+		return nil
+	}
+}
+
+// WithPushSuccessBody writes the value returned by fn as the JSON body of
+// successful push responses instead of an empty 200.
+func WithPushSuccessBody(fn func(result PushResult) any) Option {
+	return func(r *Replicache) error {
+		r.pushSuccessBody = fn
+		return nil
+	}
+}
+
+// WithNewClientHook calls fn after the push that created a client commits.
+// It runs exactly once per client.
+func WithNewClientHook(fn func(ctx context.Context, info ClientInfo, clientID string)) Option {
+	return func(r *Replicache) error {
+		r.newClientHook = fn
+		return nil
+	}
+}
+
+// WithNewGroupHook calls fn after the request that created a client group
+// commits. It runs exactly once per client group.
+func WithNewGroupHook(fn func(ctx context.Context, info ClientInfo)) Option {
+	return func(r *Replicache) error {
+		r.newGroupHook = fn
+		return nil
+	}
+}
+
+// WithSupportedSchemaVersions only accepts requests whose schemaVersion is in
+// versions; others get a VersionNotSupported response. The accepted schema
+// version is recorded on the client group, and a change is reported to the
+// handler in PreviousSchemaVersion. Without this option every schema version
+// is accepted and nothing is recorded.
+func WithSupportedSchemaVersions(versions ...string) Option {
+	return func(r *Replicache) error {
+		r.schemaVersions = append([]string(nil), versions...)
+		return nil
+	}
+}
+
+// WithMaxClientGroupIDLength rejects requests whose clientGroupID is longer
+// than n characters with 400 Bad Request. n must be between 1 and 256, the
+// width of the columns created by CreateSchema, which is the default.
+func WithMaxClientGroupIDLength(n int) Option {
+	return func(r *Replicache) error {
+		if err := checkMaxIDLength("client group ID", n); err != nil {
+			return err
+		}
+		r.maxClientGroupIDLength = n
+		return nil
+	}
+}
+
+// WithMaxProfileIDLength rejects requests whose profileID is longer than n
+// characters with 400 Bad Request. n must be between 1 and 256, the width of
+// the columns created by CreateSchema, which is the default.
+func WithMaxProfileIDLength(n int) Option {
+	return func(r *Replicache) error {
+		if err := checkMaxIDLength("profile ID", n); err != nil {
+			return err
+		}
+		r.maxProfileIDLength = n
+		return nil
+	}
+}
+
+// WithMaxClientIDLength rejects pushes containing a mutation whose clientID
+// is longer than n characters with 400 Bad Request. n must be between 1 and
+// 256, the width of the columns created by CreateSchema, which is the
+// default.
+func WithMaxClientIDLength(n int) Option {
+	return func(r *Replicache) error {
+		if err := checkMaxIDLength("client ID", n); err != nil {
+			return err
+		}
+		r.maxClientIDLength = n
+		return nil
+	}
+}
+
+// WithLogLevelMapper overrides the level at which failed requests are logged.
+// By default ErrorClassProtocol logs at info, ErrorClassUnavailable at warn
+// and ErrorClassUnexpected at error.
+func WithLogLevelMapper(mapper LogLevelMapper) Option {
+	return func(r *Replicache) error {
+		if mapper == nil {
+			return errors.New("replicache: log level mapper must not be nil")
+		}
+		r.logLevelMapper = mapper
+		return nil
+	}
+}
+
+// WithAutoMigrate runs CreateSchema from NewReplicache so the tables exist
+// before the first request. The databases of a DBRouter set by WithDBRouter
+// are migrated too, so register them before calling NewReplicache. Each
+// database is migrated at most once per process; later instances using it
+// log a warning and skip it. A failed migration is retried by the next
+// instance.
+func WithAutoMigrate(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.autoMigrateEnabled = enabled
+		return nil
+	}
+}
+
+// WithStatusHook calls hook with the status of every push and pull response,
+// including successes, before it is written.
+func WithStatusHook(hook StatusHook) Option {
+	return func(r *Replicache) error {
+		r.statusHook = hook
+		return nil
+	}
+}
+
+// WithResponseHeaders controls whether successful push and pull responses
+// carry X-Replicache-ClientGroupID and X-Replicache-ProfileID headers with
+// the resolved client info. It is enabled by default.
+func WithResponseHeaders(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.responseHeaders = enabled
+		return nil
+	}
+}
+
+// WithPushRetry retries push transactions that fail with a serialization
+// failure or deadlock, up to maxAttempts attempts in total, waiting backoff
+// between attempts. FullJitterBackoff is a good default. If the next wait
+// would take the push past maxElapsed, it gives up early; zero means no time
+// limit. Either way a push that runs out of retries is answered with a 503
+// and a Retry-After header of the wait it would have taken next. Streaming pushes are never
+// retried since their body has already been consumed.
+func WithPushRetry(maxAttempts int, backoff Backoff, maxElapsed time.Duration) Option {
+	return func(r *Replicache) error {
+		if maxAttempts < 1 || backoff == nil || maxElapsed < 0 {
+			return errors.New("replicache: push retry needs at least one attempt and a backoff")
+		}
+		r.pushRetry = &pushRetry{maxAttempts: maxAttempts, backoff: backoff, maxElapsed: maxElapsed}
+		return nil
+	}
+}
+
+// WithFailedRequestCapture hands the body of every failed push, up to
+// maxBytes, to store so it can be kept for reproducing the failure. The
+// body is only buffered when this option is set, and the Authorization
+// header is never passed to store.
+func WithFailedRequestCapture(maxBytes int, store FailedRequestStore) Option {
+	return func(r *Replicache) error {
+		if maxBytes <= 0 || store == nil {
+			return errors.New("replicache: failed request capture needs a positive size cap and a store")
+		}
+		r.failedRequestStore = store
+		r.failedRequestMaxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithMutationNameNormalizer sets the name normalizer of routers created by
+// Handle and MustHandle. See MutationRouter.NormalizeNames.
+func WithMutationNameNormalizer(fn func(string) string) Option {
+	return func(r *Replicache) error {
+		r.nameNormalizer = fn
+		return nil
+	}
+}
+
+// WithDuplicateWindowSize skips mutations whose ID is among the last n
+// applied IDs of their client, remembered in memory by a DuplicateDetector,
+// before touching the database. Clients idle for the client purge duration,
+// or a day if none is set, are forgotten.
+func WithDuplicateWindowSize(n int) Option {
+	return func(r *Replicache) error {
+		if n < 0 {
+			return errors.New("replicache: duplicate window size must not be negative")
+		}
+		r.duplicateWindow = n
+		return nil
+	}
+}
+
+// WithSparsePullSupported declares that the pull handler honors
+// PullRequest.RequestedKeys. Without it, pulls with requestedKeys are
+// rejected with 400 Bad Request rather than silently answered in full.
+func WithSparsePullSupported(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.sparsePull = enabled
+		return nil
+	}
+}
+
+// WithSparseFullSupported is an alias of WithSparsePullSupported, kept under
+// the name the option was first proposed with.
+func WithSparseFullSupported(enabled bool) Option {
+	return WithSparsePullSupported(enabled)
+}
+
+// WithSharedMaintenance stores maintenance windows set with SetMaintenance in
+// the database so every instance behind a load balancer agrees on them. Each
+// instance rereads the window at most once per refresh.
+func WithSharedMaintenance(refresh time.Duration) Option {
+	return func(r *Replicache) error {
+		if refresh <= 0 {
+			return errors.New("replicache: maintenance refresh interval must be positive")
+		}
+		r.maintenanceRefresh = refresh
+		return nil
+	}
+}
+
+// WithLazyTransaction hands pull handlers a LazyTx that only begins the pull
+// transaction when the handler first uses it, and leaves PullRequest.Tx nil.
+// The transaction is still begun up front when WithPullDistributedLock,
+// WithSupportedSchemaVersions, WithIdentityChangePolicy or a CVRStore need
+// it. Pushes always begin
+// their transaction immediately to load client state.
+func WithLazyTransaction(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.lazyTx = enabled
+		return nil
+	}
+}
+
+// WithLastMutationIDGap reports a last mutation ID regression, instead of an
+// out of order mutation, when a push's first mutation for a client is more
+// than gap IDs ahead of the stored last mutation ID. Regressions are logged
+// at LevelCritical and answered with a LastMutationIDRegression error code.
+// Zero, the default, disables the check.
+func WithLastMutationIDGap(gap int64) Option {
+	return func(r *Replicache) error {
+		if gap < 0 {
+			return errors.New("replicache: last mutation ID gap must not be negative")
+		}
+		r.lastMutationIDGap = gap
+		return nil
+	}
+}
+
+// WithPushHandler sets the push handler, overriding the Handler passed to
+// NewReplicache. Use it with a nil Handler to serve only the push endpoint;
+// the pull endpoint then responds 405 Method Not Allowed.
+func WithPushHandler(h PushHandler) Option {
+	return func(r *Replicache) error {
+		if h == nil {
+			return errors.New("replicache: push handler must not be nil")
+		}
+		r.pushHandler = h
+		return nil
+	}
+}
+
+// WithPullHandler sets the pull handler, overriding the Handler passed to
+// NewReplicache. Use it with a nil Handler to serve only the pull endpoint;
+// the push endpoint then responds 405 Method Not Allowed.
+func WithPullHandler(h PullHandler) Option {
+	return func(r *Replicache) error {
+		if h == nil {
+			return errors.New("replicache: pull handler must not be nil")
+		}
+		r.pullHandler = h
+		return nil
+	}
+}
+
+// WithJSONEncoder sets the encoder used for pull responses, error bodies and
+// the OpenAPI endpoint. The default is encoding/json with HTML escaping
+// turned off.
+func WithJSONEncoder(newEncoder func(w io.Writer) Encoder) Option {
+	return func(r *Replicache) error {
+		if newEncoder == nil {
+			return errors.New("replicache: JSON encoder must not be nil")
+		}
+		r.newEncoder = newEncoder
+		return nil
+	}
+}
+
+// WithDBRouter runs pushes and pulls against the database the router picks
+// for the request's profile ID instead of the database passed to
+// NewReplicache. Requests from profiles without a database get
+// ClientStateNotFound.
+func WithDBRouter(router *DBRouter) Option {
+	return func(r *Replicache) error {
+		if router == nil {
+			return errors.New("replicache: DB router must not be nil")
+		}
+		r.dbRouter = router
+		return nil
+	}
+}
+
+// WithSyncLagTracking records when each client group last pushed and pulled
+// so SyncLag can report it. It costs one extra write per request.
+func WithSyncLagTracking() Option {
+	return func(r *Replicache) error {
+		r.syncLagTracking = true
+		return nil
+	}
+}
+
+// WithMaxClientGroupsPerProfile limits how many client groups a profile can
+// create, so an authenticated client can't flood the database with random
+// client group IDs. When a new group would exceed n, policy either rejects
+// the request with 403 Forbidden or evicts the profile's longest idle group.
+// Groups without a profile ID are not limited.
+func WithMaxClientGroupsPerProfile(n int, policy GroupLimitPolicy) Option {
+	return func(r *Replicache) error {
+		if n <= 0 {
+			return errors.New("replicache: max client groups per profile must be positive")
+		}
+		r.maxGroupsPerProfile = n
+		r.groupLimitPolicy = policy
+		return nil
+	}
+}
+
+// WithArgRedactor sets how mutation args are rewritten before they reach a
+// log record, an error message or the failed request store. The default
+// replaces args with their length and SHA-256 hash. Use an identity function
+// to keep args intact where that is safe.
+func WithArgRedactor(redactor ArgRedactor) Option {
+	return func(r *Replicache) error {
+		if redactor == nil {
+			return errors.New("replicache: arg redactor must not be nil")
+		}
+		r.argRedactor = redactor
+		return nil
+	}
+}
+
+// WithClientStateCache keeps the last mutation IDs of up to maxGroups
+// recently pushed client groups in memory, so pushes that only re-send
+// applied mutations are answered without touching the database. Other pushes
+// still read client state inside their transaction. The cache is only
+// invalidated by this instance, so don't combine it with restoring or
+// resetting client state through another instance or by hand.
+func WithClientStateCache(maxGroups int) Option {
+	return func(r *Replicache) error {
+		if maxGroups <= 0 {
+			return errors.New("replicache: client state cache size must be positive")
+		}
+		r.clientCache = newClientStateCache(maxGroups)
+		return nil
+	}
+}
+
+// WithSlowQueryThreshold logs the package's own queries that take longer
+// than d at warn level, by name rather than SQL, and counts them in
+// Stats().SlowQueryCount. Queries run by handlers are not timed.
+func WithSlowQueryThreshold(d time.Duration) Option {
+	return func(r *Replicache) error {
+		if d <= 0 {
+			return errors.New("replicache: slow query threshold must be positive")
+		}
+		r.slowQueryThreshold = d
+		return nil
+	}
+}
+
+// WithHookTimeout gives user hooks a context that expires after d, and logs
+// hooks that take longer. The new client, new client group and pre-rollback
+// hooks can't fail the request by overrunning. The identity function, quota
+// checker and schema upgrade handler run inside the request transaction, so
+// one that overruns aborts the request with ErrHookTimeout rather than
+// holding the transaction open. The default is no timeout.
+func WithHookTimeout(d time.Duration) Option {
+	return func(r *Replicache) error {
+		if d < 0 {
+			return errors.New("replicache: hook timeout must not be negative")
+		}
+		r.hookTimeout = d
+		return nil
+	}
+}
+
+// WithShadowHandler also runs a sampleRate fraction of pushes and pulls
+// through h in the background, to validate a new handler against real
+// traffic. It requires WithShadowDB. h gets its own transaction on the
+// shadow database and the same mutations or pull request as the primary
+// handler, once per push however many transactions or retries the primary
+// needed. A shadow push that fails where the primary succeeded, or the
+// reverse, and a shadow pull with a different patch are logged and counted
+// in Stats. Shadows start in the background after the primary transaction
+// committed or rolled back, so the primary response never waits for them;
+// when too many shadow requests are in flight new ones are dropped.
+func WithShadowHandler(h Handler, sampleRate float64) Option {
+	return func(r *Replicache) error {
+		if h == nil {
+			return errors.New("replicache: shadow handler must not be nil")
+		}
+		if sampleRate <= 0 || sampleRate > 1 {
+			return errors.New("replicache: shadow sample rate must be in (0, 1]")
+		}
+		r.shadowConfig().handler = h
+		r.shadow.sampleRate = sampleRate
+		return nil
+	}
+}
+
+// WithShadowDB sets the database the shadow handler set by WithShadowHandler
+// runs against, committing its transactions. It must be a separate copy of
+// the primary database, never the primary itself.
+func WithShadowDB(db *sql.DB) Option {
+	return func(r *Replicache) error {
+		if db == nil {
+			return errors.New("replicache: shadow database must not be nil")
+		}
+		r.shadowConfig().db = db
+		return nil
+	}
+}
+
+// WithVersionCookies declares that the pull handler's cookie is the client
+// group version kept in replicache_version. Pulls with a cookie then read the
+// group's version and reset version, and a cookie issued before the last
+// ForceFullPull is handed to the handler as NilCookie. Like the
+// LastMutationIDChanges fallback, it assumes that cookie scheme, so don't
+// set it with another one.
+func WithVersionCookies() Option {
+	return func(r *Replicache) error {
+		r.versionCookies = true
+		return nil
+	}
+}
+
+// WithFutureCookieReset treats a pull cookie greater than the client group
+// version as NilCookie, forcing a full pull, which happens when clients sync
+// with a database restored from a backup. It implies WithVersionCookies and
+// shares its query, so don't set it with another cookie scheme either.
+func WithFutureCookieReset() Option {
+	return func(r *Replicache) error {
+		r.futureCookieCheck = true
+		r.versionCookies = true
+		return nil
+	}
+}
+
+// WithAllowedPushMethods sets the HTTP methods accepted by the push endpoint,
+// for proxies that forward pushes as PUT. The default is POST only. Pulls
+// always require POST.
+func WithAllowedPushMethods(methods ...string) Option {
+	return func(r *Replicache) error {
+		if len(methods) == 0 {
+			return errors.New("replicache: at least one push method must be allowed")
+		}
+		r.pushMethods = methods
+		return nil
+	}
+}
+
+// WithCanonicalizeArgs rewrites the args of every pushed mutation to their
+// CanonicalizeArgs form before the handler sees them. Args that aren't valid
+// JSON or have duplicate keys fail the push.
+func WithCanonicalizeArgs(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.canonicalizeArgs = enabled
+		return nil
+	}
+}
+
+// WithLogMalformedPushBody logs the first 512 bytes of push bodies that fail
+// to decode, at debug level, to help debug misbehaving clients. Mutation
+// args are redacted like for WithFailedRequestCapture, and a body that
+// can't be parsed is logged only by its length and SHA-256 hash.
+func WithLogMalformedPushBody(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.logMalformedBody = enabled
+		return nil
+	}
+}
+
+// WithIdentityChangePolicy binds every client group to the identity returned
+// by identity for the request that created it, and applies policy when a
+// request from another identity uses the group, such as after a different
+// user logs in on the same browser profile. The check runs in the request's
+// transaction. identity may be called more than once per request, so it
+// should be cheap.
+func WithIdentityChangePolicy(identity IdentityFunc, policy IdentityChangePolicy) Option {
+	return func(r *Replicache) error {
+		if identity == nil {
+			return errors.New("replicache: identity function must not be nil")
+		}
+		r.identity = identity
+		r.identityPolicy = policy
+		return nil
+	}
+}
+
+// WithPartialPushSuccess makes routers created by Handle run each mutation in
+// a savepoint, so a failing mutation is rolled back and skipped instead of
+// failing the whole push. See MutationRouter.PartialSuccess.
+func WithPartialPushSuccess(enabled bool) Option {
+	return func(r *Replicache) error {
+		r.partialPushSuccess = enabled
+		return nil
+	}
+}
+
+// WithMaxDecompressedBodyBytes caps the decompressed size of gzip encoded
+// push and pull bodies. Larger bodies get a 413. The default is 32 MiB.
+// gzip is the only supported Content-Encoding; zstd and others get a 415.
+func WithMaxDecompressedBodyBytes(n int64) Option {
+	return func(r *Replicache) error {
+		if n <= 0 {
+			return errors.New("replicache: max decompressed body size must be positive")
+		}
+		r.maxDecompressedBytes = n
+		return nil
+	}
+}
+
+// WithCookieHorizon checks every pull's cookie against the horizon returned
+// by fn, and applies policy to pulls with an older cookie instead of letting
+// the handler compute a patch from state it no longer has.
+func WithCookieHorizon(fn CookieHorizonFunc, policy StaleCookiePolicy) Option {
+	return func(r *Replicache) error {
+		if fn == nil {
+			return errors.New("replicache: cookie horizon function must not be nil")
+		}
+		r.cookieHorizon = fn
+		r.staleCookiePolicy = policy
+		return nil
+	}
+}
+
+// WithIDNormalizer maps client group, profile and client IDs through fn as
+// requests are decoded, before anything is looked up or stored, for example
+// NormalizeUUID when client builds disagree on UUID case. Existing data must
+// be normalized with NormalizeStoredIDs when the normalizer is introduced.
+func WithIDNormalizer(fn func(id string) string) Option {
+	return func(r *Replicache) error {
+		r.idNormalizer = fn
+		return nil
+	}
+}
+
+// WithServerID identifies this server instance in pull cookies, which are
+// then sent as CookieValue strings. A pull with a cookie issued under another
+// server ID gets a full pull, for example after moving clients to a server
+// with a separate database. Pulls with a bare version cookie are still
+// accepted.
+func WithServerID(id string) Option {
+	return func(r *Replicache) error {
+		if id == "" {
+			return errors.New("replicache: server ID must not be empty")
+		}
+		r.serverID = id
+		return nil
+	}
+}
+
+// WithPullGroupState reads the client group's stored state in the pull
+// transaction and hands it to the pull handler in PullRequest.Group, so the
+// handler sees the same snapshot as its own queries. It costs two extra
+// queries per pull and begins a lazy transaction up front.
+func WithPullGroupState() Option {
+	return func(r *Replicache) error {
+		r.pullGroupState = true
+		return nil
+	}
+}
+
+// WithSchemaVersionUpgradeHandler calls fn the first time a push arrives
+// with a schemaVersion not yet seen for its profile, within the push
+// transaction and before the push handler. oldVersion is the schema version
+// last upgraded to for the profile, or empty for its first push. The new
+// version is only recorded if fn and the push succeed, so a failed upgrade is
+// tried again on the next push.
+func WithSchemaVersionUpgradeHandler(fn SchemaUpgradeFunc) Option {
+	return func(r *Replicache) error {
+		r.schemaUpgrade = fn
+		return nil
+	}
+}
+
+// WithLogLevel logs event at level instead of its default, see LogEvent.
+// Setting a level for LogEventPushError or LogEventPullError overrides the
+// LogLevelMapper for failed pushes or pulls.
+func WithLogLevel(event LogEvent, level slog.Level) Option {
+	return func(r *Replicache) error {
+		if event < LogEventPushStart || event > LogEventPullError {
+			return fmt.Errorf("replicache: unknown log event %d", event)
+		}
+		if r.logLevels == nil {
+			r.logLevels = make(map[LogEvent]slog.Level)
+		}
+		r.logLevels[event] = level
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package replicache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// VersionType is the kind of version a VersionNotSupported response refers
+// to.
+type VersionType string
+
+const (
+	VersionTypePush   VersionType = "push"
+	VersionTypePull   VersionType = "pull"
+	VersionTypeSchema VersionType = "schema"
+)
+
+// ProtocolError is an error response defined by the Replicache protocol.
+// Clients expect it with a 200 status. It is implemented by
+// ClientStateNotFoundResponse and VersionNotSupportedResponse.
+type ProtocolError interface {
+	json.Marshaler
+	protocolError()
+}
+
+// ClientStateNotFoundResponse tells the client its client group or client is
+// unknown, which makes it reset its state.
+type ClientStateNotFoundResponse struct{}
+
+func (ClientStateNotFoundResponse) protocolError() {}
+
+func (ClientStateNotFoundResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(protocolErrorBody{Error: "ClientStateNotFound"})
+}
+
+// UnmarshalJSON fails unless b is a ClientStateNotFound response.
+func (r *ClientStateNotFoundResponse) UnmarshalJSON(b []byte) error {
+	_, err := decodeProtocolError(b, "ClientStateNotFound")
+	return err
+}
+
+// VersionNotSupportedResponse tells the client the server doesn't support
+// its push, pull or schema version.
+type VersionNotSupportedResponse struct {
+	// VersionType is omitted from the response when empty.
+	VersionType VersionType
+}
+
+func (VersionNotSupportedResponse) protocolError() {}
+
+func (r VersionNotSupportedResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(protocolErrorBody{Error: "VersionNotSupported", VersionType: r.VersionType})
+}
+
+// UnmarshalJSON fails unless b is a VersionNotSupported response.
+func (r *VersionNotSupportedResponse) UnmarshalJSON(b []byte) error {
+	body, err := decodeProtocolError(b, "VersionNotSupported")
+	if err != nil {
+		return err
+	}
+	r.VersionType = body.VersionType
+	return nil
+}
+
+type protocolErrorBody struct {
+	Error       string      `json:"error"`
+	VersionType VersionType `json:"versionType,omitempty"`
+}
+
+func decodeProtocolError(b []byte, want string) (protocolErrorBody, error) {
+	var body protocolErrorBody
+	if err := json.Unmarshal(b, &body); err != nil {
+		return body, err
+	}
+	if body.Error != want {
+		return body, fmt.Errorf("replicache: expected %s response, got error %q", want, body.Error)
+	}
+	return body, nil
+}
+
+// WriteProtocolError writes e with the 200 status the protocol requires, for
+// handlers built around the package rather than served by it.
+func WriteProtocolError(w http.ResponseWriter, e ProtocolError) {
+	b, err := e.MarshalJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
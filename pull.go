@@ -0,0 +1,543 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Cookie is the version of the client view returned with a pull and sent
+// back by the client on its next pull. A null cookie decodes as NilCookie.
+type Cookie int64
+
+const NilCookie Cookie = 0
+
+func (c Cookie) MarshalJSON() ([]byte, error) {
+	if c == NilCookie {
+		return []byte("null"), nil
+	}
+	return strconv.AppendInt(nil, int64(c), 10), nil
+}
+
+func (c *Cookie) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		*c = NilCookie
+		return nil
+	}
+	var v int64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = Cookie(v)
+	return nil
+}
+
+// PullResponse can be returned from HandlePull. Handlers may return any
+// value that encodes to a valid pull response, but returning a PullResponse
+// lets the package inspect it.
+type PullResponse struct {
+	Cookie                Cookie           `json:"cookie"`
+	LastMutationIDChanges map[string]int64 `json:"lastMutationIDChanges"`
+	Patch                 []PatchOperation `json:"patch"`
+
+	// SchemaVersion is the schema version of the data in the response. It is
+	// not sent to the client.
+	SchemaVersion string `json:"-"`
+
+	// CVR is the client view record after this pull. When a CVRStore is
+	// configured and CVR is not nil, it is saved in the pull transaction.
+	CVR map[string]string `json:"-"`
+
+	// Headers are added to the HTTP response, for example Cache-Control or
+	// CDN tags. Content-Type and ETag are managed by the package and can't
+	// be set here.
+	Headers http.Header `json:"-"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ErrSparsePullNotSupported is returned, with a 400 response, for pulls that
+// request specific keys unless WithSparsePullSupported is set.
+var ErrSparsePullNotSupported = errors.New("replicache: sparse pull not supported")
+
+func (rep *Replicache) PullHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withErrorEvent(r, LogEventPullError)
+		if rep.pullHandler == nil {
+			rep.respond(w, r, http.StatusMethodNotAllowed, nil, ErrNoHandler)
+			return
+		}
+		if !rep.allowMethod(w, r, []string{http.MethodPost}) {
+			return
+		}
+		req := struct {
+			PullVersion   int             `json:"pullVersion"`
+			ClientGroupID string          `json:"clientGroupID"`
+			Cookie        json.RawMessage `json:"cookie"`
+			ProfileID     string          `json:"profileID"`
+			SchemaVersion string          `json:"schemaVersion"`
+
+			Scopes        []string `json:"scopes"`
+			RequestedKeys []string `json:"requestedKeys"`
+
+			LastMutationIDs map[string]int64 `json:"lastMutationIDs"`
+		}{}
+		if err := rep.decodeRequestBody(r); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rep.writeDecodeError(w, r, err)
+			return
+		}
+		if err := rep.checkPullVersion(req.PullVersion); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		cookie, rawCookie, err := rep.decodeCookie(r.Context(), req.Cookie)
+		if err != nil {
+			rep.writeDecodeError(w, r, err)
+			return
+		}
+		if !rep.pullScopes {
+			req.Scopes = nil
+		}
+		if len(req.RequestedKeys) > 0 && !rep.sparsePull {
+			rep.writeError(w, r, ErrSparsePullNotSupported)
+			return
+		}
+		info := ClientInfo{
+			Auth:          r.Header.Get("Authorization"),
+			ClientGroupID: req.ClientGroupID,
+			ProfileID:     req.ProfileID,
+			SchemaVersion: req.SchemaVersion,
+		}
+		rep.normalizeIDs(&info, nil)
+		if err := rep.checkClientInfoLengths(info); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		pr := PullRequest{
+			ClientInfo:    info,
+			Cookie:        cookie,
+			RawCookie:     rawCookie,
+			Scopes:        req.Scopes,
+			RequestedKeys: req.RequestedKeys,
+
+			ClientLastMutationIDs: rep.normalizeClientIDKeys(req.LastMutationIDs),
+		}
+		resp, err := rep.handlePull(r.Context(), &pr)
+		if err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		rep.setClientHeaders(w, pr.ClientGroupID, pr.ProfileID)
+		setResponseHeaders(w, resp)
+		rep.respond(w, r, http.StatusOK, rep.encodeCookie(resp), nil)
+		rep.serverPush(w, req.SchemaVersion, resp)
+	})
+}
+
+// setResponseHeaders copies the Headers of a PullResponse to w, skipping the
+// headers the package manages.
+func setResponseHeaders(w http.ResponseWriter, resp any) {
+	var headers http.Header
+	switch v := resp.(type) {
+	case PullResponse:
+		headers = v.Headers
+	case *PullResponse:
+		if v != nil {
+			headers = v.Headers
+		}
+	}
+	for key, values := range headers {
+		key = http.CanonicalHeaderKey(key)
+		if key == "Content-Type" || key == "Etag" {
+			continue
+		}
+		w.Header().Del(key)
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+}
+
+// handlePull runs the pull handler for pr, which must have its client info,
+// cookie and scopes set. The anonymous policy is applied to pr's client info
+// in place.
+func (rep *Replicache) handlePull(ctx context.Context, pr *PullRequest) (any, error) {
+	if rep.pullHandler == nil {
+		return nil, ErrNoHandler
+	}
+	rep.Freeze()
+	rep.logger.Log(ctx, rep.logLevel(LogEventPullStart, slog.LevelDebug), "replicache pull started",
+		slog.String("client_group_id", pr.ClientGroupID),
+		slog.Int64("cookie", int64(pr.Cookie)),
+	)
+	if err := rep.applyAnonymousPolicy(&pr.ClientInfo); err != nil {
+		return nil, err
+	}
+	rep, err := rep.routeDB(pr.ClientInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err := rep.checkClientSchemaVersion(pr.SchemaVersion); err != nil {
+		return nil, err
+	}
+	info := pr.ClientInfo
+
+	lazy := &LazyTx{begin: func(ctx context.Context) (*sql.Tx, error) {
+		return rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	}}
+	var shadow func()
+	defer func() {
+		if tx := lazy.started(); tx != nil {
+			tx.Rollback()
+		}
+		// the shadow starts once the pull transaction is over, so it
+		// can't conflict with it
+		if shadow != nil {
+			shadow()
+		}
+	}()
+	if rep.lazyTx {
+		pr.LazyTx = lazy
+	}
+
+	// the package's own bookkeeping needs the transaction up front
+	var tx *sql.Tx
+	if !rep.lazyTx || rep.pullLock || len(rep.schemaVersions) > 0 || rep.cvrStore != nil || rep.identity != nil {
+		if tx, err = lazy.Tx(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if reset, err := rep.checkIdentity(ctx, tx, info); err != nil {
+		return nil, err
+	} else if reset {
+		return nil, rep.commitIdentityReset(ctx, tx, info)
+	}
+
+	if len(pr.ClientLastMutationIDs) > 0 {
+		divergenceTx, err := lazy.Tx(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := rep.checkClientDivergence(ctx, divergenceTx, info.ClientGroupID, pr.ClientLastMutationIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	if rep.versionCookies && pr.Cookie != NilCookie {
+		versionTx, err := lazy.Tx(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := rep.checkCookieVersion(ctx, versionTx, pr); err != nil {
+			return nil, err
+		}
+	}
+
+	if rep.cookieHorizon != nil && pr.Cookie != NilCookie {
+		if err := rep.checkCookieHorizon(ctx, pr); err != nil {
+			return nil, err
+		}
+	}
+
+	var events clientEvents
+	if rep.pullLock {
+		if events.groupCreated, err = rep.lockClientGroup(ctx, tx, info); err != nil {
+			return nil, err
+		}
+	}
+	if pr.PreviousSchemaVersion, err = rep.recordSchemaVersion(ctx, tx, info, &events); err != nil {
+		return nil, err
+	}
+
+	var stored map[string]string
+	if rep.cvrStore != nil {
+		if stored, err = rep.cvrStore.GetCVR(ctx, tx, info.ClientGroupID); err != nil {
+			return nil, err
+		}
+		pr.CVR = FilterCVR(stored, pr.Scopes)
+	}
+
+	if rep.pullGroupState {
+		groupTx, err := lazy.Tx(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if pr.Group, err = loadGroupState(ctx, groupTx, info.ClientGroupID); err != nil {
+			return nil, err
+		}
+	}
+
+	pr.Tx = tx
+	resp, err := rep.pullHandler.HandlePull(ctx, *pr)
+	shadowReq, shadowResp, shadowErr := *pr, resp, err
+	shadow = func() { rep.shadowPull(ctx, shadowReq, shadowResp, shadowErr) }
+	if errors.Is(err, ErrClientNotFound) {
+		resp, err = rep.recheckClientGroup(ctx, pr, err)
+	}
+	if resp, err = rep.pullResult(ctx, pr, resp, err); err != nil {
+		return nil, err
+	}
+
+	if resp, err = rep.fillLastMutationIDChanges(ctx, lazy, info.ClientGroupID, pr.Cookie, resp); err != nil {
+		return nil, err
+	}
+
+	if rep.cvrStore != nil {
+		if next, ok := responseCVR(resp); ok {
+			if err := rep.cvrStore.SetCVR(ctx, tx, info.ClientGroupID, mergeCVR(stored, next, pr.Scopes)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if tx := lazy.started(); tx != nil {
+		if err := rep.commit(tx); err != nil {
+			return nil, err
+		}
+	}
+	rep.recordSync(ctx, info.ClientGroupID, "last_pull_at")
+	rep.fireClientEvents(ctx, info, events)
+	rep.logger.Log(ctx, rep.logLevel(LogEventPullSuccess, slog.LevelDebug), "replicache pull succeeded",
+		slog.String("client_group_id", info.ClientGroupID),
+	)
+	return resp, nil
+}
+
+// fillLastMutationIDChanges sets LastMutationIDChanges of a PullResponse the
+// handler left it empty in, from the clients changed since the client group
+// version in cookie. This assumes the handler's cookie is the client group
+// version kept in replicache_version; handlers with another cookie scheme
+// must set LastMutationIDChanges themselves. The query joins the pull
+// transaction if one was started, so it reads the handler's snapshot, and
+// otherwise runs on a plain connection rather than opening a transaction
+// for it.
+func (rep *Replicache) fillLastMutationIDChanges(ctx context.Context, lazy *LazyTx, clientGroupID string, cookie Cookie, resp any) (any, error) {
+	var pr *PullResponse
+	switch v := resp.(type) {
+	case PullResponse:
+		pr = &v
+	case *PullResponse:
+		pr = v
+	}
+	if pr == nil || len(pr.LastMutationIDChanges) > 0 {
+		return resp, nil
+	}
+
+	var q DBTX = rep.db
+	if tx := lazy.started(); tx != nil {
+		q = tx
+	}
+	done := rep.observeQuery(ctx, queryLastMutationChanges, clientGroupID)
+	changes, err := getLastMutationIDChangesSince(ctx, q, clientGroupID, int64(cookie))
+	done()
+	if err != nil {
+		return nil, err
+	}
+	pr.LastMutationIDChanges = changes
+	if _, ok := resp.(PullResponse); ok {
+		return *pr, nil
+	}
+	return pr, nil
+}
+
+// PullWarning is a non-fatal error returned by a pull handler with its
+// response. Create one with Warning.
+type PullWarning struct {
+	Err error
+}
+
+// Warning marks err as non-fatal: a pull handler returning Warning(err) with
+// a response has the response sent and err logged and counted in Stats. Any
+// other error discards the response.
+func Warning(err error) error {
+	return &PullWarning{Err: err}
+}
+
+func (w *PullWarning) Error() string { return "replicache: pull warning: " + w.Err.Error() }
+
+func (w *PullWarning) Unwrap() error { return w.Err }
+
+// pullResult applies the contract for what a pull handler returns. A warning
+// is logged and dropped, and a nil response without error means nothing
+// changed, which is answered with the client's own cookie and an empty patch.
+func (rep *Replicache) pullResult(ctx context.Context, pr *PullRequest, resp any, err error) (any, error) {
+	var warning *PullWarning
+	if errors.As(err, &warning) {
+		rep.counters.pullWarnings.Add(1)
+		rep.logger.WarnContext(ctx, "replicache pull handler warning",
+			slog.String("client_group_id", pr.ClientGroupID),
+			slog.Any("err", warning.Err),
+		)
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := resp.(*PullResponse); resp == nil || ok && p == nil {
+		return PullResponse{
+			Cookie:                pr.Cookie,
+			LastMutationIDChanges: map[string]int64{},
+			Patch:                 []PatchOperation{},
+		}, nil
+	}
+	return resp, nil
+}
+
+// recheckClientGroup handles a pull handler reporting its client group as not
+// found. The pull reads a snapshot, so a group created by a push that
+// committed after the snapshot was taken is invisible to it, and answering
+// ClientStateNotFound would reset a client that just pushed. If the group
+// exists outside the snapshot the pull gets an empty response with the
+// client's own cookie, and the next pull returns the group's data.
+func (rep *Replicache) recheckClientGroup(ctx context.Context, pr *PullRequest, notFound error) (any, error) {
+	var exists bool
+	if err := rep.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM replicache_version WHERE client_group_id = $1)`,
+		pr.ClientGroupID,
+	).Scan(&exists); err != nil || !exists {
+		return nil, notFound
+	}
+	rep.logger.DebugContext(ctx, "replicache pull raced client group creation",
+		slog.String("client_group_id", pr.ClientGroupID),
+	)
+	return PullResponse{
+		Cookie:                pr.Cookie,
+		LastMutationIDChanges: map[string]int64{},
+		Patch:                 []PatchOperation{},
+	}, nil
+}
+
+// CookieHorizonFunc returns the oldest cookie the pull handler can still
+// answer with a correct incremental patch for a client group, for example
+// the version tombstones were last compacted at. NilCookie means every
+// cookie can be served.
+type CookieHorizonFunc func(ctx context.Context, clientGroupID string) (Cookie, error)
+
+// StaleCookiePolicy decides how a pull with a cookie older than the horizon
+// is answered.
+type StaleCookiePolicy int
+
+const (
+	// StaleCookieResync hands the pull to the handler with NilCookie, so it
+	// returns a clear op followed by the full client view.
+	StaleCookieResync StaleCookiePolicy = iota
+
+	// StaleCookieClientStateNotFound answers ClientStateNotFound, so the
+	// client starts over with a new client group.
+	StaleCookieClientStateNotFound
+)
+
+// checkCookieHorizon applies the stale cookie policy to a pull whose cookie
+// is older than the horizon.
+func (rep *Replicache) checkCookieHorizon(ctx context.Context, pr *PullRequest) error {
+	horizon, err := rep.cookieHorizon(ctx, pr.ClientGroupID)
+	if err != nil {
+		return err
+	}
+	if horizon == NilCookie || pr.Cookie >= horizon {
+		return nil
+	}
+	rep.counters.staleCookies.Add(1)
+	rep.logger.InfoContext(ctx, "replicache cookie older than horizon",
+		slog.String("client_group_id", pr.ClientGroupID),
+		slog.Int64("cookie", int64(pr.Cookie)),
+		slog.Int64("horizon", int64(horizon)),
+	)
+	if rep.staleCookiePolicy == StaleCookieClientStateNotFound {
+		return fmt.Errorf("%w: cookie %d is older than horizon %d", ErrClientNotFound, pr.Cookie, horizon)
+	}
+	pr.Cookie = NilCookie
+	return nil
+}
+
+// checkCookieVersion compares a cookie with the client group version and
+// reset version, read in one query. A cookie issued before the last
+// ForceFullPull, or with WithFutureCookieReset one ahead of the version,
+// is replaced with NilCookie.
+func (rep *Replicache) checkCookieVersion(ctx context.Context, tx *sql.Tx, pr *PullRequest) error {
+	var version, reset int64
+	err := tx.QueryRowContext(ctx,
+		`SELECT version, reset_version FROM replicache_version WHERE client_group_id = $1`,
+		pr.ClientGroupID,
+	).Scan(&version, &reset)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	switch {
+	case rep.futureCookieCheck && int64(pr.Cookie) > version:
+		rep.counters.futureCookies.Add(1)
+		rep.logger.WarnContext(ctx, "replicache cookie ahead of client group version, forcing full pull",
+			slog.String("client_group_id", pr.ClientGroupID),
+			slog.Int64("cookie", int64(pr.Cookie)),
+			slog.Int64("version", version),
+		)
+	case int64(pr.Cookie) < reset:
+		rep.logger.InfoContext(ctx, "replicache cookie older than forced full pull",
+			slog.String("client_group_id", pr.ClientGroupID),
+			slog.Int64("cookie", int64(pr.Cookie)),
+			slog.Int64("reset_version", reset),
+		)
+	default:
+		return nil
+	}
+	pr.Cookie = NilCookie
+	return nil
+}
+
+// serverPush pushes the configured resources over HTTP/2 when the pull
+// response carries a schema version different from the client's.
+func (rep *Replicache) serverPush(w http.ResponseWriter, schemaVersion string, resp any) {
+	if len(rep.serverPushPaths) == 0 {
+		return
+	}
+	pusher, ok := w.(http.Pusher)
+	if !ok {
+		return
+	}
+	var respSchemaVersion string
+	switch v := resp.(type) {
+	case PullResponse:
+		respSchemaVersion = v.SchemaVersion
+	case *PullResponse:
+		if v != nil {
+			respSchemaVersion = v.SchemaVersion
+		}
+	}
+	if respSchemaVersion == "" || respSchemaVersion == schemaVersion {
+		return
+	}
+	for _, path := range rep.serverPushPaths {
+		if err := pusher.Push(path, nil); err != nil {
+			rep.logger.Debug("replicache server push failed", slog.String("path", path), slog.Any("err", err))
+		}
+	}
+}
+
+// lockClientGroup locks the client group's version row for the rest of the
+// transaction, creating it if needed so there is always a row to lock. It
+// reports whether the row was created.
+func (rep *Replicache) lockClientGroup(ctx context.Context, tx *sql.Tx, info ClientInfo) (bool, error) {
+	created, err := rep.ensureClientGroup(ctx, tx, info)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`SELECT 1 FROM replicache_version WHERE client_group_id = $1 FOR UPDATE`,
+		info.ClientGroupID,
+	)
+	return created, err
+}
@@ -0,0 +1,233 @@
+package replicache
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSparsePull(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		want    int
+	}{
+		{"unsupported", nil, http.StatusBadRequest},
+		{"supported", []Option{WithSparsePullSupported(true)}, http.StatusOK},
+		{"alias", []Option{WithSparseFullSupported(true)}, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, db := newFakeDB(t)
+			var keys []string
+			rep := newTestReplicache(t, db, testHandler{pull: func(_ context.Context, pr PullRequest) (any, error) {
+				keys = pr.RequestedKeys
+				return nil, nil
+			}}, tc.options...)
+
+			w := post(t, rep.PullHandler(), map[string]any{
+				"pullVersion":   1,
+				"clientGroupID": "group",
+				"cookie":        nil,
+				"requestedKeys": []string{"todo/1", "todo/2"},
+			})
+			if w.Code != tc.want {
+				t.Fatalf("pull status = %d, want %d", w.Code, tc.want)
+			}
+			if tc.want == http.StatusOK && !slices.Equal(keys, []string{"todo/1", "todo/2"}) {
+				t.Errorf("handler saw requested keys %q", keys)
+			}
+		})
+	}
+}
+
+func TestFillLastMutationIDChangesWithoutTx(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.addClient("group", "client", 3)
+	rep := newTestReplicache(t, db, testHandler{pull: func(context.Context, PullRequest) (any, error) {
+		return &PullResponse{Cookie: 1}, nil
+	}}, WithLazyTransaction(true))
+
+	w := post(t, rep.PullHandler(), pullRequest("group", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("pull status = %d: %s", w.Code, w.Body)
+	}
+	if begins, _, _ := f.txCounts(); begins != 0 {
+		t.Errorf("filling lastMutationIDChanges began %d transactions for a handler that didn't use one", begins)
+	}
+	if n := f.count(`AND last_modified_version > $2`); n != 1 {
+		t.Errorf("changes were queried %d times, want once", n)
+	}
+}
+
+func TestPullRacesGroupCreation(t *testing.T) {
+	_, db := newFakeDB(t)
+	var rep *Replicache
+	h := testHandler{pull: func(ctx context.Context, pr PullRequest) (any, error) {
+		// the client's first push commits after the pull's snapshot was
+		// taken
+		if pr.ClientGroupID == "new" {
+			if w := post(t, rep.PushHandler(), pushRequest("new", mutation("client", 1, "m"))); w.Code != http.StatusOK {
+				t.Fatalf("push status = %d: %s", w.Code, w.Body)
+			}
+		}
+		var one int
+		if err := pr.Tx.QueryRowContext(ctx, `SELECT 1 FROM replicache_version WHERE client_group_id = $1`, pr.ClientGroupID).Scan(&one); err != nil {
+			return nil, ErrClientNotFound
+		}
+		return &PullResponse{Cookie: 1}, nil
+	}}
+	rep = newTestReplicache(t, db, h, WithClientOnPush(true))
+
+	w := post(t, rep.PullHandler(), pullRequest("new", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "ClientStateNotFound") {
+		t.Errorf("pull racing the group's creation got %d %s, want an empty response", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"patch":[]`) {
+		t.Errorf("pull racing the group's creation got %s, want an empty patch", w.Body)
+	}
+
+	// a group that really doesn't exist still resets the client
+	w = post(t, rep.PullHandler(), pullRequest("missing", nil))
+	if !strings.Contains(w.Body.String(), "ClientStateNotFound") {
+		t.Errorf("pull of a missing group got %d %s, want ClientStateNotFound", w.Code, w.Body)
+	}
+}
+
+func TestDecodeCookie(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.addClient("group", "client", 1)
+	f.mu.Lock()
+	f.state.groups["group"].version = 10
+	f.mu.Unlock()
+	var got PullRequest
+	rep := newTestReplicache(t, db, testHandler{pull: func(_ context.Context, pr PullRequest) (any, error) {
+		got = pr
+		return nil, nil
+	}}, WithServerID("a"))
+
+	ours := CookieValue{Version: 7, IssuedAt: time.UnixMilli(1000), ServerID: "a"}.String()
+	other := CookieValue{Version: 7, ServerID: "b"}.String()
+	for _, tc := range []struct {
+		name    string
+		cookie  any
+		want    Cookie
+		wantRaw string
+	}{
+		{"null", nil, NilCookie, ""},
+		{"version", 7, 7, ""},
+		{"ours", ours, 7, ""},
+		{"other server", other, NilCookie, ""},
+		// strings this package didn't issue are the handler's business
+		{"handler", "page-3", NilCookie, `"page-3"`},
+		{"base64 JSON", base64.RawURLEncoding.EncodeToString([]byte(`{"v":7,"x":1}`)), NilCookie, `"eyJ2Ijo3LCJ4IjoxfQ"`},
+	} {
+		got = PullRequest{}
+		if w := post(t, rep.PullHandler(), pullRequest("group", tc.cookie)); w.Code != http.StatusOK {
+			t.Errorf("%s: pull status = %d: %s", tc.name, w.Code, w.Body)
+			continue
+		}
+		if got.Cookie != tc.want || string(got.RawCookie) != tc.wantRaw {
+			t.Errorf("%s: handler saw cookie %d raw %s, want %d raw %s", tc.name, got.Cookie, got.RawCookie, tc.want, tc.wantRaw)
+		}
+	}
+}
+
+func TestForceFullPull(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.addClient("group", "client", 1)
+	f.mu.Lock()
+	f.state.groups["group"].version = 5
+	f.mu.Unlock()
+	var cookie Cookie
+	h := testHandler{pull: func(_ context.Context, pr PullRequest) (any, error) {
+		cookie = pr.Cookie
+		return nil, nil
+	}}
+	rep := newTestReplicache(t, db, h, WithVersionCookies())
+	pull := func(rep *Replicache, c Cookie) Cookie {
+		t.Helper()
+		if w := post(t, rep.PullHandler(), pullRequest("group", c)); w.Code != http.StatusOK {
+			t.Fatalf("pull status = %d: %s", w.Code, w.Body)
+		}
+		return cookie
+	}
+
+	if got := pull(rep, 5); got != 5 {
+		t.Errorf("handler saw cookie %d before forcing a full pull, want 5", got)
+	}
+	// without a CVRStore, only the reset version forces the full pull
+	if err := rep.ForceFullPull(context.Background(), "group"); err != nil {
+		t.Fatal(err)
+	}
+	if got := pull(rep, 5); got != NilCookie {
+		t.Errorf("handler saw cookie %d from before the full pull was forced, want NilCookie", got)
+	}
+	if got := pull(rep, 6); got != 6 {
+		t.Errorf("handler saw cookie %d issued after the full pull was forced, want 6", got)
+	}
+
+	// cookies of other schemes aren't compared with versions, and cost no
+	// extra query
+	other := newTestReplicache(t, db, h)
+	queries := f.count("reset_version FROM replicache_version")
+	if got := pull(other, 5); got != 5 {
+		t.Errorf("handler without version cookies saw cookie %d, want 5", got)
+	}
+	if n := f.count("reset_version FROM replicache_version"); n != queries {
+		t.Errorf("pull without version cookies read the reset version %d times", n-queries)
+	}
+
+	if err := rep.ForceFullPull(context.Background(), "missing"); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("ForceFullPull of a missing group = %v, want ErrClientNotFound", err)
+	}
+}
+
+func TestFutureCookie(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		f, db := newFakeDB(t)
+		f.addClient("group", "client", 1)
+		f.mu.Lock()
+		f.state.groups["group"].version = 5
+		f.mu.Unlock()
+		var cookie Cookie
+		var options []Option
+		if reset {
+			options = append(options, WithFutureCookieReset())
+		}
+		rep := newTestReplicache(t, db, testHandler{pull: func(_ context.Context, pr PullRequest) (any, error) {
+			cookie = pr.Cookie
+			return nil, nil
+		}}, options...)
+
+		for _, tc := range []struct {
+			cookie Cookie
+			want   Cookie
+		}{
+			{5, 5},
+			// restored from a backup taken before version 9
+			{9, 9},
+		} {
+			if reset && tc.cookie > 5 {
+				tc.want = NilCookie
+			}
+			if w := post(t, rep.PullHandler(), pullRequest("group", tc.cookie)); w.Code != http.StatusOK {
+				t.Fatalf("reset %v: pull status = %d: %s", reset, w.Code, w.Body)
+			}
+			if cookie != tc.want {
+				t.Errorf("reset %v: handler saw cookie %d for %d, want %d", reset, cookie, tc.cookie, tc.want)
+			}
+		}
+		want := int64(0)
+		if reset {
+			want = 1
+		}
+		if n := rep.Stats().FutureVersionCount; n != want {
+			t.Errorf("reset %v: counted %d future cookies, want %d", reset, n, want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+var errMalformedPush = errors.New("replicache: malformed push request")
+
+// streamPush decodes the push body one mutation at a time and hands each
+// mutation to the handler as soon as it is decoded, so that the whole
+// mutation array is never held in memory. Mutations must arrive ordered per
+// client, which the Replicache protocol guarantees. Fields that follow the
+// mutations array in the body, usually schemaVersion, are not available to
+// the handler. Quotas are always checked per mutation when streaming,
+// pushVersion must precede mutations so that unsupported versions are
+// refused before anything is applied, schemaVersion must precede mutations
+// when WithSupportedSchemaVersions is set, and profileID must precede
+// mutations when WithDBRouter is set.
+func (rep *Replicache) streamPush(w http.ResponseWriter, r *http.Request, body *countingReader) {
+	sp := &streamingPush{
+		rep:             rep,
+		info:            ClientInfo{Auth: r.Header.Get("Authorization")},
+		lastMutationIDs: make(map[string]int64),
+		previous:        make(map[string]int64),
+		result:          PushResult{LastMutationIDs: make(map[string]int64)},
+	}
+	decoded, capture := rep.captureBody(r.Body)
+	err := sp.run(r.Context(), decoded)
+	if sp.info.ClientGroupID != "" {
+		rep.accountPushSize(r.Context(), sp.info.ClientGroupID, r.ContentLength, body.n)
+	}
+	if err != nil {
+		if errors.Is(err, errMalformedPush) {
+			rep.logMalformedPush(r.Context(), capture, err)
+		}
+		rep.captureFailedPush(r.Context(), capture, sp.info, err)
+		if errors.Is(err, errMalformedPush) {
+			rep.writeDecodeError(w, r, err)
+			return
+		}
+		rep.writeError(w, r, err)
+		return
+	}
+	sp.result.ClientGroupID = sp.info.ClientGroupID
+	sp.result.ProfileID = sp.info.ProfileID
+	rep.logPushSuccess(r.Context(), sp.result)
+	rep.writePushSuccess(w, r, sp.result)
+}
+
+type streamingPush struct {
+	rep    *Replicache
+	info   ClientInfo
+	result PushResult
+	events clientEvents
+
+	previousSchemaVersion string
+	tx                    *sql.Tx
+	lastMutationIDs       map[string]int64
+	previous              map[string]int64
+	applied               []Mutation
+}
+
+func (sp *streamingPush) run(ctx context.Context, body io.Reader) (err error) {
+	dec := json.NewDecoder(body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	defer func() {
+		if sp.tx != nil {
+			if err != nil {
+				sp.rep.beforeRollback(ctx, sp.info, err)
+			}
+			sp.tx.Rollback()
+		}
+	}()
+
+	var pushVersion int
+	var sawPushVersion bool
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("%w: %w", errMalformedPush, err)
+		}
+		key, _ := tok.(string)
+		switch key {
+		case "pushVersion":
+			if err = dec.Decode(&pushVersion); err == nil {
+				sawPushVersion = true
+				err = sp.rep.checkPushVersion(pushVersion)
+			}
+		case "clientGroupID":
+			if err = dec.Decode(&sp.info.ClientGroupID); err == nil {
+				sp.info.ClientGroupID = sp.rep.normalizeID(sp.info.ClientGroupID)
+				err = checkIDLength("clientGroupID", sp.info.ClientGroupID, sp.rep.maxClientGroupIDLength)
+			}
+		case "profileID":
+			if err = dec.Decode(&sp.info.ProfileID); err == nil {
+				sp.info.ProfileID = sp.rep.normalizeID(sp.info.ProfileID)
+				err = checkIDLength("profileID", sp.info.ProfileID, sp.rep.maxProfileIDLength)
+			}
+		case "schemaVersion":
+			err = dec.Decode(&sp.info.SchemaVersion)
+		case "mutations":
+			if !sawPushVersion {
+				return fmt.Errorf("%w: pushVersion must precede mutations when streaming", errMalformedPush)
+			}
+			err = sp.mutations(ctx, dec)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%w: %w", errMalformedPush, err)
+			}
+			return err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+	if err := sp.rep.checkPushVersion(pushVersion); err != nil {
+		return err
+	}
+	if err := sp.flush(ctx); err != nil {
+		return err
+	}
+	if len(sp.result.Rejected) > 0 {
+		return &QuotaExceededError{Mutations: sp.result.Rejected}
+	}
+	return nil
+}
+
+// flush records the last mutation IDs and commits the open transaction, if
+// any.
+func (sp *streamingPush) flush(ctx context.Context) error {
+	if sp.tx == nil {
+		return nil
+	}
+	if err := sp.rep.saveLastMutationIDs(ctx, sp.tx, sp.info.ClientGroupID, sp.previous, sp.lastMutationIDs); err != nil {
+		return err
+	}
+	err := sp.rep.commit(sp.tx)
+	sp.tx = nil
+	if err == nil {
+		for clientID, lmid := range sp.lastMutationIDs {
+			sp.result.LastMutationIDs[clientID] = lmid
+		}
+		sp.rep.recordApplied(sp.lastMutationIDs)
+		sp.rep.logApplied(ctx, sp.info.ClientGroupID, sp.applied)
+		sp.rep.clientCache.update(sp.info.ClientGroupID, sp.lastMutationIDs)
+		sp.rep.recordSync(ctx, sp.info.ClientGroupID, "last_push_at")
+		sp.rep.fireClientEvents(ctx, sp.info, sp.events)
+	}
+	sp.events = clientEvents{}
+	sp.applied = nil
+	// reload client state with fresh locks in the next transaction
+	sp.lastMutationIDs = make(map[string]int64)
+	sp.previous = make(map[string]int64)
+	return err
+}
+
+func (sp *streamingPush) mutations(ctx context.Context, dec *json.Decoder) error {
+	if sp.info.ClientGroupID == "" {
+		return fmt.Errorf("%w: clientGroupID must precede mutations when streaming", errMalformedPush)
+	}
+	sp.rep.Freeze()
+	sp.rep.logger.Log(ctx, sp.rep.logLevel(LogEventPushStart, slog.LevelDebug), "replicache push started",
+		slog.String("client_group_id", sp.info.ClientGroupID),
+	)
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	if err := sp.rep.applyAnonymousPolicy(&sp.info); err != nil {
+		return err
+	}
+	rep, err := sp.rep.routeDB(sp.info)
+	if err != nil {
+		return err
+	}
+	sp.rep = rep
+	if err := sp.rep.checkClientSchemaVersion(sp.info.SchemaVersion); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		var m Mutation
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+		m.ClientID = sp.rep.normalizeID(m.ClientID)
+		if err := sp.rep.checkClientIDLengths([]Mutation{m}); err != nil {
+			return err
+		}
+		canonical, err := sp.rep.canonicalizeMutations([]Mutation{m})
+		if err != nil {
+			return err
+		}
+		m = canonical[0]
+		if err := sp.apply(ctx, m); err != nil {
+			return err
+		}
+		if sp.rep.txScope == TransactionPerMutation {
+			if err := sp.flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func (sp *streamingPush) apply(ctx context.Context, m Mutation) error {
+	if sp.tx == nil {
+		tx, err := sp.rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+		if err != nil {
+			return err
+		}
+		sp.tx = tx
+		if reset, err := sp.rep.checkIdentity(ctx, tx, sp.info); err != nil {
+			return err
+		} else if reset {
+			sp.tx = nil
+			return sp.rep.commitIdentityReset(ctx, tx, sp.info)
+		}
+		if sp.previousSchemaVersion, err = sp.rep.recordSchemaVersion(ctx, tx, sp.info, &sp.events); err != nil {
+			return err
+		}
+		if err := sp.rep.upgradeSchemaVersion(ctx, tx, sp.info); err != nil {
+			return err
+		}
+	}
+
+	batch := sp.rep.dropSeenMutations([]Mutation{m})
+	if len(batch) == 0 {
+		sp.result.Skipped++
+		return nil
+	}
+	if _, ok := sp.lastMutationIDs[m.ClientID]; !ok {
+		loaded, err := sp.rep.loadClients(ctx, sp.tx, sp.info, batch, &sp.events)
+		if err != nil {
+			return err
+		}
+		sp.lastMutationIDs[m.ClientID] = loaded[m.ClientID]
+		sp.previous[m.ClientID] = loaded[m.ClientID]
+	}
+
+	pending, err := sp.rep.pendingMutations(ctx, sp.info.ClientGroupID, batch, sp.lastMutationIDs)
+	if err != nil {
+		return err
+	}
+	pending, err = sp.rep.dropDuplicateArgs(ctx, sp.tx, sp.info.ClientGroupID, pending)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		sp.result.Skipped++
+		return nil
+	}
+	pending, rejected, err := sp.rep.checkQuota(ctx, sp.tx, sp.info, pending)
+	sp.result.Rejected = append(sp.result.Rejected, rejected...)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	if err := sp.rep.pushHandler.HandlePush(ctx, PushRequest{
+		ClientInfo:            sp.info,
+		Mutations:             pending,
+		PreviousSchemaVersion: sp.previousSchemaVersion,
+		Tx:                    sp.tx,
+	}); err != nil {
+		return wrapMutationError(err, m, sp.info.ClientGroupID)
+	}
+	if err := sp.rep.recordArgs(ctx, sp.tx, sp.info.ClientGroupID, pending); err != nil {
+		return err
+	}
+	sp.result.Applied++
+	sp.applied = append(sp.applied, pending...)
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errMalformedPush, err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("%w: expected %q", errMalformedPush, want)
+	}
+	return nil
+}
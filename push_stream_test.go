@@ -0,0 +1,89 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStreamingPushRequiresPushVersionFirst(t *testing.T) {
+	_, db := newFakeDB(t)
+	var calls int
+	rep := newTestReplicache(t, db, testHandler{push: func(context.Context, PushRequest) error {
+		calls++
+		return nil
+	}}, WithClientOnPush(true), WithStreamingPush())
+
+	// an unsupported version after the mutations must not let them apply
+	w := post(t, rep.PushHandler(), `{"clientGroupID":"group","profileID":"profile","mutations":[{"clientID":"client","id":1,"name":"m"}],"pushVersion":2}`)
+	if w.Code == http.StatusOK {
+		t.Fatalf("push status = %d, want an error", w.Code)
+	}
+	if calls != 0 {
+		t.Errorf("handler ran %d times before the push version was checked", calls)
+	}
+
+	w = post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestStreamingPushStopsAtFailingMutation(t *testing.T) {
+	f, db := newFakeDB(t)
+	errFailed := errors.New("failed")
+	rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+		if pr.Mutations[0].ID == 2 {
+			return errFailed
+		}
+		return nil
+	}}, WithClientOnPush(true), WithStreamingPush())
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m"), mutation("client", 2, "m")))
+	if w.Code == http.StatusOK {
+		t.Fatalf("push status = %d, want an error", w.Code)
+	}
+	if _, ok := f.client("group", "client"); ok {
+		t.Error("client was committed although the push failed")
+	}
+}
+
+// BenchmarkPush10kMutations compares the memory used to push 10,000
+// mutations when the body is buffered and when it is streamed.
+func BenchmarkPush10kMutations(b *testing.B) {
+	mutations := make([]Mutation, 10000)
+	for i := range mutations {
+		mutations[i] = Mutation{
+			ClientID: "client",
+			ID:       i + 1,
+			Name:     "update",
+			Args:     []byte(fmt.Sprintf(`{"id":%d,"title":%q}`, i, strings.Repeat("x", 100))),
+		}
+	}
+	for _, bc := range []struct {
+		name    string
+		options []Option
+	}{
+		{"buffered", nil},
+		{"streaming", []Option{WithStreamingPush()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				_, db := newFakeDB(b)
+				rep := newTestReplicache(b, db, testHandler{}, append([]Option{WithClientOnPush(true)}, bc.options...)...)
+				b.StartTimer()
+				if w := post(b, rep.PushHandler(), pushRequest("group", mutations...)); w.Code != http.StatusOK {
+					b.Fatalf("push status = %d", w.Code)
+				}
+			}
+		})
+	}
+}
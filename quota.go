@@ -0,0 +1,146 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded should be returned, optionally wrapped, by a QuotaChecker
+// when a push would exceed the quota. Any other error fails the push.
+var ErrQuotaExceeded = errors.New("replicache: quota exceeded")
+
+// QuotaChecker is called inside the push transaction, before the handler
+// runs, with the summed size of the args about to be applied.
+type QuotaChecker interface {
+	Check(ctx context.Context, tx *sql.Tx, info ClientInfo, deltaBytes int64) error
+}
+
+type QuotaScope int
+
+const (
+	// QuotaPerPush checks the args of all pending mutations at once and
+	// rejects every one of them if the quota is exceeded.
+	QuotaPerPush QuotaScope = iota
+
+	// QuotaPerMutation checks each mutation on its own and only rejects the
+	// mutations that would exceed the quota.
+	QuotaPerMutation
+)
+
+// QuotaExceededError is returned after the push has been committed when
+// mutations were rejected by the QuotaChecker. Rejected mutations are
+// treated as permanently failed: their IDs are recorded as applied so that
+// the client doesn't retry them.
+type QuotaExceededError struct {
+	Mutations []Mutation
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: %d mutations rejected", ErrQuotaExceeded, len(e.Mutations))
+}
+
+func (e *QuotaExceededError) Unwrap() error { return ErrQuotaExceeded }
+
+type rejectedMutation struct {
+	ClientID string `json:"clientID"`
+	ID       int    `json:"id"`
+}
+
+func (e *QuotaExceededError) response() any {
+	rejected := make([]rejectedMutation, 0, len(e.Mutations))
+	for _, m := range e.Mutations {
+		rejected = append(rejected, rejectedMutation{ClientID: m.ClientID, ID: m.ID})
+	}
+	return struct {
+		Error     string             `json:"error"`
+		Mutations []rejectedMutation `json:"mutations"`
+	}{
+		Error:     "quota_exceeded",
+		Mutations: rejected,
+	}
+}
+
+// checkQuota splits mutations into those that fit the quota and those that
+// were rejected.
+func (rep *Replicache) checkQuota(ctx context.Context, tx *sql.Tx, info ClientInfo, mutations []Mutation) (kept, rejected []Mutation, err error) {
+	if rep.quota == nil || len(mutations) == 0 {
+		return mutations, nil, nil
+	}
+
+	if rep.quotaScope == QuotaPerPush {
+		var total int64
+		for _, m := range mutations {
+			total += int64(len(m.Args))
+		}
+		err := rep.checkQuotaHook(ctx, tx, info, total)
+		switch {
+		case errors.Is(err, ErrQuotaExceeded):
+			return nil, mutations, nil
+		case err != nil:
+			return nil, nil, err
+		}
+		return mutations, nil, nil
+	}
+
+	for _, m := range mutations {
+		err := rep.checkQuotaHook(ctx, tx, info, int64(len(m.Args)))
+		switch {
+		case errors.Is(err, ErrQuotaExceeded):
+			rejected = append(rejected, m)
+		case err != nil:
+			return nil, nil, err
+		default:
+			kept = append(kept, m)
+		}
+	}
+	return kept, rejected, nil
+}
+
+// checkQuotaHook calls the quota checker as a transaction hook.
+func (rep *Replicache) checkQuotaHook(ctx context.Context, tx *sql.Tx, info ClientInfo, deltaBytes int64) error {
+	return rep.runTxHook(ctx, hookQuota, info.ClientGroupID, func(ctx context.Context) error {
+		return rep.quota.Check(ctx, tx, info, deltaBytes)
+	})
+}
+
+// SQLQuotaChecker limits the total size of args pushed by each profile. Usage
+// is kept in the replicache_quota_usage table and updated inside the push
+// transaction, so it is rolled back with a failed push. Requests without a
+// profileID are counted per client group, under the profile ID
+// AnonymousAssign would give them, so anonymous users don't share a quota.
+type SQLQuotaChecker struct {
+	LimitBytes int64
+}
+
+func (q SQLQuotaChecker) Check(ctx context.Context, tx *sql.Tx, info ClientInfo, deltaBytes int64) error {
+	if deltaBytes > q.LimitBytes {
+		return ErrQuotaExceeded
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO replicache_quota_usage (profile_id, bytes) VALUES ($1, $2)
+		ON CONFLICT (profile_id) DO UPDATE SET bytes = replicache_quota_usage.bytes + EXCLUDED.bytes
+		WHERE replicache_quota_usage.bytes + EXCLUDED.bytes <= $3`,
+		quotaProfileID(info), deltaBytes, q.LimitBytes,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// quotaProfileID returns the profile whose usage a request counts towards.
+func quotaProfileID(info ClientInfo) string {
+	if info.ProfileID == "" {
+		return anonymousProfileID(info.ClientGroupID)
+	}
+	return info.ProfileID
+}
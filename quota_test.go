@@ -0,0 +1,53 @@
+package replicache
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSQLQuotaCheckerAnonymous(t *testing.T) {
+	f, db := newFakeDB(t)
+	usage := make(map[string]int64)
+	f.on(`INSERT INTO replicache_quota_usage`, func(_ *fakeState, args []driver.Value) (fakeResult, error) {
+		profileID, delta, limit := str(args[0]), num(args[1]), num(args[2])
+		if usage[profileID]+delta > limit {
+			return fakeResult{}, nil
+		}
+		usage[profileID] += delta
+		return fakeResult{affected: 1}, nil
+	})
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithQuotaChecker(SQLQuotaChecker{LimitBytes: 10}, QuotaPerPush),
+	)
+
+	push := func(group string, id int) string {
+		m := mutation(group+"-client", id, "m")
+		m.Args = []byte(`"12345678"`)
+		req := pushRequest(group, m)
+		req.ProfileID = ""
+		w := post(t, rep.PushHandler(), req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+		}
+		return w.Body.String()
+	}
+	// each anonymous group has its own quota, which one push fills
+	if body := push("a", 1); body != "" {
+		t.Errorf("first push of group a was rejected: %s", body)
+	}
+	if body := push("b", 1); body != "" {
+		t.Errorf("group b was charged for group a's usage: %s", body)
+	}
+	if body := push("a", 2); !strings.Contains(body, "quota_exceeded") {
+		t.Errorf("push over group a's quota got %q, want quota_exceeded", body)
+	}
+	if _, ok := usage[""]; ok {
+		t.Error("anonymous usage was recorded under an empty profile")
+	}
+	if usage[anonymousProfileID("a")] != 10 || usage[anonymousProfileID("b")] != 10 {
+		t.Errorf("usage = %v, want 10 bytes for each group", usage)
+	}
+}
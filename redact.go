@@ -0,0 +1,62 @@
+package replicache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// ArgRedactor rewrites mutation args before they reach a log record, an
+// error message or the failed request store. Args often carry emails and
+// access tokens.
+type ArgRedactor func(name string, args json.RawMessage) json.RawMessage
+
+// redactedSummary describes redacted data by its length and SHA-256 hash,
+// which is enough to tell two payloads apart without revealing them.
+func redactedSummary(b []byte) json.RawMessage {
+	sum := sha256.Sum256(b)
+	summary, _ := json.Marshal(map[string]any{
+		"redacted": true,
+		"length":   len(b),
+		"sha256":   hex.EncodeToString(sum[:]),
+	})
+	return summary
+}
+
+// defaultArgRedactor replaces args entirely with a length and hash summary.
+func defaultArgRedactor(name string, args json.RawMessage) json.RawMessage {
+	return redactedSummary(args)
+}
+
+// redactPushBody runs the args of every mutation in a push body through the
+// redactor. A body that can't be parsed, for example because it was
+// truncated, is replaced by a summary since its args can't be located.
+func (rep *Replicache) redactPushBody(body []byte) []byte {
+	var push map[string]json.RawMessage
+	var mutations []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &push); err != nil {
+		return redactedSummary(body)
+	}
+	if raw, ok := push["mutations"]; ok {
+		if err := json.Unmarshal(raw, &mutations); err != nil {
+			return redactedSummary(body)
+		}
+		for _, m := range mutations {
+			var name string
+			json.Unmarshal(m["name"], &name)
+			if args, ok := m["args"]; ok {
+				m["args"] = rep.argRedactor(name, args)
+			}
+		}
+		redacted, err := json.Marshal(mutations)
+		if err != nil {
+			return redactedSummary(body)
+		}
+		push["mutations"] = redacted
+	}
+	redacted, err := json.Marshal(push)
+	if err != nil {
+		return redactedSummary(body)
+	}
+	return redacted
+}
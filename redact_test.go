@@ -0,0 +1,73 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// sentinelSecret stands in for an access token in mutation args. It must
+// never show up in rendered logs or captured bodies.
+const sentinelSecret = "sk-live-SENTINEL-7f3a9c"
+
+func TestArgsNeverLogged(t *testing.T) {
+	_, db := newFakeDB(t)
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	var captured []byte
+	fail := true
+	h := testHandler{push: func(ctx context.Context, pr PushRequest) error {
+		for _, m := range pr.Mutations {
+			logger.InfoContext(ctx, "applying", slog.Any("mutation", m))
+		}
+		if fail {
+			return errors.New("handler failed")
+		}
+		return nil
+	}}
+	rep := newTestReplicache(t, db, h,
+		WithLogger(logger),
+		WithClientOnPush(true),
+		WithFailedRequestCapture(4096, func(_ context.Context, _ ClientInfo, body []byte, _ error) {
+			captured = append(captured, body...)
+		}),
+	)
+
+	m := mutation("client", 1, "login")
+	m.Args = json.RawMessage(`{"email":"a@example.com","token":"` + sentinelSecret + `"}`)
+	if w := post(t, rep.PushHandler(), pushRequest("group", m)); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failing push status = %d", w.Code)
+	}
+	fail = false
+	if w := post(t, rep.PushHandler(), pushRequest("group", m)); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+
+	// a malformed body is logged, but not its args
+	rep.logMalformedBody = true
+	body, _ := json.Marshal(pushRequest("group", m))
+	if w := post(t, rep.PushHandler(), string(body[:len(body)-1])); w.Code == http.StatusOK {
+		t.Fatal("truncated push was accepted")
+	}
+	if !strings.Contains(logs.String(), "malformed push body") {
+		t.Error("malformed push body wasn't logged")
+	}
+
+	if len(captured) == 0 {
+		t.Fatal("failed push wasn't captured")
+	}
+	if bytes.Contains(captured, []byte(sentinelSecret)) {
+		t.Errorf("captured body leaks the secret: %s", captured)
+	}
+	if logs.Len() == 0 {
+		t.Fatal("nothing was logged")
+	}
+	if strings.Contains(logs.String(), sentinelSecret) {
+		t.Errorf("logs leak the secret:\n%s", logs.String())
+	}
+}
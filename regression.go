@@ -0,0 +1,155 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrLastMutationIDRegression is returned when a client's stored last
+// mutation ID looks like it moved backward, usually after a database
+// restore. The push is refused rather than risk re-applying or dropping
+// mutations.
+var ErrLastMutationIDRegression = errors.New("replicache: last mutation ID regression")
+
+// LevelCritical is the level of log entries for conditions that need an
+// operator's attention right away.
+const LevelCritical = slog.LevelError + 4
+
+// LastMutationIDRegressionError carries the stored and received mutation IDs
+// of the client that regressed.
+type LastMutationIDRegressionError struct {
+	ClientGroupID string
+	ClientID      string
+	Stored        int64
+	Received      int64
+}
+
+func (e *LastMutationIDRegressionError) Error() string {
+	return fmt.Sprintf("%s: client %s has last mutation ID %d, received %d", ErrLastMutationIDRegression, e.ClientID, e.Stored, e.Received)
+}
+
+func (e *LastMutationIDRegressionError) Unwrap() error { return ErrLastMutationIDRegression }
+
+func (e *LastMutationIDRegressionError) response() map[string]string {
+	return map[string]string{"error": "LastMutationIDRegression"}
+}
+
+// regression logs a critical event for a last mutation ID regression and
+// returns the error to fail the push with.
+func (rep *Replicache) regression(ctx context.Context, clientGroupID, clientID string, stored, received int64) error {
+	rep.logger.Log(ctx, LevelCritical, "replicache last mutation ID regression",
+		slog.String("client_group_id", clientGroupID),
+		slog.String("client_id", clientID),
+		slog.Int64("stored_last_mutation_id", stored),
+		slog.Int64("received_mutation_id", received),
+	)
+	return &LastMutationIDRegressionError{
+		ClientGroupID: clientGroupID,
+		ClientID:      clientID,
+		Stored:        stored,
+		Received:      received,
+	}
+}
+
+// checkClientDivergence compares the last mutation IDs clients claim with the
+// stored ones. A client ahead of the server means the database was restored
+// from a backup, and pulling on would silently lose the client's mutations,
+// so it is logged as critical and the client is told to reset.
+func (rep *Replicache) checkClientDivergence(ctx context.Context, tx *sql.Tx, clientGroupID string, claimed map[string]int64) error {
+	args := []any{clientGroupID}
+	for clientID := range claimed {
+		args = append(args, clientID)
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND client_id IN (`+placeholders(2, len(claimed))+`)`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	stored := make(map[string]int64)
+	for rows.Next() {
+		var clientID string
+		var lmid int64
+		if err := rows.Scan(&clientID, &lmid); err != nil {
+			return err
+		}
+		stored[clientID] = lmid
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for clientID, lmid := range claimed {
+		if lmid <= stored[clientID] {
+			continue
+		}
+		rep.logger.Log(ctx, LevelCritical, "replicache client ahead of server",
+			slog.String("client_group_id", clientGroupID),
+			slog.String("client_id", clientID),
+			slog.Int64("stored_last_mutation_id", stored[clientID]),
+			slog.Int64("client_last_mutation_id", lmid),
+		)
+		return fmt.Errorf("%w: client %s claims last mutation ID %d, server has %d", ErrClientNotFound, clientID, lmid, stored[clientID])
+	}
+	return nil
+}
+
+// ForceLastMutationID sets the last mutation ID of a client, even backward.
+// It is meant for deliberate repairs after a regression was reported and
+// returns ErrClientNotFound if the client isn't registered in the client
+// group. The client group version is bumped so the next pull reports the
+// new ID.
+func (rep *Replicache) ForceLastMutationID(ctx context.Context, clientGroupID, clientID string, lastMutationID int64) error {
+	rep, err := rep.routeGroup(ctx, clientGroupID)
+	if err != nil {
+		return err
+	}
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	err = tx.QueryRowContext(ctx,
+		`UPDATE replicache_version SET version = version + 1 WHERE client_group_id = $1 RETURNING version`,
+		clientGroupID,
+	).Scan(&version)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: client group %s", ErrClientNotFound, clientGroupID)
+	case err != nil:
+		return err
+	}
+	res, err := tx.ExecContext(ctx,
+		`UPDATE replicache_clients SET last_mutation_id = $1, last_modified_version = $2, updated_at = $3 WHERE client_group_id = $4 AND client_id = $5`,
+		lastMutationID, version, rep.clock.Now(), clientGroupID, clientID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s in group %s", ErrClientNotFound, clientID, clientGroupID)
+	}
+	if err := rep.commit(tx); err != nil {
+		return err
+	}
+	rep.clientCache.invalidate(clientGroupID)
+	rep.forgetDuplicates(clientID)
+	rep.logger.Warn("replicache last mutation ID forced",
+		slog.String("client_group_id", clientGroupID),
+		slog.String("client_id", clientID),
+		slog.Int64("last_mutation_id", lastMutationID),
+	)
+	return nil
+}
@@ -0,0 +1,63 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestForceLastMutationIDScopedToGroup(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.addClient("g", "c", 5)
+	f.addClient("other", "c", 5)
+	rep := newTestReplicache(t, db, testHandler{})
+
+	if err := rep.ForceLastMutationID(context.Background(), "g", "c", 2); err != nil {
+		t.Fatal(err)
+	}
+	g, _ := f.group("g")
+	c, _ := f.client("g", "c")
+	if c.lastMutationID != 2 || c.lastModifiedVersion != g.version || g.version != 1 {
+		t.Errorf("forced client = %+v at group version %v, want last mutation ID 2 modified at version 1", c, g.version)
+	}
+	if c, _ := f.client("other", "c"); c.lastMutationID != 5 {
+		t.Errorf("client of another group was forced to %d", c.lastMutationID)
+	}
+
+	err := rep.ForceLastMutationID(context.Background(), "g", "missing", 1)
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("forcing an unknown client = %v, want ErrClientNotFound", err)
+	}
+	err = rep.ForceLastMutationID(context.Background(), "missing", "c", 1)
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("forcing in an unknown group = %v, want ErrClientNotFound", err)
+	}
+}
+
+func TestForceLastMutationIDForgetsDuplicates(t *testing.T) {
+	_, db := newFakeDB(t)
+	var applied []int
+	h := testHandler{push: func(_ context.Context, pr PushRequest) error {
+		for _, m := range pr.Mutations {
+			applied = append(applied, int(m.ID))
+		}
+		return nil
+	}}
+	rep := newTestReplicache(t, db, h, WithClientOnPush(true), WithDuplicateWindowSize(10))
+
+	push := func(muts ...Mutation) {
+		if w := post(t, rep.PushHandler(), pushRequest("g", muts...)); w.Code != http.StatusOK {
+			t.Fatalf("push status = %d: %s", w.Code, w.Body)
+		}
+	}
+	push(mutation("c", 1, "m"), mutation("c", 2, "m"))
+	if err := rep.ForceLastMutationID(context.Background(), "g", "c", 1); err != nil {
+		t.Fatal(err)
+	}
+	applied = nil
+	push(mutation("c", 2, "m"))
+	if len(applied) != 1 || applied[0] != 2 {
+		t.Errorf("re-sent mutation after forcing back applied %v, want [2]", applied)
+	}
+}
@@ -1,26 +1,138 @@
 package replicache
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Replicache is safe for concurrent use. Its configuration is fixed by the
+// options passed to NewReplicache, and the handlers it serves stop accepting
+// registrations at the first request or at Freeze. State that changes while
+// serving, such as counters, caches and maintenance mode, is synchronized
+// internally and shared with copies made by WithContext.
 type Replicache struct {
+	ctx                 context.Context
 	logger              *slog.Logger
 	db                  *sql.DB
-	handler             Handler
+	pushHandler         PushHandler
+	pullHandler         PullHandler
 	clientOnPush        bool
 	clientOnPull        bool
 	clientPurgeDuration time.Duration
+	clientFactory       ClientFactory
+	breaker             *circuitBreaker
+	breakers            *breakerSet
+	serverPushPaths     []string
+	streamingPush       bool
+	dedupeByArgs        bool
+	dedupeByArgsTTL     time.Duration
+	anonymousPolicy     AnonymousPolicy
+	quota               QuotaChecker
+	quotaScope          QuotaScope
+	pushSizeAccounting  func(ctx context.Context, clientGroupID string, bytes int64)
+	txScope             TransactionScope
+	cvrStore            CVRStore
+	pullScopes          bool
+	pushVersions        []int
+	pushMethods         []string
+	pullVersions        []int
+	pushPath            string
+	pullPath            string
+	pullLock            bool
+	preRollback         func(ctx context.Context, info ClientInfo, err error)
+	selfTestMutator     string
+	pushSuccessBody     func(result PushResult) any
+	newClientHook       func(ctx context.Context, info ClientInfo, clientID string)
+	newGroupHook        func(ctx context.Context, info ClientInfo)
+	schemaVersions      []string
+	counters            *counters
+	logLevelMapper      LogLevelMapper
+	logLevels           map[LogEvent]slog.Level
+	autoMigrateEnabled  bool
+	statusHook          StatusHook
+	responseHeaders     bool
+	pushRetry           *pushRetry
+	nameNormalizer      func(string) string
+	duplicateWindow     int
+	duplicates          *DuplicateDetector
+	sparsePull          bool
+	maintenance         *maintenanceState
+	maintenanceRefresh  time.Duration
+	lazyTx              bool
+	lastMutationIDGap   int64
+	newEncoder          func(w io.Writer) Encoder
+	dbRouter            *DBRouter
+	syncLagTracking     bool
+	maxGroupsPerProfile int
+	groupLimitPolicy    GroupLimitPolicy
+	argRedactor         ArgRedactor
+	clientCache         *clientStateCache
+	slowQueryThreshold  time.Duration
+	hookTimeout         time.Duration
+	shadow              *shadow
+	futureCookieCheck   bool
+	versionCookies      bool
+	canonicalizeArgs    bool
+	identity            IdentityFunc
+	identityPolicy      IdentityChangePolicy
+	partialPushSuccess  bool
+	cookieHorizon       CookieHorizonFunc
+	staleCookiePolicy   StaleCookiePolicy
+	idNormalizer        func(string) string
+	serverID            string
+	pullGroupState      bool
+	schemaUpgrade       SchemaUpgradeFunc
+	clock               clock
+	freeze              *sync.Once
+
+	failedRequestStore    FailedRequestStore
+	failedRequestMaxBytes int
+	logMalformedBody      bool
+	maxDecompressedBytes  int64
+
+	maxClientGroupIDLength int
+	maxProfileIDLength     int
+	maxClientIDLength      int
 }
 
 func (rep *Replicache) PushHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withErrorEvent(r, LogEventPushError)
+		if rep.pushHandler == nil {
+			rep.respond(w, r, http.StatusMethodNotAllowed, nil, ErrNoHandler)
+			return
+		}
+		if !rep.allowMethod(w, r, rep.pushMethods) {
+			return
+		}
+		if err := rep.checkMaintenance(); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		// count the bytes as sent, before decompression
+		body := &countingReader{r: r.Body}
+		r.Body = readCloser{body, r.Body}
+		if err := rep.decodeRequestBody(r); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		if rep.streamingPush {
+			rep.streamPush(w, r, body)
+			return
+		}
 		req := struct {
 			PushVersion   int        `json:"pushVersion"`
 			ClientGroupID string     `json:"clientGroupID"`
@@ -28,59 +140,281 @@ func (rep *Replicache) PushHandler() http.Handler {
 			ProfileID     string     `json:"profileID"`
 			SchemaVersion string     `json:"schemaVersion"`
 		}{}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PushVersion != 1 {
-			w.WriteHeader(http.StatusInternalServerError)
+		decoded, capture := rep.captureBody(r.Body)
+		info := ClientInfo{Auth: r.Header.Get("Authorization")}
+		if err := json.NewDecoder(decoded).Decode(&req); err != nil {
+			rep.logMalformedPush(r.Context(), capture, err)
+			rep.captureFailedPush(r.Context(), capture, info, err)
+			rep.writeDecodeError(w, r, err)
+			return
+		}
+		info.ClientGroupID = req.ClientGroupID
+		info.ProfileID = req.ProfileID
+		info.SchemaVersion = req.SchemaVersion
+		rep.normalizeIDs(&info, req.Mutations)
+		fail := func(err error) {
+			rep.captureFailedPush(r.Context(), capture, info, err)
+			rep.writeError(w, r, err)
+		}
+		if err := rep.checkPushVersion(req.PushVersion); err != nil {
+			fail(err)
+			return
+		}
+		if err := rep.checkClientInfoLengths(info); err != nil {
+			fail(err)
+			return
+		}
+		if err := rep.checkClientIDLengths(req.Mutations); err != nil {
+			fail(err)
 			return
 		}
-		if err := rep.handlePush(r.Context(), ClientInfo{
-			Auth:          r.Header.Get("Authorization"),
-			ClientGroupID: req.ClientGroupID,
-			ProfileID:     req.ProfileID,
-			SchemaVersion: req.SchemaVersion,
-		},
-			req.Mutations,
-		); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		rep.accountPushSize(r.Context(), info.ClientGroupID, r.ContentLength, body.n)
+		result, err := rep.handlePush(r.Context(), info, req.Mutations)
+		if err != nil {
+			fail(err)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
+		rep.writePushSuccess(w, r, result)
 	})
 }
 
-func (rep *Replicache) handlePush(ctx context.Context, info ClientInfo, mutations []Mutation) error {
+func (rep *Replicache) writePushSuccess(w http.ResponseWriter, r *http.Request, result PushResult) {
+	var body any
+	if rep.pushSuccessBody != nil {
+		body = rep.pushSuccessBody(result)
+	}
+	rep.setClientHeaders(w, result.ClientGroupID, result.ProfileID)
+	rep.respond(w, r, http.StatusOK, body, nil)
+}
 
-	tx, err := rep.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+func (rep *Replicache) handlePush(ctx context.Context, info ClientInfo, mutations []Mutation) (PushResult, error) {
+	if rep.pushHandler == nil {
+		return PushResult{}, ErrNoHandler
+	}
+	rep.Freeze()
+	rep.logger.Log(ctx, rep.logLevel(LogEventPushStart, slog.LevelDebug), "replicache push started",
+		slog.String("client_group_id", info.ClientGroupID),
+		slog.Int("mutations", len(mutations)),
+	)
+	if err := rep.applyAnonymousPolicy(&info); err != nil {
+		return PushResult{}, err
+	}
+	rep, err := rep.routeDB(info)
 	if err != nil {
-		return err
+		return PushResult{}, err
+	}
+	if err := rep.checkClientSchemaVersion(info.SchemaVersion); err != nil {
+		return PushResult{}, err
+	}
+	if mutations, err = rep.canonicalizeMutations(mutations); err != nil {
+		return PushResult{}, err
+	}
+	// only short-circuit once the push passed the same checks as any other
+	// push, so a re-send can't bypass them; identities are checked in the
+	// push transaction, so the cache is skipped when they are tracked
+	if rep.identity == nil && rep.clientCache.allApplied(info.ClientGroupID, mutations) {
+		// every mutation is a re-send, nothing to do
+		rep.counters.skippedMutations.Add(int64(len(mutations)))
+		result := PushResult{
+			ClientGroupID:   info.ClientGroupID,
+			ProfileID:       info.ProfileID,
+			Skipped:         len(mutations),
+			LastMutationIDs: make(map[string]int64),
+		}
+		rep.logPushSuccess(ctx, result)
+		return result, nil
+	}
+
+	batches := [][]Mutation{mutations}
+	if rep.txScope == TransactionPerMutation {
+		batches = make([][]Mutation, 0, len(mutations))
+		for i := range mutations {
+			batches = append(batches, mutations[i:i+1])
+		}
+	}
+
+	result := PushResult{
+		ClientGroupID:   info.ClientGroupID,
+		ProfileID:       info.ProfileID,
+		LastMutationIDs: make(map[string]int64),
+	}
+	// the shadow runs once for the whole push, after every primary
+	// transaction committed or rolled back
+	var shadowed shadowedPush
+	defer rep.shadowPush(ctx, &shadowed)
+	for _, batch := range batches {
+		r, err := rep.pushTxWithRetry(ctx, info, batch, &shadowed)
+		if err != nil {
+			return PushResult{}, err
+		}
+		result.add(r)
+	}
+	if len(result.Rejected) > 0 {
+		return result, &QuotaExceededError{Mutations: result.Rejected}
+	}
+	rep.logPushSuccess(ctx, result)
+	return result, nil
+}
+
+// pushTx applies mutations in a single transaction.
+func (rep *Replicache) pushTx(ctx context.Context, info ClientInfo, mutations []Mutation, shadowed *shadowedPush) (PushResult, error) {
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return PushResult{}, err
 	}
 	defer tx.Rollback()
 
-	// TODO: check all clientIDs for mutations exist in client group
-	// or add them if createOnPush is true
+	if reset, err := rep.checkIdentity(ctx, tx, info); err != nil {
+		return PushResult{}, err
+	} else if reset {
+		return PushResult{}, rep.commitIdentityReset(ctx, tx, info)
+	}
+	result, err := rep.applyPush(ctx, tx, info, mutations, shadowed)
+	if err != nil {
+		rep.beforeRollback(ctx, info, err)
+		return PushResult{}, err
+	}
 
-	if err := rep.handler.HandlePush(ctx, PushRequest{
-		ClientInfo: info,
-		Mutations:  mutations,
-		Tx:         tx,
-	}); err != nil {
-		// TODO: inspect error to see if it's an auth error
-		return err
+	if err := rep.commit(tx); err != nil {
+		return PushResult{}, err
 	}
+	rep.recordApplied(result.LastMutationIDs)
+	rep.logApplied(ctx, info.ClientGroupID, result.applied)
+	rep.clientCache.update(info.ClientGroupID, result.LastMutationIDs)
+	rep.recordSync(ctx, info.ClientGroupID, "last_push_at")
+	rep.fireClientEvents(ctx, info, result.events)
+	return result, nil
+}
 
-	// TODO: update client state
+// applyPush applies mutations within tx.
+// The handler call is recorded in shadowed, which may be nil.
+func (rep *Replicache) applyPush(ctx context.Context, tx *sql.Tx, info ClientInfo, mutations []Mutation, shadowed *shadowedPush) (PushResult, error) {
+	var events clientEvents
+	previousSchemaVersion, err := rep.recordSchemaVersion(ctx, tx, info, &events)
+	if err != nil {
+		return PushResult{}, err
+	}
+	if err := rep.upgradeSchemaVersion(ctx, tx, info); err != nil {
+		return PushResult{}, err
+	}
+	fresh := rep.dropSeenMutations(mutations)
+	lastMutationIDs, err := rep.loadClients(ctx, tx, info, fresh, &events)
+	if err != nil {
+		return PushResult{}, err
+	}
+	previous := make(map[string]int64, len(lastMutationIDs))
+	for clientID, lmid := range lastMutationIDs {
+		previous[clientID] = lmid
+	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+	pending, err := rep.pendingMutations(ctx, info.ClientGroupID, fresh, lastMutationIDs)
+	if err != nil {
+		return PushResult{}, err
 	}
-	return nil
+	pending, err = rep.dropDuplicateArgs(ctx, tx, info.ClientGroupID, pending)
+	if err != nil {
+		return PushResult{}, err
+	}
+	pending, rejected, err := rep.checkQuota(ctx, tx, info, pending)
+	if err != nil {
+		return PushResult{}, err
+	}
+	if len(pending) > 0 {
+		req := PushRequest{
+			ClientInfo:            info,
+			Mutations:             pending,
+			PreviousSchemaVersion: previousSchemaVersion,
+			Tx:                    tx,
+		}
+		err := rep.pushHandler.HandlePush(ctx, req)
+		shadowed.record(req, err)
+		if err != nil {
+			// TODO: inspect error to see if it's an auth error
+			if len(pending) == 1 {
+				err = wrapMutationError(err, pending[0], info.ClientGroupID)
+			}
+			return PushResult{}, err
+		}
+		if err := rep.recordArgs(ctx, tx, info.ClientGroupID, pending); err != nil {
+			return PushResult{}, err
+		}
+	}
+
+	if err := rep.saveLastMutationIDs(ctx, tx, info.ClientGroupID, previous, lastMutationIDs); err != nil {
+		return PushResult{}, err
+	}
+	return PushResult{
+		ClientGroupID:   info.ClientGroupID,
+		Applied:         len(pending),
+		Skipped:         len(mutations) - len(pending) - len(rejected),
+		Rejected:        rejected,
+		LastMutationIDs: lastMutationIDs,
+		events:          events,
+		applied:         pending,
+	}, nil
+}
+
+// beforeRollback runs the pre-rollback hook. A panicking hook is logged and
+// otherwise ignored so it can't prevent the rollback.
+func (rep *Replicache) beforeRollback(ctx context.Context, info ClientInfo, err error) {
+	if rep.preRollback == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rep.logger.Error("replicache pre-rollback hook panicked", slog.Any("panic", p))
+		}
+	}()
+	rep.runHook(ctx, hookPreRollback, info.ClientGroupID, func(ctx context.Context) {
+		rep.preRollback(ctx, info, err)
+	})
 }
 
 func newDefaultInstance(db *sql.DB, handler Handler) *Replicache {
-	return &Replicache{
-		db:      db,
-		handler: handler,
-		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	r := &Replicache{
+		ctx:             context.Background(),
+		db:              db,
+		logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		clientFactory:   defaultClientFactory,
+		dedupeByArgsTTL: defaultDeduplicateByArgsTTL,
+		pushVersions:    []int{1},
+		pushMethods:     []string{http.MethodPost},
+		pullVersions:    []int{1},
+		pushPath:        DefaultPushPath,
+		pullPath:        DefaultPullPath,
+		selfTestMutator: defaultSelfTestMutator,
+		counters:        &counters{},
+		maintenance:     &maintenanceState{},
+		freeze:          &sync.Once{},
+		logLevelMapper:  defaultLogLevel,
+		responseHeaders: true,
+		newEncoder:      defaultEncoder,
+		argRedactor:     defaultArgRedactor,
+		clock:           realClock{},
+
+		maxClientGroupIDLength: defaultMaxIDLength,
+		maxProfileIDLength:     defaultMaxIDLength,
+		maxClientIDLength:      defaultMaxIDLength,
+
+		maxDecompressedBytes: defaultMaxDecompressedBytes,
+	}
+	if handler != nil {
+		r.pushHandler = handler
+		r.pullHandler = handler
 	}
+	return r
+}
+
+// WithContext returns a shallow copy of rep that shares the database, handler,
+// logger and all options but uses ctx as the root context for background
+// operations.
+func (rep *Replicache) WithContext(ctx context.Context) *Replicache {
+	if ctx == nil {
+		panic("replicache: nil context")
+	}
+	r := *rep
+	r.ctx = ctx
+	return &r
 }
 
 type Option func(r *Replicache) error
@@ -92,13 +426,57 @@ func NewReplicache(db *sql.DB, handler Handler, options ...Option) (*Replicache,
 			return nil, err
 		}
 	}
+	if r.pushHandler == nil && r.pullHandler == nil {
+		return nil, errors.New("replicache: a handler, push handler or pull handler is required")
+	}
+	if r.breakers != nil {
+		r.breakers.logger = r.logger
+		r.breakers.clock = r.clock
+		r.breaker = r.breakers.forDB(r.db)
+	}
+	if r.duplicateWindow > 0 {
+		ttl := r.clientPurgeDuration
+		if ttl <= 0 {
+			ttl = defaultDuplicateWindowTTL
+		}
+		r.duplicates = newDuplicateDetector(r.duplicateWindow, ttl, r.clock)
+	}
+	if r.shadow != nil && (r.shadow.handler == nil || r.shadow.db == nil) {
+		return nil, errors.New("replicache: WithShadowHandler and WithShadowDB must be set together")
+	}
+	if r.shadow != nil && r.shadow.db == r.db {
+		return nil, errors.New("replicache: the shadow database must not be the primary database")
+	}
+	if r.autoMigrateEnabled {
+		if err := r.autoMigrate(); err != nil {
+			return nil, err
+		}
+	}
 	return r, nil
 }
 
+type TransactionScope int
+
+const (
+	// TransactionPerPush applies all mutations of a push in one transaction,
+	// so either every mutation is applied or none are.
+	TransactionPerPush TransactionScope = iota
+
+	// TransactionPerMutation applies each mutation, and advances its
+	// client's lastMutationID, in its own transaction. A failed mutation
+	// leaves the mutations before it committed, which breaks the
+	// all-or-nothing semantics of a push. Only use it when mutations are
+	// independent of each other.
+	TransactionPerMutation
+)
+
 type PushHandler interface {
 	HandlePush(ctx context.Context, pr PushRequest) error
 }
 
+// PullHandler returns the pull response for pr. An error discards the
+// response unless it was created with Warning, and a nil response with a nil
+// error means nothing changed since pr's cookie.
 type PullHandler interface {
 	HandlePull(ctx context.Context, pr PullRequest) (any, error)
 }
@@ -106,12 +484,93 @@ type PullHandler interface {
 type PushRequest struct {
 	ClientInfo
 	Mutations []Mutation
-	Tx        *sql.Tx
+
+	// PreviousSchemaVersion is set to the schema version last seen for the
+	// client group when this request changes it. It is only tracked when
+	// WithSupportedSchemaVersions is set.
+	PreviousSchemaVersion string
+	Tx                    *sql.Tx
+}
+
+// PushResult summarizes a successful push.
+type PushResult struct {
+	ClientGroupID string
+
+	// ProfileID is the profile ID after the anonymous policy was applied.
+	ProfileID string
+
+	// Applied is the number of mutations passed to the handler.
+	Applied int
+
+	// Skipped is the number of mutations that had already been applied or
+	// were dropped as duplicates.
+	Skipped int
+
+	// Rejected holds the mutations rejected by the quota checker.
+	Rejected []Mutation
+
+	// LastMutationIDs is the last mutation ID of each client in the push
+	// after it was applied.
+	LastMutationIDs map[string]int64
+
+	events  clientEvents
+	applied []Mutation
+}
+
+func (r *PushResult) add(other PushResult) {
+	r.Applied += other.Applied
+	r.Skipped += other.Skipped
+	r.Rejected = append(r.Rejected, other.Rejected...)
+	for clientID, lmid := range other.LastMutationIDs {
+		r.LastMutationIDs[clientID] = lmid
+	}
+	r.events.add(other.events)
 }
 
 type PullRequest struct {
 	ClientInfo
+	Cookie Cookie
+
+	// RawCookie is the cookie as sent by the client when it is a string
+	// that this package didn't issue, such as a handler's own cookie.
+	// Cookie is NilCookie then.
+	RawCookie json.RawMessage
+
+	// Scopes are the key prefixes requested by the client. They are only
+	// decoded when WithPullScopes is set, and an empty list means the whole
+	// client view.
+	Scopes []string
+
+	// RequestedKeys limits the pull to these keys when the client only
+	// needs part of the client view. It is only set when
+	// WithSparsePullSupported is set, and an empty list means every key.
+	RequestedKeys []string
+
+	// ClientLastMutationIDs holds the last mutation IDs clients claim to
+	// have, if the pull body carried lastMutationIDs. A claim ahead of the
+	// server fails the pull with ClientStateNotFound.
+	ClientLastMutationIDs map[string]int64
+
+	// CVR is the client view record saved by the previous pull. It is only
+	// set when a CVRStore is configured.
+	CVR map[string]string
+
+	// Group is the client group's stored state, read in the pull
+	// transaction. It is only set when WithPullGroupState is set.
+	Group GroupState
+
+	// PreviousSchemaVersion is set to the schema version last seen for the
+	// client group when this request changes it. It is only tracked when
+	// WithSupportedSchemaVersions is set.
+	PreviousSchemaVersion string
+
+	// Tx is nil when WithLazyTransaction is set and the package didn't
+	// need a transaction itself; use LazyTx instead.
 	Tx *sql.Tx
+
+	// LazyTx is set when WithLazyTransaction is set. It shares Tx's
+	// transaction if one was begun.
+	LazyTx *LazyTx
 }
 
 type ClientInfo struct {
@@ -131,6 +590,178 @@ type Mutation struct {
 	Timestamp float64 `json:"timestamp"`
 }
 
+// NullArgs is the Args of a mutation sent without arguments. Mutations
+// decoded from a request with args absent or null both carry it, so they
+// compare and replay byte for byte. Args of {} are kept as sent.
+var NullArgs = json.RawMessage("null")
+
+// UnmarshalJSON decodes a mutation, replacing absent or null args with
+// NullArgs.
+func (m *Mutation) UnmarshalJSON(b []byte) error {
+	type mutation Mutation
+	var v mutation
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v.Args) == 0 || bytes.Equal(v.Args, NullArgs) {
+		v.Args = NullArgs
+	}
+	*m = Mutation(v)
+	return nil
+}
+
+// DecodeArgs unmarshals the mutation arguments into v. Numbers decoded into
+// an interface value become json.Number rather than float64 so that large
+// integer IDs keep their precision. Prefer decoding into a typed struct.
+// Missing or null args leave v unchanged, so a struct keeps its zero value.
+func (m Mutation) DecodeArgs(v any) error {
+	args := m.Args
+	if len(args) == 0 {
+		args = NullArgs
+	}
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// LogValue implements slog.LogValuer so that slog.Any("mutation", m) logs
+// the same attributes everywhere. Args are left out since they often carry
+// personal data; pass them through the arg redactor if they are needed.
+func (m Mutation) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("mutation_id", m.ID),
+		slog.String("mutation_name", m.Name),
+		slog.String("client_id", m.ClientID),
+		slog.Bool("has_args", len(m.Args) > 0 && !bytes.Equal(m.Args, NullArgs)),
+	)
+}
+
+// Equals reports whether m and other are identical, comparing Args byte for
+// byte.
+func (m Mutation) Equals(other Mutation) bool {
+	return m.ClientID == other.ClientID &&
+		m.ID == other.ID &&
+		m.Name == other.Name &&
+		m.Timestamp == other.Timestamp &&
+		bytes.Equal(m.Args, other.Args)
+}
+
+// EqualArgs reports whether m.Args holds the same JSON as v marshaled,
+// ignoring key order and whitespace.
+func (m Mutation) EqualArgs(v any) bool {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	equal, err := jsonEqual(m.Args, b, true)
+	return err == nil && equal
+}
+
+// MutationsEqual reports whether a and b hold equal mutations in the same
+// order.
+func MutationsEqual(a, b []Mutation) bool {
+	return slices.EqualFunc(a, b, Mutation.Equals)
+}
+
+// writeError logs err at the level chosen by the log level mapper and writes
+// the matching response.
+func (rep *Replicache) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	err = rep.schemaError(r.Context(), err)
+	rep.logRequestError(r.Context(), err)
+
+	var circuitErr *CircuitOpenError
+	var retryErr *RetryBudgetError
+	var maintenanceErr *MaintenanceError
+	var regressionErr *LastMutationIDRegressionError
+	var quotaErr *QuotaExceededError
+	var versionErr *VersionNotSupportedError
+	switch {
+	case errors.As(err, &versionErr):
+		rep.respond(w, r, http.StatusOK, versionErr.response(), err)
+	case errors.As(err, &regressionErr):
+		rep.respond(w, r, http.StatusInternalServerError, regressionErr.response(), err)
+	case errors.As(err, &quotaErr):
+		rep.respond(w, r, http.StatusOK, quotaErr.response(), err)
+	case errors.As(err, &circuitErr):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitErr.RetryAfter.Seconds()))))
+		rep.respond(w, r, http.StatusServiceUnavailable, nil, err)
+	case errors.As(err, &maintenanceErr):
+		retryAfter := max(time.Until(maintenanceErr.Until), time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		rep.respond(w, r, http.StatusServiceUnavailable, maintenanceErr.response(), err)
+	case errors.As(err, &retryErr):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryErr.RetryAfter.Seconds()))))
+		rep.respond(w, r, http.StatusServiceUnavailable, nil, err)
+	case errors.Is(err, ErrSchemaMissing), errors.Is(err, ErrSchemaStale), errors.Is(err, ErrHookTimeout):
+		rep.respond(w, r, http.StatusServiceUnavailable, nil, err)
+	case errors.Is(err, ErrUnsupportedEncoding):
+		rep.respond(w, r, http.StatusUnsupportedMediaType, map[string]string{"error": "UnsupportedContentEncoding"}, err)
+	case errors.Is(err, ErrBodyTooLarge):
+		rep.respond(w, r, http.StatusRequestEntityTooLarge, nil, err)
+	case errors.Is(err, ErrIdentityChanged):
+		rep.respond(w, r, http.StatusForbidden, nil, err)
+	case errors.Is(err, ErrTooManyClientGroups):
+		rep.respond(w, r, http.StatusForbidden, map[string]string{"error": "TooManyClientGroups"}, err)
+	case errors.Is(err, ErrAnonymousProfile):
+		rep.respond(w, r, http.StatusUnauthorized, nil, err)
+	case errors.Is(err, ErrIDTooLong), errors.Is(err, ErrSparsePullNotSupported):
+		rep.respond(w, r, http.StatusBadRequest, nil, err)
+	case errors.Is(err, ErrClientNotFound):
+		rep.respond(w, r, http.StatusOK, ClientStateNotFoundResponse{}, err)
+	default:
+		rep.respond(w, r, http.StatusInternalServerError, nil, err)
+	}
+}
+
+// setClientHeaders identifies the client group and profile that were
+// processed on a successful response.
+func (rep *Replicache) setClientHeaders(w http.ResponseWriter, clientGroupID, profileID string) {
+	if !rep.responseHeaders {
+		return
+	}
+	w.Header().Set("X-Replicache-ClientGroupID", clientGroupID)
+	w.Header().Set("X-Replicache-ProfileID", profileID)
+}
+
+// StatusHook observes the status chosen for every push and pull response
+// before it is written, so middleware doesn't need to wrap the
+// ResponseWriter. err is nil on success.
+type StatusHook func(r *http.Request, status int, err error)
+
+// respond is the only place the push and pull endpoints write a status. It
+// reports the status to the status hook and writes body as JSON, or no body
+// if it is nil.
+func (rep *Replicache) respond(w http.ResponseWriter, r *http.Request, status int, body any, err error) {
+	if rep.statusHook != nil {
+		rep.statusHook(r, status, err)
+	}
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	rep.writeJSON(w, status, body)
+}
+
+// ErrMethodNotAllowed is returned, with a 405 response and an Allow header,
+// for push and pull requests with a method other than POST or those set by
+// WithAllowedPushMethods.
+var ErrMethodNotAllowed = errors.New("replicache: method not allowed")
+
+// allowMethod reports whether r's method is one of allowed, responding with
+// a 405 if it isn't.
+func (rep *Replicache) allowMethod(w http.ResponseWriter, r *http.Request, allowed []string) bool {
+	if slices.Contains(allowed, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	rep.respond(w, r, http.StatusMethodNotAllowed, nil, fmt.Errorf("%w: %s", ErrMethodNotAllowed, r.Method))
+	return false
+}
+
+// ErrNoHandler is returned, with a 405 response, by an endpoint whose
+// handler isn't configured.
+var ErrNoHandler = errors.New("replicache: no handler configured for endpoint")
+
 type Handler interface {
 	PushHandler
 	PullHandler
@@ -0,0 +1,238 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransactionPerMutation(t *testing.T) {
+	f, db := newFakeDB(t)
+	errFailed := errors.New("failed")
+	var calls int
+	rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+		calls++
+		if len(pr.Mutations) != 1 {
+			t.Errorf("handler got %d mutations, want 1", len(pr.Mutations))
+		}
+		if pr.Mutations[0].ID == 2 {
+			return errFailed
+		}
+		return nil
+	}}, WithClientOnPush(true), WithTransactionScope(TransactionPerMutation))
+
+	w := post(t, rep.PushHandler(), pushRequest("group",
+		mutation("client", 1, "m"), mutation("client", 2, "m"), mutation("client", 3, "m"),
+	))
+	if w.Code == http.StatusOK {
+		t.Fatalf("push status = %d, want an error", w.Code)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want it to stop after the failure", calls)
+	}
+	c, ok := f.client("group", "client")
+	if !ok || c.lastMutationID != 1 {
+		t.Errorf("last mutation ID = %d (stored %v), want mutation 1 committed", c.lastMutationID, ok)
+	}
+}
+
+func TestPreRollback(t *testing.T) {
+	f, db := newFakeDB(t)
+	errFailed := errors.New("failed")
+	var got error
+	var rolledBack bool
+	rep := newTestReplicache(t, db, testHandler{push: func(context.Context, PushRequest) error {
+		return errFailed
+	}},
+		WithClientOnPush(true),
+		WithPreRollback(func(_ context.Context, info ClientInfo, err error) {
+			got = err
+			_, _, rollbacks := f.txCounts()
+			rolledBack = rollbacks > 0
+			panic("a panicking hook must not prevent the rollback")
+		}),
+	)
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if !errors.Is(got, errFailed) {
+		t.Errorf("hook got %v, want the handler's error", got)
+	}
+	if rolledBack {
+		t.Error("hook ran after the rollback")
+	}
+	if _, _, rollbacks := f.txCounts(); rollbacks != 1 {
+		t.Errorf("rollbacks = %d, want 1", rollbacks)
+	}
+	if _, ok := f.client("group", "client"); ok {
+		t.Error("client of the failed push was committed")
+	}
+}
+
+func TestPushSuccessBody(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithPushSuccessBody(func(result PushResult) any {
+			return map[string]int{"applied": result.Applied, "skipped": result.Skipped}
+		}),
+	)
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m"), mutation("client", 2, "m")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got, want := strings.TrimSpace(w.Body.String()), `{"applied":2,"skipped":0}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	// without the option a successful push has no body
+	rep = newTestReplicache(t, db, testHandler{}, WithClientOnPush(true))
+	w = post(t, rep.PushHandler(), pushRequest("group", mutation("client", 3, "m")))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("default push got status %d and body %q, want an empty 200", w.Code, w.Body)
+	}
+}
+
+func TestDecodeArgsLargeInteger(t *testing.T) {
+	// 2^53 + 1 can't be represented as a float64
+	m := Mutation{Args: json.RawMessage(`{"id":9007199254740993,"nested":[9007199254740993]}`)}
+
+	var v any
+	if err := m.DecodeArgs(&v); err != nil {
+		t.Fatal(err)
+	}
+	args := v.(map[string]any)
+	if id, ok := args["id"].(json.Number); !ok || id.String() != "9007199254740993" {
+		t.Errorf("id = %#v, want json.Number 9007199254740993", args["id"])
+	}
+	if n := args["nested"].([]any)[0]; n != json.Number("9007199254740993") {
+		t.Errorf("nested id = %#v, want json.Number 9007199254740993", n)
+	}
+
+	var typed struct {
+		ID int64 `json:"id"`
+	}
+	if err := m.DecodeArgs(&typed); err != nil || typed.ID != 9007199254740993 {
+		t.Errorf("typed id = %d (%v), want 9007199254740993", typed.ID, err)
+	}
+
+	// null and missing args leave a struct at its zero value
+	typed.ID = 0
+	for _, args := range []json.RawMessage{nil, NullArgs} {
+		if err := (Mutation{Args: args}).DecodeArgs(&typed); err != nil || typed.ID != 0 {
+			t.Errorf("DecodeArgs(%s) = %v, id %d", args, err, typed.ID)
+		}
+	}
+}
+
+func TestStatusHook(t *testing.T) {
+	type observed struct {
+		status int
+		err    error
+	}
+	newRep := func(t *testing.T, h Handler, options ...Option) (*Replicache, *[]observed) {
+		_, db := newFakeDB(t)
+		var seen []observed
+		options = append(options, WithClientOnPush(true), WithStatusHook(func(_ *http.Request, status int, err error) {
+			seen = append(seen, observed{status, err})
+		}))
+		return newTestReplicache(t, db, h, options...), &seen
+	}
+	failing := testHandler{push: func(context.Context, PushRequest) error { return errors.New("failed") }}
+
+	for _, tc := range []struct {
+		name    string
+		handler Handler
+		options []Option
+		request func() *http.Request
+		pull    bool
+		want    int
+		wantErr error
+	}{
+		{name: "push success", want: http.StatusOK},
+		{name: "pull success", pull: true, want: http.StatusOK},
+		{
+			name:    "method",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "/", nil) },
+			want:    http.StatusMethodNotAllowed,
+			wantErr: ErrMethodNotAllowed,
+		},
+		{
+			name: "encoding",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+				r.Header.Set("Content-Encoding", "br")
+				return r
+			},
+			want:    http.StatusUnsupportedMediaType,
+			wantErr: ErrUnsupportedEncoding,
+		},
+		{name: "anonymous", options: []Option{WithAnonymousPolicy(AnonymousReject)}, want: http.StatusUnauthorized, wantErr: ErrAnonymousProfile},
+		{name: "id length", options: []Option{WithMaxClientGroupIDLength(2)}, want: http.StatusBadRequest, wantErr: ErrIDTooLong},
+		{name: "version", options: []Option{WithProtocolVersions([]int{2}, []int{1})}, want: http.StatusOK, wantErr: ErrVersionNotSupported},
+		{name: "handler", handler: failing, want: http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := tc.handler
+			if h == nil {
+				h = testHandler{}
+			}
+			rep, seen := newRep(t, h, tc.options...)
+			var r *http.Request
+			if tc.request != nil {
+				r = tc.request()
+			} else {
+				var body any = testPush{PushVersion: 1, ClientGroupID: "group", Mutations: []Mutation{mutation("client", 1, "m")}}
+				if tc.pull {
+					body = pullRequest("group", nil)
+				}
+				b, _ := json.Marshal(body)
+				r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+			}
+			endpoint := rep.PushHandler()
+			if tc.pull {
+				endpoint = rep.PullHandler()
+			}
+			w := httptest.NewRecorder()
+			endpoint.ServeHTTP(w, r)
+
+			if len(*seen) != 1 {
+				t.Fatalf("hook ran %d times, want once", len(*seen))
+			}
+			got := (*seen)[0]
+			if got.status != tc.want || got.status != w.Code {
+				t.Errorf("hook saw %d, response was %d, want %d", got.status, w.Code, tc.want)
+			}
+			switch {
+			case tc.want == http.StatusOK && tc.wantErr == nil && got.err != nil:
+				t.Errorf("hook saw error %v on success", got.err)
+			case tc.wantErr != nil && !errors.Is(got.err, tc.wantErr):
+				t.Errorf("hook saw error %v, want %v", got.err, tc.wantErr)
+			case tc.want >= 400 && got.err == nil:
+				t.Error("hook saw no error for a failure")
+			}
+		})
+	}
+
+	// maintenance refuses pushes before the body is read
+	rep, seen := newRep(t, testHandler{})
+	if err := rep.SetMaintenance(time.Now().Add(time.Minute), "upgrading"); err != nil {
+		t.Fatal(err)
+	}
+	w := post(t, rep.PushHandler(), pushRequest("group"))
+	if len(*seen) != 1 || (*seen)[0].status != http.StatusServiceUnavailable || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("maintenance: hook saw %v, response was %d, want 503", *seen, w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+// Package replicachetest provides helpers for testing Replicache handlers
+// end to end.
+package replicachetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/BTBurke/go-replicache"
+)
+
+// Client formulates push and pull requests the way the Replicache JS client
+// does.
+type Client struct {
+	PushURL    string
+	PullURL    string
+	HTTPClient *http.Client
+
+	// Token is sent as the Authorization header of every request when set.
+	Token string
+}
+
+// NewClient returns a client for a server that mounts the push and pull
+// handlers at replicache.DefaultPushPath and replicache.DefaultPullPath. Set
+// PushURL and PullURL directly for other paths.
+func NewClient(serverURL string) *Client {
+	return &Client{
+		PushURL:    serverURL + replicache.DefaultPushPath,
+		PullURL:    serverURL + replicache.DefaultPullPath,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func NewClientWithAuth(serverURL, token string) *Client {
+	c := NewClient(serverURL)
+	c.Token = token
+	return c
+}
+
+// Push sends mutations and returns the unread response. The caller must
+// close the response body.
+func (c *Client) Push(ctx context.Context, clientGroupID, profileID, schemaVersion string, mutations []replicache.Mutation) (*http.Response, error) {
+	if mutations == nil {
+		mutations = []replicache.Mutation{}
+	}
+	return c.post(ctx, c.PushURL, struct {
+		PushVersion   int                   `json:"pushVersion"`
+		ClientGroupID string                `json:"clientGroupID"`
+		Mutations     []replicache.Mutation `json:"mutations"`
+		ProfileID     string                `json:"profileID"`
+		SchemaVersion string                `json:"schemaVersion"`
+	}{
+		PushVersion:   1,
+		ClientGroupID: clientGroupID,
+		Mutations:     mutations,
+		ProfileID:     profileID,
+		SchemaVersion: schemaVersion,
+	})
+}
+
+// Pull requests a pull and decodes a successful response. The returned
+// response body has already been read but can be read again, for example
+// to inspect an error response.
+func (c *Client) Pull(ctx context.Context, clientGroupID, profileID, schemaVersion string, cookie replicache.Cookie) (replicache.PullResponse, *http.Response, error) {
+	resp, err := c.post(ctx, c.PullURL, struct {
+		PullVersion   int               `json:"pullVersion"`
+		ClientGroupID string            `json:"clientGroupID"`
+		Cookie        replicache.Cookie `json:"cookie"`
+		ProfileID     string            `json:"profileID"`
+		SchemaVersion string            `json:"schemaVersion"`
+	}{
+		PullVersion:   1,
+		ClientGroupID: clientGroupID,
+		Cookie:        cookie,
+		ProfileID:     profileID,
+		SchemaVersion: schemaVersion,
+	})
+	if err != nil {
+		return replicache.PullResponse{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return replicache.PullResponse{}, resp, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var pr replicache.PullResponse
+	if resp.StatusCode != http.StatusOK || len(body) == 0 {
+		return pr, resp, nil
+	}
+	if err := json.Unmarshal(body, &pr); err != nil {
+		return pr, resp, err
+	}
+	return pr, resp, nil
+}
+
+func (c *Client) post(ctx context.Context, url string, body any) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", c.Token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
@@ -0,0 +1,259 @@
+package replicachetest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/BTBurke/go-replicache"
+)
+
+// memDB is a database/sql driver holding just enough client state in memory
+// for a push and pull round trip. It answers the statements Replicache runs
+// for that by their leading text; every other statement affects nothing.
+type memDB struct {
+	mu       sync.Mutex
+	versions map[string]int64
+	clients  map[[2]string]*memClient
+}
+
+type memClient struct {
+	lastMutationID      int64
+	lastModifiedVersion int64
+}
+
+// memDBs numbers the registered drivers, which can't be unregistered.
+var memDBs atomic.Int64
+
+func openMemDB(t *testing.T) *sql.DB {
+	t.Helper()
+	name := "replicachetest-mem-" + strconv.FormatInt(memDBs.Add(1), 10)
+	sql.Register(name, &memDB{versions: make(map[string]int64), clients: make(map[[2]string]*memClient)})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func (d *memDB) Open(string) (driver.Conn, error) { return &memConn{d}, nil }
+
+type memConn struct{ db *memDB }
+
+func (c *memConn) Prepare(query string) (driver.Stmt, error) { return &memStmt{c.db, query}, nil }
+func (c *memConn) Close() error                              { return nil }
+func (c *memConn) Begin() (driver.Tx, error)                 { return memTx{}, nil }
+
+func (c *memConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return memTx{}, nil }
+
+// memTx applies statements as they run, so a rolled back push isn't undone;
+// the round trip doesn't roll back.
+type memTx struct{}
+
+func (memTx) Commit() error   { return nil }
+func (memTx) Rollback() error { return nil }
+
+type memStmt struct {
+	db    *memDB
+	query string
+}
+
+func (s *memStmt) Close() error  { return nil }
+func (s *memStmt) NumInput() int { return -1 }
+
+func (s *memStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, err := s.Query(args)
+	if err != nil {
+		return nil, err
+	}
+	r := rows.(*memRows)
+	return driver.RowsAffected(len(r.rows) + r.affected), nil
+}
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func (s *memStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	query := strings.TrimSpace(spaces.ReplaceAllString(s.query, " "))
+	has := func(prefix string) bool { return strings.HasPrefix(query, prefix) }
+	str := func(v driver.Value) string { text, _ := v.(string); return text }
+	res := &memRows{}
+
+	switch {
+	case has(`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND client_id IN (`):
+		for _, id := range args[1:] {
+			if c, ok := s.db.clients[[2]string{str(args[0]), str(id)}]; ok {
+				res.rows = append(res.rows, []driver.Value{str(id), c.lastMutationID})
+			}
+		}
+	case has(`SELECT client_id, last_mutation_id FROM replicache_clients WHERE client_group_id = $1 AND last_modified_version > $2`):
+		for key, c := range s.db.clients {
+			if key[0] == str(args[0]) && c.lastModifiedVersion > args[1].(int64) {
+				res.rows = append(res.rows, []driver.Value{key[1], c.lastMutationID})
+			}
+		}
+	case has(`INSERT INTO replicache_version (client_group_id, profile_id`):
+		if _, ok := s.db.versions[str(args[0])]; !ok {
+			s.db.versions[str(args[0])] = 0
+			res.affected = 1
+		}
+	case has(`INSERT INTO replicache_profile_groups`):
+		res.rows = append(res.rows, []driver.Value{int64(1)})
+	case has(`INSERT INTO replicache_clients (client_id, client_group_id, last_mutation_id, metadata, created_at, updated_at)`):
+		for i := 0; i+5 < len(args); i += 6 {
+			key := [2]string{str(args[i+1]), str(args[i])}
+			if _, ok := s.db.clients[key]; !ok {
+				s.db.clients[key] = &memClient{lastMutationID: args[i+2].(int64)}
+				res.rows = append(res.rows, []driver.Value{key[1]})
+			}
+		}
+	case has(`INSERT INTO replicache_version (client_group_id, version) VALUES ($1, 1)`):
+		s.db.versions[str(args[0])]++
+		res.rows = append(res.rows, []driver.Value{s.db.versions[str(args[0])]})
+	case has(`UPDATE replicache_clients SET last_mutation_id = $1, last_modified_version = $2`):
+		if c, ok := s.db.clients[[2]string{str(args[3]), str(args[4])}]; ok && c.lastMutationID <= args[0].(int64) {
+			c.lastMutationID, c.lastModifiedVersion = args[0].(int64), args[1].(int64)
+			res.rows = append(res.rows, []driver.Value{c.lastMutationID})
+		}
+	}
+	return res, nil
+}
+
+type memRows struct {
+	rows [][]driver.Value
+	next int
+
+	// affected counts rows changed by a statement that returns none.
+	affected int
+}
+
+func (r *memRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *memRows) Close() error { return nil }
+
+func (r *memRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// todoHandler keeps todos in memory and serves them all on every pull.
+type todoHandler struct {
+	mu    sync.Mutex
+	auth  string
+	todos map[string]json.RawMessage
+}
+
+func (h *todoHandler) HandlePush(_ context.Context, pr replicache.PushRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.auth = pr.Auth
+	for _, m := range pr.Mutations {
+		var todo struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(m.Args, &todo); err != nil {
+			return err
+		}
+		h.todos[todo.ID] = m.Args
+	}
+	return nil
+}
+
+func (h *todoHandler) HandlePull(_ context.Context, pr replicache.PullRequest) (any, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	patch := []replicache.PatchOperation{{Op: "clear"}}
+	for id, todo := range h.todos {
+		patch = append(patch, replicache.PatchOperation{Op: "put", Key: "todo/" + id, Value: todo})
+	}
+	return replicache.PullResponse{Cookie: pr.Cookie + 1, Patch: patch}, nil
+}
+
+// state returns the Authorization of the last push and the number of todos.
+func (h *todoHandler) state() (auth string, todos int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.auth, len(h.todos)
+}
+
+func (h *todoHandler) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.todos = make(map[string]json.RawMessage)
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	h := &todoHandler{todos: make(map[string]json.RawMessage)}
+	rep, err := replicache.NewReplicache(openMemDB(t), h, replicache.WithClientOnPush(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(replicache.DefaultPushPath, rep.PushHandler())
+	mux.Handle(replicache.DefaultPullPath, rep.PullHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := NewClientWithAuth(srv.URL, "token")
+	resp, err := c.Push(ctx, "group", "profile", "", []replicache.Mutation{
+		{ClientID: "tab", ID: 1, Name: "createTodo", Args: json.RawMessage(`{"id":"1","text":"milk"}`)},
+		{ClientID: "tab", ID: 2, Name: "createTodo", Args: json.RawMessage(`{"id":"2","text":"eggs"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("push status = %d", resp.StatusCode)
+	}
+	if auth, _ := h.state(); auth != "token" {
+		t.Errorf("handler saw Authorization %q, want the client's token", auth)
+	}
+
+	pr, resp, err := c.Pull(ctx, "group", "profile", "", replicache.NilCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("pull status = %d: %s", resp.StatusCode, body)
+	}
+	if pr.Cookie != 1 || pr.LastMutationIDChanges["tab"] != 2 || len(pr.Patch) != 3 {
+		t.Errorf("pull = %+v, want cookie 1, last mutation ID 2 and both todos", pr)
+	}
+
+	// a re-sent push is skipped and doesn't reach the handler
+	h.reset()
+	resp, err = c.Push(ctx, "group", "profile", "", []replicache.Mutation{
+		{ClientID: "tab", ID: 2, Name: "createTodo", Args: json.RawMessage(`{"id":"2","text":"eggs"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, todos := h.state(); resp.StatusCode != http.StatusOK || todos != 0 {
+		t.Errorf("re-sent push status = %d, applied %d todos", resp.StatusCode, todos)
+	}
+}
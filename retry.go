@@ -0,0 +1,90 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrRetryBudgetExceeded is returned, with a 503 response, when a push keeps
+// failing with serialization errors past the maximum retry attempts or time.
+var ErrRetryBudgetExceeded = errors.New("replicache: push retry budget exceeded")
+
+// RetryBudgetError carries how long clients should wait before retrying.
+type RetryBudgetError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RetryBudgetError) Error() string {
+	return ErrRetryBudgetExceeded.Error() + ": " + e.Err.Error()
+}
+
+func (e *RetryBudgetError) Unwrap() []error { return []error{ErrRetryBudgetExceeded, e.Err} }
+
+// Backoff returns how long to wait before retry attempt, starting at 1.
+type Backoff func(attempt int) time.Duration
+
+// FullJitterBackoff waits a random duration between zero and base doubled
+// for every attempt, capped at max. Spreading retries over the whole window
+// keeps instances that conflicted once from conflicting again in lockstep.
+func FullJitterBackoff(base, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		ceiling := max
+		if attempt < 32 {
+			if d := base << (attempt - 1); d > 0 && d < max {
+				ceiling = d
+			}
+		}
+		if ceiling <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(ceiling)))
+	}
+}
+
+type pushRetry struct {
+	maxAttempts int
+	backoff     Backoff
+	maxElapsed  time.Duration
+}
+
+// pushTxWithRetry runs pushTx, retrying serialization failures according to
+// the configured retry policy.
+func (rep *Replicache) pushTxWithRetry(ctx context.Context, info ClientInfo, mutations []Mutation, shadowed *shadowedPush) (PushResult, error) {
+	// only the final attempt is shadowed
+	var last shadowedPush
+	defer func() { shadowed.add(last) }()
+	if rep.pushRetry == nil {
+		return rep.pushTx(ctx, info, mutations, &last)
+	}
+	start := rep.clock.Now()
+	for attempt := 1; ; attempt++ {
+		last = shadowedPush{}
+		result, err := rep.pushTx(ctx, info, mutations, &last)
+		if err == nil || !isSerializationFailure(err) {
+			return result, err
+		}
+
+		wait := rep.pushRetry.backoff(attempt)
+		if attempt >= rep.pushRetry.maxAttempts ||
+			rep.pushRetry.maxElapsed > 0 && rep.clock.Now().Sub(start)+wait > rep.pushRetry.maxElapsed {
+			return PushResult{}, &RetryBudgetError{RetryAfter: wait, Err: err}
+		}
+		if err := rep.clock.Sleep(ctx, wait); err != nil {
+			return PushResult{}, err
+		}
+	}
+}
+
+// isSerializationFailure reports whether err is a serialization failure or
+// deadlock that succeeds when the transaction is retried.
+func isSerializationFailure(err error) bool {
+	var stateErr interface{ SQLState() string }
+	if !errors.As(err, &stateErr) {
+		return false
+	}
+	code := stateErr.SQLState()
+	return code == "40001" || code == "40P01"
+}
@@ -0,0 +1,90 @@
+package replicache
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPushRetry(t *testing.T) {
+	constant := func(int) time.Duration { return time.Second }
+	for _, tc := range []struct {
+		name        string
+		maxAttempts int
+		maxElapsed  time.Duration
+		wantCalls   int
+		wantSleeps  []time.Duration
+		wantStatus  int
+		wantRetry   string
+	}{
+		// three attempts, sleeping between them
+		{"attempt cap", 3, 0, 3, []time.Duration{time.Second, time.Second}, http.StatusServiceUnavailable, "1"},
+		// the third wait would end past 2.5s even though attempts remain
+		{"elapsed cap", 10, 2500 * time.Millisecond, 3, []time.Duration{time.Second, time.Second}, http.StatusServiceUnavailable, "1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, db := newFakeDB(t)
+			clock := newFakeClock()
+			var calls int
+			rep := newTestReplicache(t, db, testHandler{push: func(context.Context, PushRequest) error {
+				calls++
+				return sqlStateError("40001")
+			}},
+				WithClientOnPush(true),
+				WithPushRetry(tc.maxAttempts, constant, tc.maxElapsed),
+				withClock(clock),
+			)
+
+			w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m")))
+			if w.Code != tc.wantStatus {
+				t.Errorf("push status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tc.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, tc.wantRetry)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tc.wantCalls)
+			}
+			if got := clock.Sleeps(); !slices.Equal(got, tc.wantSleeps) {
+				t.Errorf("slept %v, want %v", got, tc.wantSleeps)
+			}
+		})
+	}
+}
+
+func TestPushRetrySucceeds(t *testing.T) {
+	f, db := newFakeDB(t)
+	var calls int
+	rep := newTestReplicache(t, db, testHandler{push: func(context.Context, PushRequest) error {
+		calls++
+		if calls == 1 {
+			return sqlStateError("40P01")
+		}
+		return nil
+	}},
+		WithClientOnPush(true),
+		WithPushRetry(3, FullJitterBackoff(time.Millisecond, time.Second), 0),
+		withClock(newFakeClock()),
+	)
+
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if c, _ := f.client("group", "client"); c.lastMutationID != 1 {
+		t.Errorf("last mutation ID = %d after the retry, want 1", c.lastMutationID)
+	}
+}
+
+func TestFullJitterBackoff(t *testing.T) {
+	backoff := FullJitterBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for attempt := 1; attempt <= 40; attempt++ {
+		ceiling := min(10*time.Millisecond<<min(attempt-1, 30), 50*time.Millisecond)
+		for i := 0; i < 20; i++ {
+			if d := backoff(attempt); d < 0 || d >= ceiling {
+				t.Fatalf("attempt %d waited %v, want within [0, %v)", attempt, d, ceiling)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+var (
+	ErrInvalidMutationName = errors.New("replicache: invalid mutation name")
+	ErrUnknownMutation     = errors.New("replicache: unknown mutation")
+	ErrRouterFrozen        = errors.New("replicache: mutation router is frozen")
+)
+
+// MutationHandlerFunc applies a single mutation within the push transaction.
+type MutationHandlerFunc func(ctx context.Context, tx *sql.Tx, info ClientInfo, m Mutation) error
+
+// MutationRouter is a PushHandler that dispatches each mutation to the
+// handler registered for its name. It is frozen by its first push or by
+// Freeze, after which Register fails with ErrRouterFrozen, so handlers are
+// never added while pushes are being dispatched.
+type MutationRouter struct {
+	handlers  map[string]MutationHandlerFunc
+	normalize func(string) string
+	partial   bool
+	logger    *slog.Logger
+
+	mu     sync.Mutex
+	frozen atomic.Bool
+}
+
+func NewMutationRouter() *MutationRouter {
+	return &MutationRouter{handlers: make(map[string]MutationHandlerFunc), logger: slog.Default()}
+}
+
+// Register adds fn as the handler for mutations named name. Names must be
+// non-empty, contain no whitespace and be registered only once.
+func (mr *MutationRouter) Register(name string, fn MutationHandlerFunc) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if mr.frozen.Load() {
+		return fmt.Errorf("%w: can't register %q", ErrRouterFrozen, name)
+	}
+	if name == "" || strings.ContainsFunc(name, unicode.IsSpace) {
+		return fmt.Errorf("%w: %q", ErrInvalidMutationName, name)
+	}
+	if fn == nil {
+		return fmt.Errorf("%w: %q has a nil handler", ErrInvalidMutationName, name)
+	}
+	if _, ok := mr.handlers[name]; ok {
+		return fmt.Errorf("%w: %q is already registered", ErrInvalidMutationName, name)
+	}
+	mr.handlers[name] = fn
+	return nil
+}
+
+// Freeze stops further registrations. It is called by the first push.
+func (mr *MutationRouter) Freeze() {
+	if mr.frozen.Load() {
+		return
+	}
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.frozen.Store(true)
+}
+
+// NormalizeNames maps every mutation name through fn before looking up its
+// handler, so one registered handler can serve several client naming
+// conventions such as "todo/create" and "todoCreate". Registered names are
+// not normalized, and handlers still see the original mutation name.
+// Normalization only affects dispatch: skipping already applied mutations
+// and ordering are based on mutation IDs, never names. Like Register, it has
+// no effect once the router is frozen.
+func (mr *MutationRouter) NormalizeNames(fn func(string) string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if !mr.frozen.Load() {
+		mr.normalize = fn
+	}
+}
+
+// PartialSuccess runs each mutation in a savepoint when enabled, so a failing
+// mutation only rolls back its own changes. The failure is logged and the
+// mutation still counts as processed, as the Replicache protocol expects
+// for mutations that can never succeed. Unknown mutations, serialization
+// failures, cancellation and database outages still fail the push, so it can
+// be retried. Like Register, it has no effect once the router is frozen.
+func (mr *MutationRouter) PartialSuccess(enabled bool) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if !mr.frozen.Load() {
+		mr.partial = enabled
+	}
+}
+
+func (mr *MutationRouter) HandlePush(ctx context.Context, pr PushRequest) error {
+	mr.Freeze()
+	for _, m := range pr.Mutations {
+		name := m.Name
+		if mr.normalize != nil {
+			name = mr.normalize(name)
+		}
+		fn, ok := mr.handlers[name]
+		if !ok {
+			return wrapMutationError(fmt.Errorf("%w: %q", ErrUnknownMutation, m.Name), m, pr.ClientGroupID)
+		}
+		if !mr.partial {
+			if err := fn(ctx, pr.Tx, pr.ClientInfo, m); err != nil {
+				return wrapMutationError(err, m, pr.ClientGroupID)
+			}
+			continue
+		}
+		if err := mr.handlePartial(ctx, pr, fn, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlePartial runs fn in a savepoint and rolls back to it if fn fails.
+// Only errors of the mutation itself are swallowed: serialization failures,
+// cancellation and database outages are returned unchanged so the push can
+// be retried.
+func (mr *MutationRouter) handlePartial(ctx context.Context, pr PushRequest, fn MutationHandlerFunc, m Mutation) error {
+	if err := savepoint(ctx, pr.Tx, "replicache_mutation"); err != nil {
+		return err
+	}
+	err := fn(ctx, pr.Tx, pr.ClientInfo, m)
+	switch {
+	case err == nil:
+		return releaseSavepoint(ctx, pr.Tx, "replicache_mutation")
+	case isSerializationFailure(err), ctx.Err() != nil, isInfrastructureError(err):
+		return err
+	}
+	mr.logger.WarnContext(ctx, "replicache mutation failed, rolled back to savepoint",
+		slog.Any("mutation", m),
+		slog.String("client_group_id", pr.ClientGroupID),
+		slog.Any("err", err),
+	)
+	return rollbackToSavepoint(ctx, pr.Tx, "replicache_mutation")
+}
+
+// Handle creates a MutationRouter with every entry of handlers registered,
+// using the normalizer set by WithMutationNameNormalizer, the partial
+// success mode set by WithPartialPushSuccess and rep's logger.
+func (rep *Replicache) Handle(handlers map[string]MutationHandlerFunc) (*MutationRouter, error) {
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mr := NewMutationRouter()
+	mr.NormalizeNames(rep.nameNormalizer)
+	mr.PartialSuccess(rep.partialPushSuccess)
+	mr.logger = rep.logger
+	for _, name := range names {
+		if err := mr.Register(name, handlers[name]); err != nil {
+			return nil, err
+		}
+	}
+	return mr, nil
+}
+
+// MustHandle is like Handle but panics if a handler can't be registered.
+func (rep *Replicache) MustHandle(handlers map[string]MutationHandlerFunc) *MutationRouter {
+	mr, err := rep.Handle(handlers)
+	if err != nil {
+		panic(err)
+	}
+	return mr
+}
@@ -0,0 +1,155 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// camelCase turns namespaced mutation names like todo/create into todoCreate.
+func camelCase(name string) string {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func TestMutationNameNormalizer(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{}, WithClientOnPush(true), WithMutationNameNormalizer(camelCase))
+
+	var names []string
+	router := rep.MustHandle(map[string]MutationHandlerFunc{
+		"todoCreate": func(_ context.Context, _ *sql.Tx, _ ClientInfo, m Mutation) error {
+			names = append(names, m.Name)
+			return nil
+		},
+	})
+	rep.pushHandler = router
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "todo/create"), mutation("client", 2, "todoCreate")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("push status = %d, want %d", w.Code, http.StatusOK)
+	}
+	// handlers see the name the client sent
+	if len(names) != 2 || names[0] != "todo/create" || names[1] != "todoCreate" {
+		t.Errorf("handler saw %q, want both conventions", names)
+	}
+
+	w = post(t, rep.PushHandler(), pushRequest("group", mutation("client", 3, "todo/delete")))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unknown mutation status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestMutationRouterFrozen(t *testing.T) {
+	mr := NewMutationRouter()
+	noop := func(context.Context, *sql.Tx, ClientInfo, Mutation) error { return nil }
+	if err := mr.Register("a", noop); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "", "has space"} {
+		if err := mr.Register(name, noop); !errors.Is(err, ErrInvalidMutationName) {
+			t.Errorf("Register(%q) = %v, want ErrInvalidMutationName", name, err)
+		}
+	}
+	mr.Freeze()
+	if err := mr.Register("b", noop); !errors.Is(err, ErrRouterFrozen) {
+		t.Errorf("Register after Freeze = %v, want ErrRouterFrozen", err)
+	}
+}
+
+func TestPartialSuccessRetriesTransientErrors(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithPartialPushSuccess(true),
+		WithPushRetry(3, func(int) time.Duration { return time.Millisecond }, 0),
+		withClock(newFakeClock()),
+	)
+	var calls int
+	var failWith error
+	rep.pushHandler = rep.MustHandle(map[string]MutationHandlerFunc{
+		"fail": func(context.Context, *sql.Tx, ClientInfo, Mutation) error {
+			return errors.New("invalid todo")
+		},
+		"flaky": func(context.Context, *sql.Tx, ClientInfo, Mutation) error {
+			calls++
+			if calls == 1 {
+				return failWith
+			}
+			return nil
+		},
+	})
+
+	// a business error is skipped
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "fail"))); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	if c, _ := f.client("group", "client"); c.lastMutationID != 1 {
+		t.Errorf("last mutation ID = %d after a failed mutation, want 1", c.lastMutationID)
+	}
+
+	// a serialization failure retries the push
+	calls, failWith = 0, sqlStateError("40001")
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 2, "flaky"))); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times after a serialization failure, want 2", calls)
+	}
+
+	// and a database outage fails it
+	calls, failWith = 0, sqlStateError("08006")
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 3, "flaky"))); w.Code == http.StatusOK {
+		t.Errorf("push with a database outage succeeded")
+	}
+	if c, _ := f.client("group", "client"); c.lastMutationID != 2 {
+		t.Errorf("last mutation ID = %d after a database outage, want 2", c.lastMutationID)
+	}
+}
+
+func TestMutationArgsShapes(t *testing.T) {
+	body := `{"pushVersion":1,"clientGroupID":"group","profileID":"profile","mutations":[` +
+		`{"clientID":"client","id":1,"name":"todoCreate"},` +
+		`{"clientID":"client","id":2,"name":"todoCreate","args":null},` +
+		`{"clientID":"client","id":3,"name":"todoCreate","args":{}}]}`
+	for _, streaming := range []bool{false, true} {
+		_, db := newFakeDB(t)
+		options := []Option{WithClientOnPush(true)}
+		if streaming {
+			options = append(options, WithStreamingPush())
+		}
+		rep := newTestReplicache(t, db, testHandler{}, options...)
+		var args []string
+		rep.pushHandler = rep.MustHandle(map[string]MutationHandlerFunc{
+			"todoCreate": func(_ context.Context, _ *sql.Tx, _ ClientInfo, m Mutation) error {
+				v := struct{ Title string }{"unchanged"}
+				if err := m.DecodeArgs(&v); err != nil {
+					return err
+				}
+				if v.Title != "unchanged" {
+					t.Errorf("mutation %d decoded to %+v", m.ID, v)
+				}
+				args = append(args, string(m.Args))
+				return nil
+			},
+		})
+
+		if w := post(t, rep.PushHandler(), body); w.Code != http.StatusOK {
+			t.Fatalf("streaming %v: push status = %d: %s", streaming, w.Code, w.Body)
+		}
+		// absent and null args compare equal, {} is kept as sent
+		if !slices.Equal(args, []string{"null", "null", "{}"}) {
+			t.Errorf("streaming %v: handler saw args %q", streaming, args)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// migrations holds the statements for each schema version. Existing entries
+// must never change; append a new version instead.
+var migrations = [][]string{
+	// version 1
+	{
+		`CREATE TABLE IF NOT EXISTS replicache_clients (
+			client_id TEXT PRIMARY KEY,
+			client_group_id TEXT NOT NULL,
+			last_mutation_id BIGINT NOT NULL DEFAULT 0,
+			metadata JSONB,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX IF NOT EXISTS replicache_clients_group_idx ON replicache_clients (client_group_id)`,
+		`CREATE TABLE IF NOT EXISTS replicache_mutation_args_cache (
+			client_id TEXT NOT NULL,
+			args_hash TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (client_id, args_hash)
+		)`,
+		`CREATE TABLE IF NOT EXISTS replicache_quota_usage (
+			profile_id TEXT PRIMARY KEY,
+			bytes BIGINT NOT NULL DEFAULT 0
+		)`,
+	},
+	// version 2: key clients by client group and client
+	{
+		`ALTER TABLE replicache_clients DROP CONSTRAINT replicache_clients_pkey`,
+		`ALTER TABLE replicache_clients ADD PRIMARY KEY (client_group_id, client_id)`,
+		`DROP INDEX IF EXISTS replicache_clients_group_idx`,
+		`CREATE INDEX replicache_clients_client_idx ON replicache_clients (client_id)`,
+	},
+	// version 3
+	{
+		`CREATE TABLE replicache_cvr (
+			client_group_id TEXT PRIMARY KEY,
+			cvr JSONB NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+	},
+	// version 4
+	{
+		`CREATE TABLE replicache_version (
+			client_group_id TEXT PRIMARY KEY,
+			version BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+	},
+	// version 5
+	{
+		`ALTER TABLE replicache_version ADD COLUMN schema_version TEXT`,
+	},
+	// version 6: bound identifier widths, see WithMaxClientIDLength
+	{
+		`ALTER TABLE replicache_clients ALTER COLUMN client_id TYPE VARCHAR(256), ALTER COLUMN client_group_id TYPE VARCHAR(256)`,
+		`ALTER TABLE replicache_mutation_args_cache ALTER COLUMN client_id TYPE VARCHAR(256)`,
+		`ALTER TABLE replicache_quota_usage ALTER COLUMN profile_id TYPE VARCHAR(256)`,
+		`ALTER TABLE replicache_cvr ALTER COLUMN client_group_id TYPE VARCHAR(256)`,
+		`ALTER TABLE replicache_version ALTER COLUMN client_group_id TYPE VARCHAR(256)`,
+	},
+	// version 7
+	{
+		`CREATE TABLE replicache_maintenance (
+			id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+			until TIMESTAMPTZ NOT NULL,
+			message TEXT NOT NULL
+		)`,
+	},
+	// version 8
+	{
+		`ALTER TABLE replicache_version ADD COLUMN last_push_at TIMESTAMPTZ, ADD COLUMN last_pull_at TIMESTAMPTZ`,
+	},
+	// version 9: client groups per profile, see WithMaxClientGroupsPerProfile
+	{
+		`ALTER TABLE replicache_version ADD COLUMN profile_id VARCHAR(256)`,
+		`CREATE INDEX replicache_version_profile_idx ON replicache_version (profile_id)`,
+		`CREATE TABLE replicache_profile_groups (
+			profile_id VARCHAR(256) PRIMARY KEY,
+			groups BIGINT NOT NULL DEFAULT 0
+		)`,
+	},
+	// version 10
+	{
+		`ALTER TABLE replicache_clients ADD COLUMN last_modified_version BIGINT NOT NULL DEFAULT 0`,
+	},
+	// version 11: client group owners, see WithIdentityChangePolicy
+	{
+		`ALTER TABLE replicache_version ADD COLUMN owner TEXT`,
+	},
+	// version 12: schema versions seen per profile, see WithSchemaVersionUpgradeHandler
+	{
+		`CREATE TABLE replicache_schema_migrations (
+			profile_id VARCHAR(256) NOT NULL,
+			schema_version TEXT NOT NULL,
+			migrated_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (profile_id, schema_version)
+		)`,
+	},
+	// version 13: session issue time of client group owners, see Identity
+	{
+		`ALTER TABLE replicache_version ADD COLUMN owner_issued_at TIMESTAMPTZ`,
+	},
+	// version 14: oldest cookie still served incrementally, see ForceFullPull
+	{
+		`ALTER TABLE replicache_version ADD COLUMN reset_version BIGINT NOT NULL DEFAULT 0`,
+	},
+	// version 15: key the args cache by client group and client, like
+	// replicache_clients; its rows only live for the deduplication TTL
+	{
+		`TRUNCATE replicache_mutation_args_cache`,
+		`ALTER TABLE replicache_mutation_args_cache ADD COLUMN client_group_id VARCHAR(256) NOT NULL`,
+		`ALTER TABLE replicache_mutation_args_cache DROP CONSTRAINT replicache_mutation_args_cache_pkey`,
+		`ALTER TABLE replicache_mutation_args_cache ADD PRIMARY KEY (client_group_id, client_id, args_hash)`,
+	},
+}
+
+// CreateSchema creates or upgrades the tables used to track client state.
+// The applied schema version is recorded in replicache_schema_version so
+// only new migrations run, and it is safe to call on every startup.
+func CreateSchema(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS replicache_schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+	// serialize concurrent migrations from several instances
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE replicache_schema_version IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM replicache_schema_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= len(migrations) {
+		return nil
+	}
+
+	for _, stmts := range migrations[version:] {
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM replicache_schema_version`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO replicache_schema_version (version) VALUES ($1)`, len(migrations)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// autoMigrated records the databases WithAutoMigrate has migrated in this
+// process, so instances sharing a *sql.DB migrate it once. Failed attempts
+// aren't recorded and are retried by the next instance.
+var autoMigrated = struct {
+	mu  sync.Mutex
+	dbs map[*sql.DB]bool
+}{dbs: make(map[*sql.DB]bool)}
+
+// autoMigrate runs CreateSchema against rep's database and every database of
+// its DBRouter that hasn't been migrated in this process yet, logging a
+// warning for those that were skipped.
+func (rep *Replicache) autoMigrate() error {
+	autoMigrated.mu.Lock()
+	defer autoMigrated.mu.Unlock()
+	for _, db := range rep.databases() {
+		if autoMigrated.dbs[db] {
+			rep.logger.Warn("replicache auto migration skipped, migrations already ran in this process")
+			continue
+		}
+		if err := CreateSchema(rep.ctx, db); err != nil {
+			return err
+		}
+		autoMigrated.dbs[db] = true
+	}
+	return nil
+}
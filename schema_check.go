@@ -0,0 +1,87 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// schemaErrorLogInterval limits how often requests failing on a missing or
+// stale schema are logged.
+const schemaErrorLogInterval = time.Minute
+
+var (
+	// ErrSchemaMissing is returned, with a 503 response, when the tables
+	// created by CreateSchema don't exist.
+	ErrSchemaMissing = errors.New("replicache: database schema missing, run CreateSchema")
+
+	// ErrSchemaStale is returned, with a 503 response, when the database
+	// schema is older than this version of the package expects.
+	ErrSchemaStale = errors.New("replicache: database schema out of date, run CreateSchema")
+)
+
+// CheckSchema returns ErrSchemaMissing or ErrSchemaStale unless CreateSchema
+// has been run with this version of the package. Use it in readiness checks
+// so a deployment that skipped migrations is caught before it takes traffic.
+func (rep *Replicache) CheckSchema(ctx context.Context) error {
+	return rep.checkSchemaVersion(ctx)
+}
+
+func (rep *Replicache) checkSchemaVersion(ctx context.Context) error {
+	var version int
+	err := rep.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM replicache_schema_version`).Scan(&version)
+	switch {
+	case sqlState(err) == "42P01":
+		return fmt.Errorf("%w: %w", ErrSchemaMissing, err)
+	case err != nil:
+		return err
+	case version < len(migrations):
+		return fmt.Errorf("%w: version %d is older than %d", ErrSchemaStale, version, len(migrations))
+	}
+	return nil
+}
+
+// schemaError turns an undefined table or column error into ErrSchemaMissing
+// or ErrSchemaStale when the schema is in fact behind, keeping the original
+// error, which names the missing object. Errors from a handler's own tables
+// are left alone since the schema checks out.
+func (rep *Replicache) schemaError(ctx context.Context, err error) error {
+	switch sqlState(err) {
+	case "42P01", "42703":
+	default:
+		return err
+	}
+	if errors.Is(err, ErrSchemaMissing) || errors.Is(err, ErrSchemaStale) {
+		return err
+	}
+	schemaErr := rep.checkSchemaVersion(context.WithoutCancel(ctx))
+	switch {
+	case errors.Is(schemaErr, ErrSchemaMissing):
+		return fmt.Errorf("%w: %w", ErrSchemaMissing, err)
+	case errors.Is(schemaErr, ErrSchemaStale):
+		return fmt.Errorf("%w: %w", schemaErr, err)
+	default:
+		return err
+	}
+}
+
+// logSchemaError reports whether a schema error should be logged, allowing
+// one per schemaErrorLogInterval.
+func (rep *Replicache) logSchemaError() bool {
+	now := time.Now().UnixNano()
+	last := rep.counters.schemaErrorLogged.Load()
+	if now-last < int64(schemaErrorLogInterval) {
+		return false
+	}
+	return rep.counters.schemaErrorLogged.CompareAndSwap(last, now)
+}
+
+// sqlState returns the SQLSTATE code of a database error, or an empty string.
+func sqlState(err error) string {
+	var stateErr interface{ SQLState() string }
+	if !errors.As(err, &stateErr) {
+		return ""
+	}
+	return stateErr.SQLState()
+}
@@ -0,0 +1,65 @@
+package replicache
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+// newMigratableDB returns a fake database answering the schema version
+// query of CreateSchema.
+func newMigratableDB(t *testing.T) (*fakeDB, *sql.DB) {
+	f, db := newFakeDB(t)
+	f.on(`SELECT COALESCE(MAX(version), 0) FROM replicache_schema_version`, func(*fakeState, []driver.Value) (fakeResult, error) {
+		return row(int64(0)), nil
+	})
+	return f, db
+}
+
+func TestAutoMigrateOncePerDB(t *testing.T) {
+	f1, db1 := newMigratableDB(t)
+	f2, db2 := newMigratableDB(t)
+
+	newTestReplicache(t, db1, testHandler{}, WithAutoMigrate(true))
+	newTestReplicache(t, db1, testHandler{}, WithAutoMigrate(true))
+	newTestReplicache(t, db2, testHandler{}, WithAutoMigrate(true))
+
+	if n := f1.count(`LOCK TABLE replicache_schema_version`); n != 1 {
+		t.Errorf("first database migrated %d times, want 1", n)
+	}
+	if n := f2.count(`LOCK TABLE replicache_schema_version`); n != 1 {
+		t.Errorf("second database migrated %d times, want 1", n)
+	}
+}
+
+func TestAutoMigrateRetriesFailure(t *testing.T) {
+	f, db := newMigratableDB(t)
+	errDown := errors.New("database down")
+	f.fail(`LOCK TABLE replicache_schema_version`, errDown)
+	if _, err := NewReplicache(db, testHandler{}, WithAutoMigrate(true)); !errors.Is(err, errDown) {
+		t.Fatalf("NewReplicache = %v, want %v", err, errDown)
+	}
+
+	f.on(`LOCK TABLE replicache_schema_version`, func(*fakeState, []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	newTestReplicache(t, db, testHandler{}, WithAutoMigrate(true))
+	if n := f.count(`INSERT INTO replicache_schema_version`); n != 1 {
+		t.Errorf("failed migration was retried %d times, want 1", n)
+	}
+}
+
+func TestAutoMigrateRouterDatabases(t *testing.T) {
+	f, db := newMigratableDB(t)
+	fa, dba := newMigratableDB(t)
+	fd, dbd := newMigratableDB(t)
+	router := NewDBRouter().Register("a", dba).Register("b", dba).RegisterDefault(dbd)
+
+	newTestReplicache(t, db, testHandler{}, WithAutoMigrate(true), WithDBRouter(router))
+	for name, f := range map[string]*fakeDB{"main": f, "routed": fa, "default": fd} {
+		if n := f.count(`LOCK TABLE replicache_schema_version`); n != 1 {
+			t.Errorf("%s database migrated %d times, want 1", name, n)
+		}
+	}
+}
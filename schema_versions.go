@@ -0,0 +1,102 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// checkClientSchemaVersion rejects schema versions missing from the allow
+// list. An empty allow list accepts every schema version.
+func (rep *Replicache) checkClientSchemaVersion(schemaVersion string) error {
+	if len(rep.schemaVersions) == 0 || slices.Contains(rep.schemaVersions, schemaVersion) {
+		return nil
+	}
+	return &VersionNotSupportedError{VersionType: string(VersionTypeSchema), SchemaVersion: schemaVersion}
+}
+
+// recordSchemaVersion stores the schema version of the request on its client
+// group and returns the previous schema version if it changed. It does
+// nothing unless an allow list of schema versions is configured.
+func (rep *Replicache) recordSchemaVersion(ctx context.Context, tx *sql.Tx, info ClientInfo, events *clientEvents) (string, error) {
+	if len(rep.schemaVersions) == 0 {
+		return "", nil
+	}
+
+	created, err := rep.ensureClientGroup(ctx, tx, info)
+	if err != nil {
+		return "", err
+	}
+	events.groupCreated = events.groupCreated || created
+
+	var previous sql.NullString
+	if err := tx.QueryRowContext(ctx,
+		`SELECT schema_version FROM replicache_version WHERE client_group_id = $1 FOR UPDATE`,
+		info.ClientGroupID,
+	).Scan(&previous); err != nil {
+		return "", err
+	}
+	if previous.Valid && previous.String == info.SchemaVersion {
+		return "", nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE replicache_version SET schema_version = $1 WHERE client_group_id = $2`,
+		info.SchemaVersion, info.ClientGroupID,
+	); err != nil {
+		return "", err
+	}
+	return previous.String, nil
+}
+
+// SchemaUpgradeFunc migrates data for a profile whose clients moved from
+// oldVersion to newVersion. See WithSchemaVersionUpgradeHandler.
+type SchemaUpgradeFunc func(ctx context.Context, tx *sql.Tx, oldVersion, newVersion string) error
+
+// upgradeSchemaVersion runs the schema upgrade handler if the schema version
+// of the request is new for its profile. The version is claimed with an
+// insert first, so concurrent pushes with the same new version wait on each
+// other and the handler runs once.
+func (rep *Replicache) upgradeSchemaVersion(ctx context.Context, tx *sql.Tx, info ClientInfo) error {
+	if rep.schemaUpgrade == nil || info.SchemaVersion == "" {
+		return nil
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO replicache_schema_migrations (profile_id, schema_version, migrated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (profile_id, schema_version) DO NOTHING`,
+		info.ProfileID, info.SchemaVersion, rep.clock.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	var previous string
+	err = tx.QueryRowContext(ctx,
+		`SELECT schema_version FROM replicache_schema_migrations
+		WHERE profile_id = $1 AND schema_version <> $2
+		ORDER BY migrated_at DESC LIMIT 1`,
+		info.ProfileID, info.SchemaVersion,
+	).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	rep.logger.InfoContext(ctx, "replicache upgrading schema version",
+		slog.String("profile_id", info.ProfileID),
+		slog.String("from_schema_version", previous),
+		slog.String("to_schema_version", info.SchemaVersion),
+	)
+	if err := rep.runTxHook(ctx, hookSchemaUpgrade, info.ClientGroupID, func(ctx context.Context) error {
+		return rep.schemaUpgrade(ctx, tx, previous, info.SchemaVersion)
+	}); err != nil {
+		return fmt.Errorf("replicache: schema upgrade from %q to %q: %w", previous, info.SchemaVersion, err)
+	}
+	return nil
+}
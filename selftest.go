@@ -0,0 +1,154 @@
+package replicache
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const defaultSelfTestMutator = "replicacheSelfTest"
+
+type SelfTestStage string
+
+const (
+	SelfTestSchema    SelfTestStage = "schema"
+	SelfTestSetup     SelfTestStage = "setup"
+	SelfTestPush      SelfTestStage = "push"
+	SelfTestPull      SelfTestStage = "pull"
+	SelfTestPullShape SelfTestStage = "pull shape"
+	SelfTestCleanup   SelfTestStage = "cleanup"
+)
+
+// SelfTestError identifies the stage of SelfTest that failed.
+type SelfTestError struct {
+	Stage SelfTestStage
+	Err   error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("replicache: self test failed at %s: %v", e.Stage, e.Err)
+}
+
+func (e *SelfTestError) Unwrap() error { return e.Err }
+
+// SelfTest runs a round trip through the push and pull pipeline using a
+// throwaway client group: it pushes a single mutation named by
+// WithSelfTestMutator, which the handler must treat as a no-op, pulls with a
+// nil cookie and checks that the response acknowledges the mutation and
+// carries a cookie. The client group is deleted afterwards. With a DBRouter
+// every routed database is tested. Errors are returned as a *SelfTestError.
+func (rep *Replicache) SelfTest(ctx context.Context) error {
+	for _, db := range rep.databases() {
+		r := rep.withDB(db)
+		r.dbRouter = nil
+		if err := r.selfTest(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rep *Replicache) selfTest(ctx context.Context) (err error) {
+	if err := rep.checkSchemaVersion(ctx); err != nil {
+		return &SelfTestError{Stage: SelfTestSchema, Err: err}
+	}
+
+	suffix, err := randomID()
+	if err != nil {
+		return &SelfTestError{Stage: SelfTestSetup, Err: err}
+	}
+	info := ClientInfo{
+		ClientGroupID: "selftest-group-" + suffix,
+		ProfileID:     "selftest-profile-" + suffix,
+	}
+	clientID := "selftest-client-" + suffix
+
+	defer func() {
+		if cleanupErr := rep.deleteSelfTestGroup(ctx, info.ClientGroupID); cleanupErr != nil && err == nil {
+			err = &SelfTestError{Stage: SelfTestCleanup, Err: cleanupErr}
+		}
+	}()
+
+	if err := rep.createSelfTestClient(ctx, info, clientID); err != nil {
+		return &SelfTestError{Stage: SelfTestSetup, Err: err}
+	}
+
+	if _, err := rep.handlePush(ctx, info, []Mutation{{
+		ClientID: clientID,
+		ID:       1,
+		Name:     rep.selfTestMutator,
+		Args:     json.RawMessage(`{}`),
+	}}); err != nil {
+		return &SelfTestError{Stage: SelfTestPush, Err: err}
+	}
+
+	resp, err := rep.handlePull(ctx, &PullRequest{ClientInfo: info, Cookie: NilCookie})
+	if err != nil {
+		return &SelfTestError{Stage: SelfTestPull, Err: err}
+	}
+	if err := checkSelfTestPull(resp, clientID); err != nil {
+		return &SelfTestError{Stage: SelfTestPullShape, Err: err}
+	}
+	return nil
+}
+
+func (rep *Replicache) createSelfTestClient(ctx context.Context, info ClientInfo, clientID string) error {
+	tx, err := rep.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := rep.createClient(ctx, tx, info, clientID); err != nil {
+		return err
+	}
+	return rep.commit(tx)
+}
+
+func checkSelfTestPull(resp any, clientID string) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	var pr struct {
+		Cookie                json.RawMessage  `json:"cookie"`
+		LastMutationIDChanges map[string]int64 `json:"lastMutationIDChanges"`
+		Patch                 []PatchOperation `json:"patch"`
+	}
+	if err := json.Unmarshal(b, &pr); err != nil {
+		return fmt.Errorf("response is not a pull response: %w", err)
+	}
+	if len(pr.Cookie) == 0 || string(pr.Cookie) == "null" {
+		return errors.New("cookie did not advance after push")
+	}
+	if lmid := pr.LastMutationIDChanges[clientID]; lmid != 1 {
+		return fmt.Errorf("lastMutationIDChanges for the test client is %d, expected 1", lmid)
+	}
+	return nil
+}
+
+func (rep *Replicache) deleteSelfTestGroup(ctx context.Context, clientGroupID string) error {
+	tx, err := rep.beginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteClientGroup(ctx, tx, clientGroupID); err != nil {
+		return err
+	}
+	rep.clientCache.invalidate(clientGroupID)
+	return rep.commit(tx)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
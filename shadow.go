@@ -0,0 +1,162 @@
+package replicache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+)
+
+// defaultShadowQueueSize bounds the shadow requests in flight. Requests
+// sampled while it is full are dropped rather than queued.
+const defaultShadowQueueSize = 64
+
+// shadow runs a sample of requests through a second handler to compare its
+// outcome with the primary handler's. It is shared by every copy of a
+// Replicache returned by WithContext.
+type shadow struct {
+	handler    Handler
+	sampleRate float64
+	db         *sql.DB
+	slots      chan struct{}
+}
+
+func (r *Replicache) shadowConfig() *shadow {
+	if r.shadow == nil {
+		r.shadow = &shadow{slots: make(chan struct{}, defaultShadowQueueSize)}
+	}
+	return r.shadow
+}
+
+// shadowedPush collects the calls of the primary push handler that belong to
+// a push, so the shadow runs once per push rather than once per transaction
+// or retry.
+type shadowedPush struct {
+	req PushRequest
+	err error
+	ok  bool
+}
+
+// record keeps a call of the primary handler. s may be nil.
+func (s *shadowedPush) record(req PushRequest, err error) {
+	if s == nil {
+		return
+	}
+	req.Tx = nil
+	*s = shadowedPush{req: req, err: err, ok: true}
+}
+
+// add appends the call kept by other, keeping the first error.
+func (s *shadowedPush) add(other shadowedPush) {
+	if !other.ok {
+		return
+	}
+	if !s.ok {
+		*s = other
+		return
+	}
+	s.req.Mutations = append(s.req.Mutations, other.req.Mutations...)
+	if s.err == nil {
+		s.err = other.err
+	}
+}
+
+// shadowPush runs the mutations the primary handler was given through the
+// shadow handler in the background. It must only be called once the
+// primary transactions committed or rolled back.
+func (rep *Replicache) shadowPush(ctx context.Context, s *shadowedPush) {
+	if !s.ok {
+		return
+	}
+	pr, primaryErr := s.req, s.err
+	rep.runShadow(ctx, pr.ClientGroupID, "push", func(ctx context.Context, tx *sql.Tx) (bool, error) {
+		pr.Tx = tx
+		err := rep.shadow.handler.HandlePush(ctx, pr)
+		return (err == nil) == (primaryErr == nil), err
+	})
+}
+
+// shadowPull runs the pull through the shadow handler in the background and
+// compares its patch with the one of the primary response. It must only be
+// called once the pull transaction committed or rolled back.
+func (rep *Replicache) shadowPull(ctx context.Context, pr PullRequest, primary any, primaryErr error) {
+	pr.LazyTx = nil
+	rep.runShadow(ctx, pr.ClientGroupID, "pull", func(ctx context.Context, tx *sql.Tx) (bool, error) {
+		pr.Tx = tx
+		resp, err := rep.shadow.handler.HandlePull(ctx, pr)
+		if err != nil || primaryErr != nil {
+			return (err == nil) == (primaryErr == nil), err
+		}
+		return bytes.Equal(patchHash(resp), patchHash(primary)), nil
+	})
+}
+
+// runShadow runs fn in its own transaction on the shadow database on a
+// background goroutine, if the request is sampled and there is room in the
+// queue. The transaction is committed unless fn fails. Divergences are
+// logged and counted; nothing here reaches the primary response.
+func (rep *Replicache) runShadow(ctx context.Context, clientGroupID, kind string, fn func(ctx context.Context, tx *sql.Tx) (bool, error)) {
+	if rep.shadow == nil || rand.Float64() >= rep.shadow.sampleRate {
+		return
+	}
+	select {
+	case rep.shadow.slots <- struct{}{}:
+	default:
+		rep.counters.shadowDropped.Add(1)
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-rep.shadow.slots }()
+		defer func() {
+			if p := recover(); p != nil {
+				rep.logger.ErrorContext(ctx, "replicache shadow handler panicked", slog.Any("panic", p))
+			}
+		}()
+
+		tx, err := rep.shadow.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+		if err != nil {
+			rep.logger.WarnContext(ctx, "replicache shadow request failed to start", slog.String("kind", kind), slog.Any("err", err))
+			return
+		}
+		defer tx.Rollback()
+
+		rep.counters.shadowRuns.Add(1)
+		same, err := fn(ctx, tx)
+		if err == nil {
+			if commitErr := tx.Commit(); commitErr != nil {
+				rep.logger.WarnContext(ctx, "replicache shadow commit failed", slog.String("kind", kind), slog.Any("err", commitErr))
+			}
+		}
+		if same {
+			return
+		}
+		rep.counters.shadowDivergences.Add(1)
+		rep.logger.WarnContext(ctx, "replicache shadow diverged",
+			slog.String("kind", kind),
+			slog.String("client_group_id", clientGroupID),
+			slog.Any("shadow_err", err),
+		)
+	}()
+}
+
+// patchHash returns the SHA-256 of the encoded patch of a pull response, or
+// nil if it has none.
+func patchHash(resp any) []byte {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return nil
+	}
+	var pr struct {
+		Patch json.RawMessage `json:"patch"`
+	}
+	if err := json.Unmarshal(b, &pr); err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(pr.Patch)
+	return sum[:]
+}
@@ -0,0 +1,72 @@
+package replicache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShadowRunsOncePerPushAfterCommit(t *testing.T) {
+	primary, db := newFakeDB(t)
+	shadowDB, sdb := newFakeDB(t)
+	attempts := 0
+	h := testHandler{push: func(context.Context, PushRequest) error {
+		attempts++
+		if attempts == 1 {
+			return sqlStateError("40001")
+		}
+		return nil
+	}}
+	type run struct {
+		mutations int
+		committed int
+	}
+	runs := make(chan run, 4)
+	shadowHandler := testHandler{push: func(_ context.Context, pr PushRequest) error {
+		_, commits, _ := primary.txCounts()
+		runs <- run{len(pr.Mutations), commits}
+		return nil
+	}}
+	rep := newTestReplicache(t, db, h,
+		WithClientOnPush(true),
+		WithPushRetry(3, func(int) time.Duration { return time.Millisecond }, 0),
+		WithShadowHandler(shadowHandler, 1),
+		WithShadowDB(sdb),
+		withClock(newFakeClock()),
+	)
+
+	w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m"), mutation("client", 2, "m")))
+	if w.Code != http.StatusOK || attempts != 2 {
+		t.Fatalf("push status = %d after %d attempts, want 200 after a retry", w.Code, attempts)
+	}
+	select {
+	case r := <-runs:
+		if r.committed == 0 {
+			t.Error("shadow ran before the primary transaction committed")
+		}
+		if r.mutations != 2 {
+			t.Errorf("shadow got %d mutations, want 2", r.mutations)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shadow didn't run")
+	}
+	select {
+	case <-runs:
+		t.Error("shadow ran more than once for one push")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if begins, _, _ := shadowDB.txCounts(); begins != 1 {
+		t.Errorf("shadow began %d transactions on the shadow database, want 1", begins)
+	}
+}
+
+func TestShadowRequiresSeparateDB(t *testing.T) {
+	_, db := newFakeDB(t)
+	if _, err := NewReplicache(db, testHandler{}, WithShadowHandler(testHandler{}, 1)); err == nil {
+		t.Error("WithShadowHandler without WithShadowDB was accepted")
+	}
+	if _, err := NewReplicache(db, testHandler{}, WithShadowHandler(testHandler{}, 1), WithShadowDB(db)); err == nil {
+		t.Error("the primary database was accepted as shadow database")
+	}
+}
@@ -0,0 +1,44 @@
+package replicache
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// queryName identifies a package query in slow query logs without exposing
+// its SQL.
+type queryName string
+
+const (
+	queryLoadClients         queryName = "load_clients"
+	queryCheckClientGroup    queryName = "check_client_group"
+	queryCreateClients       queryName = "create_clients"
+	querySaveLastMutationIDs queryName = "save_last_mutation_ids"
+	queryEnsureClientGroup   queryName = "ensure_client_group"
+	queryLastMutationChanges queryName = "last_mutation_id_changes"
+	queryRecordSync          queryName = "record_sync"
+)
+
+// observeQuery starts timing a query and returns the function that ends it,
+// logging the query if it took longer than the slow query threshold:
+//
+//	defer rep.observeQuery(ctx, queryLoadClients, clientGroupID)()
+func (rep *Replicache) observeQuery(ctx context.Context, name queryName, clientGroupID string) func() {
+	if rep.slowQueryThreshold <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		if elapsed < rep.slowQueryThreshold {
+			return
+		}
+		rep.counters.slowQueries.Add(1)
+		rep.logger.WarnContext(ctx, "replicache slow query",
+			slog.String("query", string(name)),
+			slog.Duration("duration", elapsed),
+			slog.String("client_group_id", clientGroupID),
+		)
+	}
+}
@@ -0,0 +1,126 @@
+package replicache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counters are shared by every copy of a Replicache returned by WithContext.
+type counters struct {
+	skippedMutations atomic.Int64
+	slowQueries      atomic.Int64
+	slowHooks        atomic.Int64
+	hooks            hookTimings
+
+	shadowRuns        atomic.Int64
+	shadowDivergences atomic.Int64
+	shadowDropped     atomic.Int64
+	futureCookies     atomic.Int64
+	pullWarnings      atomic.Int64
+	staleCookies      atomic.Int64
+
+	// schemaErrorLogged is when a schema error was last logged, in unix
+	// nanoseconds.
+	schemaErrorLogged atomic.Int64
+}
+
+// Stats is a point in time snapshot of counters maintained by the package.
+type Stats struct {
+	// CircuitState is the most severe state of the circuit breakers, one
+	// per routed database, and CircuitOpens the times any of them opened.
+	CircuitState CircuitState
+	CircuitOpens int64
+
+	// SkippedMutations counts mutations that were skipped because their ID
+	// had already been applied.
+	SkippedMutations int64
+
+	// SlowQueryCount counts package queries slower than the threshold set
+	// by WithSlowQueryThreshold.
+	SlowQueryCount int64
+
+	// SlowHookCount counts user hooks that ran longer than the timeout set
+	// by WithHookTimeout.
+	SlowHookCount int64
+
+	// Hooks holds the time spent in each user hook that ran, keyed by
+	// new_group, new_client, pre_rollback, schema_upgrade, quota and
+	// identity.
+	Hooks map[string]HookTiming
+
+	// ShadowRuns counts requests run through the shadow handler set by
+	// WithShadowHandler, ShadowDivergences those whose outcome differed
+	// from the primary handler's, and ShadowDropped sampled requests that
+	// were dropped because the shadow queue was full.
+	ShadowRuns        int64
+	ShadowDivergences int64
+	ShadowDropped     int64
+
+	// FutureVersionCount counts pulls whose cookie was ahead of the client
+	// group version and was reset by WithFutureCookieReset.
+	FutureVersionCount int64
+
+	// PullWarnings counts pull handler errors wrapped with Warning.
+	PullWarnings int64
+
+	// StaleCookies counts pulls whose cookie was older than the horizon set
+	// by WithCookieHorizon.
+	StaleCookies int64
+}
+
+func (rep *Replicache) Stats() Stats {
+	s := Stats{
+		SkippedMutations: rep.counters.skippedMutations.Load(),
+		SlowQueryCount:   rep.counters.slowQueries.Load(),
+		SlowHookCount:    rep.counters.slowHooks.Load(),
+		Hooks:            rep.counters.hooks.snapshot(),
+
+		ShadowRuns:        rep.counters.shadowRuns.Load(),
+		ShadowDivergences: rep.counters.shadowDivergences.Load(),
+		ShadowDropped:     rep.counters.shadowDropped.Load(),
+
+		FutureVersionCount: rep.counters.futureCookies.Load(),
+		PullWarnings:       rep.counters.pullWarnings.Load(),
+		StaleCookies:       rep.counters.staleCookies.Load(),
+	}
+	if rep.breakers != nil {
+		s.CircuitState, s.CircuitOpens = rep.breakers.snapshot()
+	}
+	return s
+}
+
+// HookTiming is the time spent in one user hook.
+type HookTiming struct {
+	Calls int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+type hookTimings struct {
+	mu      sync.Mutex
+	timings map[hookName]HookTiming
+}
+
+func (h *hookTimings) record(name hookName, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timings == nil {
+		h.timings = make(map[hookName]HookTiming)
+	}
+	t := h.timings[name]
+	t.Calls++
+	t.Total += d
+	t.Max = max(t.Max, d)
+	h.timings[name] = t
+}
+
+func (h *hookTimings) snapshot() map[string]HookTiming {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := make(map[string]HookTiming, len(h.timings))
+	for name, t := range h.timings {
+		s[string(name)] = t
+	}
+	return s
+}
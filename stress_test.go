@@ -0,0 +1,82 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentStress hammers push, pull and the admin calls from hundreds
+// of goroutines. Its value is in running it under -race.
+func TestConcurrentStress(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithDuplicateWindowSize(4),
+		WithClientStateCache(8),
+		WithCircuitBreaker(5, time.Minute, time.Second),
+	)
+	noop := func(context.Context, *sql.Tx, ClientInfo, Mutation) error { return nil }
+	router := rep.MustHandle(map[string]MutationHandlerFunc{"m": noop})
+	rep.pushHandler = router
+	ctx := context.Background()
+
+	const workers = 200
+	const rounds = 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			group := fmt.Sprintf("group-%d", w%16)
+			client := fmt.Sprintf("client-%d", w)
+			for i := 1; i <= rounds; i++ {
+				switch w % 8 {
+				case 0:
+					post(t, rep.PullHandler(), pullRequest(group, nil))
+				case 1:
+					rep.Stats()
+					rep.Maintenance()
+					rep.LastMutationIDs(ctx, group)
+				case 2:
+					rep.ForceLastMutationID(ctx, group, fmt.Sprintf("client-%d", w-1), int64(i))
+				case 3:
+					rep.Freeze()
+					if err := router.Register(fmt.Sprintf("late-%d-%d", w, i), noop); err != nil && !errors.Is(err, ErrRouterFrozen) {
+						t.Errorf("late registration: %v", err)
+					}
+				case 4:
+					rep.WithContext(ctx).ExportClientGroup(ctx, group)
+				default:
+					post(t, rep.PushHandler(), pushRequest(group, mutation(client, i, "m"), mutation(client, i, "m")))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := router.Register("after", noop); !errors.Is(err, ErrRouterFrozen) {
+		t.Errorf("registration after serving = %v, want ErrRouterFrozen", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	_, db := newFakeDB(t)
+	mr := NewMutationRouter()
+	// a router inside a handler chain is reached too
+	h := new(HandlerChain).Append(LoggingMiddleware(slog.Default())).Then(struct {
+		*MutationRouter
+		pullFunc
+	}{mr, func(context.Context, PullRequest) (any, error) { return nil, nil }})
+	rep := newTestReplicache(t, db, h)
+	rep.Freeze()
+	rep.Freeze()
+	if err := mr.Register("m", func(context.Context, *sql.Tx, ClientInfo, Mutation) error { return nil }); !errors.Is(err, ErrRouterFrozen) {
+		t.Errorf("Register after Freeze = %v, want ErrRouterFrozen", err)
+	}
+}
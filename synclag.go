@@ -0,0 +1,71 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SyncLag describes how far a client group's pulls trail its pushes.
+type SyncLag struct {
+	LastPush time.Time
+	LastPull time.Time
+
+	// Lag is how long the last push has gone without a pull, or zero if
+	// the group pulled after it.
+	Lag time.Duration
+}
+
+// SyncLag returns the sync lag of a client group. Timestamps are only
+// recorded when WithSyncLagTracking is set, and are zero for a group that
+// never pushed or pulled.
+func (rep *Replicache) SyncLag(ctx context.Context, clientGroupID string) (SyncLag, error) {
+	rep, err := rep.routeGroup(ctx, clientGroupID)
+	if err != nil {
+		return SyncLag{}, err
+	}
+	var lastPush, lastPull sql.NullTime
+	err = rep.db.QueryRowContext(ctx,
+		`SELECT last_push_at, last_pull_at FROM replicache_version WHERE client_group_id = $1`,
+		clientGroupID,
+	).Scan(&lastPush, &lastPull)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return SyncLag{}, nil
+	case err != nil:
+		return SyncLag{}, err
+	}
+
+	lag := SyncLag{LastPush: lastPush.Time, LastPull: lastPull.Time}
+	if lastPush.Valid && lag.LastPush.After(lag.LastPull) {
+		lag.Lag = rep.clock.Now().Sub(lag.LastPush)
+	}
+	return lag, nil
+}
+
+// recordSync stores the time of a committed push or a served pull for the
+// client group. It runs outside the request transaction so concurrent pulls
+// don't conflict on the group row, and failures are only logged. It only
+// updates groups that exist, so a pull never creates the group row its first
+// push is meant to create. The time comes from the server clock and never
+// moves backward, so a server whose clock steps back can't make an active
+// group look idle.
+func (rep *Replicache) recordSync(ctx context.Context, clientGroupID, column string) {
+	if !rep.syncLagTracking {
+		return
+	}
+	defer rep.observeQuery(ctx, queryRecordSync, clientGroupID)()
+	_, err := rep.db.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE replicache_version SET %[1]s = GREATEST(%[1]s, $2) WHERE client_group_id = $1`, column),
+		clientGroupID, rep.clock.Now(),
+	)
+	if err != nil {
+		rep.logger.WarnContext(ctx, "replicache sync lag update failed",
+			slog.String("client_group_id", clientGroupID),
+			slog.Any("err", err),
+		)
+	}
+}
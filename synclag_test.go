@@ -0,0 +1,96 @@
+package replicache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSyncLagFirstPullLeavesGroupToPush(t *testing.T) {
+	f, db := newFakeDB(t)
+	var created []string
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithSyncLagTracking(),
+		WithNewGroupHook(func(_ context.Context, info ClientInfo) {
+			created = append(created, info.ClientGroupID)
+		}),
+	)
+
+	if w := post(t, rep.PullHandler(), pullRequest("group", nil)); w.Code != http.StatusOK {
+		t.Fatalf("pull status = %d: %s", w.Code, w.Body)
+	}
+	if n := f.count(`INSERT INTO replicache_version`); n != 0 {
+		t.Errorf("first pull inserted the group row %d times, want none", n)
+	}
+
+	// the first push creates the group with its profile
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("client", 1, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	if len(created) != 1 {
+		t.Errorf("new group hook ran for %q, want once", created)
+	}
+	if g, ok := f.group("group"); !ok || g.profileID != "profile" {
+		t.Errorf("group = %+v, want it created with its profile", g)
+	}
+	lag, err := rep.SyncLag(context.Background(), "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag.LastPush.IsZero() {
+		t.Error("push time wasn't recorded")
+	}
+
+	// and later pulls are recorded
+	if w := post(t, rep.PullHandler(), pullRequest("group", nil)); w.Code != http.StatusOK {
+		t.Fatalf("pull status = %d: %s", w.Code, w.Body)
+	}
+	if lag, _ := rep.SyncLag(context.Background(), "group"); lag.LastPull.IsZero() || lag.Lag != 0 {
+		t.Errorf("sync lag after pulling = %+v, want the pull recorded and no lag", lag)
+	}
+}
+
+func TestActiveGroupSurvivesClockJumps(t *testing.T) {
+	f, db := newFakeDB(t)
+	clock := newFakeClock()
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithSyncLagTracking(),
+		WithDuplicateWindowSize(4),
+		withClock(clock),
+	)
+	ttl := defaultDuplicateWindowTTL
+	ids := make(map[string]int)
+	push := func(client string) {
+		t.Helper()
+		ids[client]++
+		if w := post(t, rep.PushHandler(), pushRequest("group-"+client, mutation(client, ids[client], "m"))); w.Code != http.StatusOK {
+			t.Fatalf("push status = %d: %s", w.Code, w.Body)
+		}
+	}
+
+	push("active")
+	push("idle")
+	latest := clock.Now()
+
+	// the clock steps back across the purge boundary and forward again,
+	// while the active group keeps syncing
+	for _, jump := range []time.Duration{-2 * ttl, ttl + time.Minute, 2 * ttl, -ttl / 2, ttl + time.Minute} {
+		clock.Advance(jump)
+		push("active")
+		if !rep.duplicates.Seen("active", int64(ids["active"])) {
+			t.Fatalf("after a jump of %v, the window of the active client was purged", jump)
+		}
+		if clock.Now().After(latest) {
+			latest = clock.Now()
+		}
+		if g, _ := f.group("group-active"); !g.lastPushAt.Equal(latest) {
+			t.Fatalf("after a jump of %v, the last push is %v, want the latest %v", jump, g.lastPushAt, latest)
+		}
+	}
+	if rep.duplicates.Seen("idle", 1) {
+		t.Error("window of the idle client outlived its TTL")
+	}
+}
@@ -0,0 +1,178 @@
+package replicache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// MutationStatus is the outcome of a single mutation in a ValidationReport.
+type MutationStatus string
+
+const (
+	MutationApplied  MutationStatus = "applied"
+	MutationSkipped  MutationStatus = "skipped"
+	MutationRejected MutationStatus = "rejected"
+	MutationFailed   MutationStatus = "failed"
+)
+
+// MutationOutcome reports what a push would do with one mutation.
+type MutationOutcome struct {
+	ClientID string         `json:"clientID"`
+	ID       int            `json:"id"`
+	Status   MutationStatus `json:"status"`
+
+	// Err is set when Status is MutationFailed.
+	Err   *MutationError `json:"-"`
+	Error string         `json:"error,omitempty"`
+}
+
+// ValidationReport is the result of Validate. Result totals the outcomes the
+// way a real push would report them.
+type ValidationReport struct {
+	Result    PushResult        `json:"-"`
+	Mutations []MutationOutcome `json:"mutations"`
+}
+
+// ValidateHandler accepts push requests and answers with the
+// ValidationReport of the mutations instead of applying them. See Validate.
+func (rep *Replicache) ValidateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rep.pushHandler == nil {
+			rep.respond(w, r, http.StatusMethodNotAllowed, nil, ErrNoHandler)
+			return
+		}
+		if !rep.allowMethod(w, r, rep.pushMethods) {
+			return
+		}
+		req := struct {
+			PushVersion   int        `json:"pushVersion"`
+			ClientGroupID string     `json:"clientGroupID"`
+			Mutations     []Mutation `json:"mutations"`
+			ProfileID     string     `json:"profileID"`
+			SchemaVersion string     `json:"schemaVersion"`
+		}{}
+		if err := rep.decodeRequestBody(r); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rep.writeDecodeError(w, r, err)
+			return
+		}
+		info := ClientInfo{
+			Auth:          r.Header.Get("Authorization"),
+			ClientGroupID: req.ClientGroupID,
+			ProfileID:     req.ProfileID,
+			SchemaVersion: req.SchemaVersion,
+		}
+		rep.normalizeIDs(&info, req.Mutations)
+		if err := rep.checkPushVersion(req.PushVersion); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		if err := rep.checkClientInfoLengths(info); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		if err := rep.checkClientIDLengths(req.Mutations); err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		report, err := rep.Validate(r.Context(), info, req.Mutations)
+		if err != nil {
+			rep.writeError(w, r, err)
+			return
+		}
+		rep.respond(w, r, http.StatusOK, report, nil)
+	})
+}
+
+// Validate runs mutations through the push pipeline, including the handler
+// and the quota checker, in a transaction that is always rolled back, and
+// reports the outcome of each mutation. Each mutation runs in its own
+// savepoint so a failure doesn't hide the outcome of the ones after it;
+// later mutations from the client of a failed one fail as out of order, as
+// they would in a real push. The identity change policy is applied too, so a
+// push that would be rejected or reset fails the same way. Nothing is
+// committed: last mutation IDs don't advance, hooks don't run, and neither
+// caches nor stats are updated.
+func (rep *Replicache) Validate(ctx context.Context, info ClientInfo, mutations []Mutation) (ValidationReport, error) {
+	if rep.pushHandler == nil {
+		return ValidationReport{}, ErrNoHandler
+	}
+	if err := rep.applyAnonymousPolicy(&info); err != nil {
+		return ValidationReport{}, err
+	}
+	rep, err := rep.routeDB(info)
+	if err != nil {
+		return ValidationReport{}, err
+	}
+	if err := rep.checkClientSchemaVersion(info.SchemaVersion); err != nil {
+		return ValidationReport{}, err
+	}
+	if mutations, err = rep.canonicalizeMutations(mutations); err != nil {
+		return ValidationReport{}, err
+	}
+	// a dry run must not reach a shadow database, the client cache or the
+	// stats either
+	dry := *rep
+	dry.shadow = nil
+	dry.clientCache = nil
+	dry.counters = &counters{}
+
+	tx, err := dry.beginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return ValidationReport{}, err
+	}
+	defer tx.Rollback()
+
+	if reset, err := dry.checkIdentity(ctx, tx, info); err != nil {
+		return ValidationReport{}, err
+	} else if reset {
+		return ValidationReport{}, fmt.Errorf("%w: client group %s would be reset after identity change", ErrClientNotFound, info.ClientGroupID)
+	}
+
+	report := ValidationReport{
+		Result: PushResult{
+			ClientGroupID:   info.ClientGroupID,
+			ProfileID:       info.ProfileID,
+			LastMutationIDs: make(map[string]int64),
+		},
+		Mutations: make([]MutationOutcome, 0, len(mutations)),
+	}
+	for _, m := range mutations {
+		outcome := MutationOutcome{ClientID: m.ClientID, ID: m.ID}
+		if err := savepoint(ctx, tx, "replicache_validate"); err != nil {
+			return ValidationReport{}, err
+		}
+		result, err := dry.applyPush(ctx, tx, info, []Mutation{m}, nil)
+		if err != nil {
+			if rbErr := rollbackToSavepoint(ctx, tx, "replicache_validate"); rbErr != nil {
+				return ValidationReport{}, rbErr
+			}
+			outcome.Status = MutationFailed
+			errors.As(wrapMutationError(err, m, info.ClientGroupID), &outcome.Err)
+			outcome.Error = outcome.Err.Error()
+			report.Mutations = append(report.Mutations, outcome)
+			continue
+		}
+		if err := releaseSavepoint(ctx, tx, "replicache_validate"); err != nil {
+			return ValidationReport{}, err
+		}
+		switch {
+		case result.Applied > 0:
+			outcome.Status = MutationApplied
+		case len(result.Rejected) > 0:
+			outcome.Status = MutationRejected
+		default:
+			outcome.Status = MutationSkipped
+		}
+		report.Result.add(result)
+		report.Mutations = append(report.Mutations, outcome)
+	}
+	return report, nil
+}
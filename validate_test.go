@@ -0,0 +1,151 @@
+package replicache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{push: func(_ context.Context, pr PushRequest) error {
+		if pr.Mutations[0].Name == "fail" {
+			return errors.New("boom")
+		}
+		return nil
+	}}, WithClientOnPush(true))
+	if w := post(t, rep.PushHandler(), pushRequest("group", mutation("a", 1, "m"))); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	skipped := rep.Stats().SkippedMutations
+	begins, commits, _ := f.txCounts()
+
+	info := ClientInfo{ClientGroupID: "group", ProfileID: "profile"}
+	report, err := rep.Validate(context.Background(), info, []Mutation{
+		mutation("a", 1, "m"),
+		mutation("a", 2, "m"),
+		mutation("b", 1, "fail"),
+		mutation("b", 2, "m"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MutationStatus{MutationSkipped, MutationApplied, MutationFailed, MutationFailed}
+	if len(report.Mutations) != len(want) {
+		t.Fatalf("report has %d outcomes, want %d", len(report.Mutations), len(want))
+	}
+	for i, o := range report.Mutations {
+		if o.Status != want[i] {
+			t.Errorf("mutation %s/%d status = %s, want %s", o.ClientID, o.ID, o.Status, want[i])
+		}
+	}
+	if report.Result.Applied != 1 || report.Result.Skipped != 1 {
+		t.Errorf("result applied %d and skipped %d, want 1 each", report.Result.Applied, report.Result.Skipped)
+	}
+
+	if n, c, _ := f.txCounts(); n-begins != 1 || c != commits {
+		t.Errorf("validate began %d and committed %d transactions, want 1 and none", n-begins, c-commits)
+	}
+	if c, _ := f.client("group", "a"); c.lastMutationID != 1 {
+		t.Errorf("last mutation ID = %d after validate, want 1", c.lastMutationID)
+	}
+	if _, ok := f.client("group", "b"); ok {
+		t.Error("validate created a client")
+	}
+	if n := rep.Stats().SkippedMutations; n != skipped {
+		t.Errorf("validate counted %d skipped mutations", n-skipped)
+	}
+}
+
+func TestValidateIdentity(t *testing.T) {
+	for _, tt := range []struct {
+		policy IdentityChangePolicy
+		want   error
+	}{
+		{IdentityChangeReject, ErrIdentityChanged},
+		{IdentityChangeReset, ErrClientNotFound},
+	} {
+		f, db := newFakeDB(t)
+		rep := newTestReplicache(t, db, testHandler{},
+			WithClientOnPush(true),
+			WithIdentityChangePolicy(sessionIdentity, tt.policy),
+		)
+		if w := postAs(t, rep.PushHandler(), "alice@1", pushRequest("group", mutation("client", 1, "m"))); w.Code != http.StatusOK {
+			t.Fatalf("alice's push status = %d: %s", w.Code, w.Body)
+		}
+
+		info := ClientInfo{Auth: "bob@2", ClientGroupID: "group", ProfileID: "profile"}
+		_, err := rep.Validate(context.Background(), info, []Mutation{mutation("client", 2, "m")})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("policy %v: validate as bob got %v, want %v", tt.policy, err, tt.want)
+		}
+		if g, ok := f.group("group"); !ok || g.owner != "alice" {
+			t.Errorf("policy %v: group after validate = %+v, %v, want it bound to alice", tt.policy, g, ok)
+		}
+	}
+}
+
+func TestValidateKeepsClientCache(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithClientStateCache(10),
+		WithMaxClientGroupsPerProfile(1, GroupLimitEvictOldest),
+	)
+	req := pushRequest("a", mutation("client", 1, "m"))
+	if w := post(t, rep.PushHandler(), req); w.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+
+	// a new group of the profile evicts a within the rolled back transaction
+	info := ClientInfo{ClientGroupID: "b", ProfileID: "profile"}
+	if _, err := rep.Validate(context.Background(), info, []Mutation{mutation("client2", 1, "m")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.group("a"); !ok {
+		t.Fatal("validate evicted a")
+	}
+	begins, _, _ := f.txCounts()
+	if w := post(t, rep.PushHandler(), req); w.Code != http.StatusOK {
+		t.Fatalf("re-sent push status = %d: %s", w.Code, w.Body)
+	}
+	if n, _, _ := f.txCounts(); n != begins {
+		t.Error("validate invalidated the cached state of the group it would evict")
+	}
+}
+
+func TestValidateHandler(t *testing.T) {
+	f, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{}, WithClientOnPush(true))
+
+	w := post(t, rep.ValidateHandler(), pushRequest("group", mutation("client", 1, "m"), mutation("client", 3, "m")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("validate status = %d: %s", w.Code, w.Body)
+	}
+	var report ValidationReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	want := []MutationStatus{MutationApplied, MutationFailed}
+	if len(report.Mutations) != len(want) {
+		t.Fatalf("report = %s, want %d outcomes", w.Body, len(want))
+	}
+	for i, o := range report.Mutations {
+		if o.Status != want[i] {
+			t.Errorf("mutation %d status = %s, want %s", o.ID, o.Status, want[i])
+		}
+	}
+	if report.Mutations[1].Error == "" {
+		t.Error("failed mutation has no error")
+	}
+	if _, ok := f.group("group"); ok {
+		t.Error("validate created the client group")
+	}
+
+	if w := post(t, rep.ValidateHandler(), `{"pushVersion":0}`); !strings.Contains(w.Body.String(), "VersionNotSupported") {
+		t.Errorf("validate of an unsupported push version got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,51 @@
+package replicache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrVersionNotSupported = errors.New("replicache: version not supported")
+
+// VersionNotSupportedError is returned for requests using a push or pull
+// protocol version that isn't enabled. It is sent to the client as a
+// VersionNotSupported response.
+type VersionNotSupportedError struct {
+	// VersionType is "push", "pull" or "schema".
+	VersionType string
+
+	// Version is the push or pull version of the request.
+	Version int
+
+	// SchemaVersion is the schema version of the request when VersionType
+	// is "schema".
+	SchemaVersion string
+}
+
+func (e *VersionNotSupportedError) Error() string {
+	if e.VersionType == string(VersionTypeSchema) {
+		return fmt.Sprintf("%s: schema version %q", ErrVersionNotSupported, e.SchemaVersion)
+	}
+	return fmt.Sprintf("%s: %s version %d", ErrVersionNotSupported, e.VersionType, e.Version)
+}
+
+func (e *VersionNotSupportedError) Unwrap() error { return ErrVersionNotSupported }
+
+func (e *VersionNotSupportedError) response() any {
+	return VersionNotSupportedResponse{VersionType: VersionType(e.VersionType)}
+}
+
+func (rep *Replicache) checkPushVersion(version int) error {
+	if !slices.Contains(rep.pushVersions, version) {
+		return &VersionNotSupportedError{VersionType: string(VersionTypePush), Version: version}
+	}
+	return nil
+}
+
+func (rep *Replicache) checkPullVersion(version int) error {
+	if !slices.Contains(rep.pullVersions, version) {
+		return &VersionNotSupportedError{VersionType: string(VersionTypePull), Version: version}
+	}
+	return nil
+}
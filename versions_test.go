@@ -0,0 +1,56 @@
+package replicache
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestProtocolVersions(t *testing.T) {
+	_, db := newFakeDB(t)
+	rep := newTestReplicache(t, db, testHandler{},
+		WithClientOnPush(true),
+		WithProtocolVersions([]int{0, 1}, []int{1}),
+	)
+
+	for _, tc := range []struct {
+		name        string
+		handler     http.Handler
+		body        any
+		versionType VersionType
+	}{
+		{"push v0", rep.PushHandler(), testPush{PushVersion: 0, ClientGroupID: "group", Mutations: []Mutation{}}, ""},
+		{"push v1", rep.PushHandler(), testPush{PushVersion: 1, ClientGroupID: "group", Mutations: []Mutation{}}, ""},
+		{"push v2", rep.PushHandler(), testPush{PushVersion: 2, ClientGroupID: "group", Mutations: []Mutation{}}, VersionTypePush},
+		{"pull v0", rep.PullHandler(), testPull{PullVersion: 0, ClientGroupID: "group"}, VersionTypePull},
+		{"pull v1", rep.PullHandler(), testPull{PullVersion: 1, ClientGroupID: "group"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := post(t, tc.handler, tc.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			var resp VersionNotSupportedResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if tc.versionType == "" {
+				if err == nil {
+					t.Errorf("accepted version got %s", w.Body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rejected version got %s, want VersionNotSupported: %v", w.Body, err)
+			}
+			if resp.VersionType != tc.versionType {
+				t.Errorf("versionType = %q, want %q", resp.VersionType, tc.versionType)
+			}
+		})
+	}
+}
+
+func TestProtocolVersionsRequireOne(t *testing.T) {
+	_, db := newFakeDB(t)
+	if _, err := NewReplicache(db, testHandler{}, WithProtocolVersions(nil, []int{1})); err == nil {
+		t.Error("NewReplicache accepted no push versions")
+	}
+}